@@ -0,0 +1,90 @@
+// kubectl-mapi2capi converts the MAPI machine sets of a cluster to CAPI and
+// prints, diffs or server-side applies the result. Installed on the PATH it
+// runs as `kubectl mapi2capi` or `oc mapi2capi`.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/converter"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/plugin"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const usage = `Usage: kubectl mapi2capi print|diff|apply [flags] [machineset...]
+
+Converts the MAPI machine sets of the namespace, or only the named ones, to
+CAPI machine sets and AWSMachineTemplates. The CAPI machine sets carry the
+cluster.x-k8s.io/paused annotation so they don't create machines next to the
+MAPI ones, remove it once the MAPI machine sets are scaled down.
+
+  print  writes the converted objects as YAML
+  diff   writes a unified diff between the live and converted objects
+  apply  server-side applies the converted objects, AWSMachineTemplates whose
+         spec changed are deleted and recreated
+
+Flags:
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("kubectl-mapi2capi", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	kubeconfig := flags.String("kubeconfig", "", "kubeconfig file path, defaults to the KUBECONFIG environment variable or ~/.kube/config")
+	namespace := flags.String("n", "openshift-machine-api", "namespace of the mapi machine sets")
+	targetNamespace := flags.String("target-namespace", "", "namespace of the converted objects, defaults to the namespace of the mapi machine sets")
+	region := flags.String("region", "", "aws region, defaults to the region of the availability zone")
+	propagateOwnerReferences := flags.Bool("propagate-owner-references", false, "copy ownerReferences to owners outside the machine API onto the converted machine sets")
+	forceConflicts := flags.Bool("force-conflicts", false, "take over fields owned by other field managers when applying")
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		flags.Usage()
+		return nil
+	}
+	mode := plugin.Mode(args[0])
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("error loading kubeconfig: %v", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("error creating client: %v", err)
+	}
+
+	return plugin.Run(context.Background(), plugin.Options{
+		Client:          client,
+		Namespace:       *namespace,
+		TargetNamespace: *targetNamespace,
+		Names:           flags.Args(),
+		Converter: converter.AWSConverter{
+			Region: *region,
+			MetadataPropagation: converter.MetadataPropagation{
+				PropagateOwnerReferences: *propagateOwnerReferences,
+			},
+		},
+		Mode:           mode,
+		ForceConflicts: *forceConflicts,
+		Out:            os.Stdout,
+		ErrOut:         os.Stderr,
+	})
+}
@@ -1,12 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/controller"
 	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/converter"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/diff"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/plan"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/webhook"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
 )
 
 var (
@@ -14,6 +31,45 @@ var (
 	inputMachineTemplateFilePath string
 	conversionApiType            string
 	cloudProviderName            string
+	imageCatalogFilePath         string
+	region                       string
+	streamMetadataFilePath       string
+	targetRegion                 string
+	targetAvailabilityZone       string
+	targetSubnet                 string
+	architecture                 string
+	bootstrapFormat              string
+	userDataFilePath             string
+	secretsBackend               string
+	uncompressedUserData         bool
+	ignitionVersion              string
+	ignitionStorageType          string
+	labelTranslationsFilePath    string
+	machineAutoscalerFilePath    string
+	machineAutoscalerOutput      string
+	machineHealthCheckFilePath   string
+	metadataAllowPrefixes        string
+	metadataDenyPrefixes         string
+	propagateOwnerReferences     bool
+	nodeMetadataStrategy         string
+	nodeDrainTimeout             time.Duration
+	machinesFilePath             string
+	includeStatus                bool
+	ec2InstancesFilePath         string
+	ec2VolumesFilePath           string
+	ec2InstanceID                string
+	templateName                 string
+	templateNamespace            string
+	outputFormat                 string
+	webhookAddr                  string
+	tlsCertFilePath              string
+	tlsKeyFilePath               string
+	mirror                       bool
+	mirrorNamespace              string
+	diffAgainstFilePath          string
+	diffOutput                   string
+	maxSurge                     string
+	maxUnavailable               string
 )
 
 func init() {
@@ -21,24 +77,93 @@ func init() {
 	flag.StringVar(&inputMachineTemplateFilePath, "input-machine-template", "mtmpl.yaml", "input machine template file path")
 	flag.StringVar(&conversionApiType, "api", "", "api type to covert to, can be either capi or mapi")
 	flag.StringVar(&cloudProviderName, "provider", "", "cloud provider name, can be aws, azure, gcp, vsphere")
+	flag.StringVar(&imageCatalogFilePath, "image-catalog", "", "image catalog or CoreOS stream metadata file path, used to resolve image lookups")
+	flag.StringVar(&region, "region", "", "cloud provider region, defaults to the region of the availability zone")
+	flag.StringVar(&streamMetadataFilePath, "stream-metadata", "", "CoreOS stream metadata file path, used to rewrite AMIs for the target region")
+	flag.StringVar(&targetRegion, "target-region", "", "region the converted machines run in, when it differs from the source region -target-availability-zone is required, and -target-subnet too if the machines set a subnet, AMIs are only rewritten for it with -stream-metadata, otherwise the source AMI is kept")
+	flag.StringVar(&targetAvailabilityZone, "target-availability-zone", "", "availability zone of the machines in the target region, required when it differs from the source region")
+	flag.StringVar(&targetSubnet, "target-subnet", "", "subnet ID of the machines in the target region, required when it differs from the source region")
+	flag.StringVar(&architecture, "arch", "", "image architecture to pick from the stream metadata and the image catalog, defaults to the architecture of the source AMI or of the instance type")
+	flag.StringVar(&bootstrapFormat, "bootstrap-format", "", "bootstrap format of the capi machines, can be either cloud-init or ignition, defaults to the format of the user data")
+	flag.StringVar(&userDataFilePath, "input-user-data", "", "user data secret file path, used to detect the bootstrap format")
+	flag.StringVar(&secretsBackend, "secrets-backend", "", "cloud-init secrets backend, can be either secrets-manager or ssm-parameter-store")
+	flag.BoolVar(&uncompressedUserData, "uncompressed-user-data", false, "don't gzip cloud-init user data")
+	flag.StringVar(&ignitionVersion, "ignition-version", "", "ignition version, defaults to the version of the user data")
+	flag.StringVar(&ignitionStorageType, "ignition-storage-type", "", "ignition user data storage, can be either ClusterObjectStore or UnencryptedUserData")
+	flag.StringVar(&labelTranslationsFilePath, "label-translations", "", "label translation table file path, defaults to translating the cluster and machineset labels")
+	flag.StringVar(&machineAutoscalerFilePath, "input-machine-autoscaler", "", "input machine autoscaler file path")
+	flag.StringVar(&machineAutoscalerOutput, "machine-autoscaler-output", "", "how to convert the machine autoscaler to capi, can be either annotations or machineautoscaler, defaults to annotations")
+	flag.StringVar(&machineHealthCheckFilePath, "input-machine-health-check", "", "input machine health check file path")
+	flag.StringVar(&metadataAllowPrefixes, "metadata-allow-prefixes", "", "comma separated label and annotation key prefixes to propagate, defaults to all")
+	flag.StringVar(&metadataDenyPrefixes, "metadata-deny-prefixes", "", "comma separated label and annotation key prefixes not to propagate")
+	flag.BoolVar(&propagateOwnerReferences, "propagate-owner-references", false, "copy ownerReferences to owners outside the source machine API onto the converted machine set, only when applying it next to its owners")
+	flag.StringVar(&nodeMetadataStrategy, "node-metadata", "", "what to do with node labels, annotations and taints when converting to capi, can be report, annotations or error, defaults to report")
+	flag.DurationVar(&nodeDrainTimeout, "node-drain-timeout", 0, "how long capi machines are drained before deletion, defaults to draining until all pods are evicted")
+	flag.StringVar(&machinesFilePath, "input-machines", "", "input machine or machine list file path, the machines must belong to the input machine set")
+	flag.BoolVar(&includeStatus, "include-status", false, "convert the status of the machine set and machines too")
+	flag.StringVar(&ec2InstancesFilePath, "input-ec2-instances", "", "aws ec2 describe-instances output file path, imports an instance instead of converting a machine set")
+	flag.StringVar(&ec2VolumesFilePath, "input-ec2-volumes", "", "aws ec2 describe-volumes output file path of the imported instance volumes")
+	flag.StringVar(&ec2InstanceID, "ec2-instance-id", "", "id of the instance to import, required when the describe-instances output has more than one instance")
+	flag.StringVar(&templateName, "name", "", "name of the imported machine template, defaults to the instance id")
+	flag.StringVar(&templateNamespace, "namespace", "", "namespace of the imported machine template")
+	flag.StringVar(&outputFormat, "output-format", "", "output format, can be yaml, run-instances or launch-template, the ec2 request formats render the input machine spec, defaults to yaml")
+	flag.StringVar(&webhookAddr, "webhook-addr", "", "serve the converter as an experimental ConversionReview endpoint on this address instead of converting files, e.g. :9443, it can't be registered as the conversion webhook of a CRD")
+	flag.StringVar(&tlsCertFilePath, "tls-cert-file", "", "webhook serving certificate file path")
+	flag.StringVar(&tlsKeyFilePath, "tls-key-file", "", "webhook serving key file path")
+	flag.BoolVar(&mirror, "mirror", false, "run a controller mirroring the cluster's mapi machine sets into paused capi machine sets instead of converting files")
+	flag.StringVar(&mirrorNamespace, "mirror-namespace", "", "namespace of the mirrored capi objects, defaults to the namespace of the mapi machine set")
+	flag.StringVar(&diffAgainstFilePath, "diff-against", "", "existing objects file path, compares them with the converted objects instead of writing output files and exits with 1 when they differ")
+	flag.StringVar(&diffOutput, "diff-output", "", "diff output format, can be either text or json, defaults to text")
+	flag.StringVar(&maxSurge, "max-surge", "1", "plan: machines, or percentage of the replicas, that may exist on top of the replicas while migrating")
+	flag.StringVar(&maxUnavailable, "max-unavailable", "0", "plan: machines, or percentage of the replicas, that may be unavailable while migrating")
 }
 
 func main() {
+	// `converter plan [flags]` writes a migration plan instead of converting. A
+	// MachineAutoscaler scaling the machine set has to be passed with
+	// -input-machine-autoscaler and -machine-autoscaler-output=machineautoscaler.
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		flag.CommandLine.Parse(os.Args[2:])
+		if err := writePlan(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	// `converter explain aws <field>` explains how a field is converted.
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		if err := explainField(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	flag.Parse()
 
-	fmt.Printf("Converting from %s, for cloud provider: %s\n", conversionApiType, cloudProviderName)
+	if webhookAddr != "" {
+		if err := serveWebhook(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if mirror {
+		if err := runMirror(); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-	inputMachineSet, err := ioutil.ReadFile(inputMachineSetFilePath)
-	if err != nil {
-		panic("can't read machine yaml")
+	if diffAgainstFilePath == "" {
+		fmt.Printf("Converting from %s, for cloud provider: %s\n", conversionApiType, cloudProviderName)
 	}
 
-	inputMachineTemplate, err := ioutil.ReadFile(inputMachineTemplateFilePath)
-	if err != nil {
-		panic("can't read machine yaml")
+	extension := "yaml"
+	if outputFormat != "" && outputFormat != string(converter.OutputFormatYAML) {
+		extension = "json"
 	}
 
-	converter, err := setupConverter(cloudProviderName, inputMachineSet, inputMachineTemplate)
+	converter, err := setupInputConverter()
 	if err != nil {
 		panic(err)
 	}
@@ -48,21 +173,57 @@ func main() {
 		panic(err)
 	}
 
+	if diffAgainstFilePath != "" {
+		if report := converter.Report(); len(report.Entries) > 0 {
+			fmt.Fprintf(os.Stderr, "Some fields could not be converted faithfully:\n%s", report.String())
+		}
+		drifted, err := diffConverted(convertedTypes)
+		if err != nil {
+			panic(err)
+		}
+		if drifted {
+			os.Exit(1)
+		}
+		return
+	}
+
 	for i, convertedType := range convertedTypes {
-		err = ioutil.WriteFile(fmt.Sprintf("output-%d.yaml", i), convertedType, 0644)
+		err = ioutil.WriteFile(fmt.Sprintf("output-%d.%s", i, extension), convertedType, 0644)
 		if err != nil {
 			panic(err)
 		}
 	}
+
+	report := converter.Report()
+	if len(report.Entries) > 0 {
+		fmt.Printf("Some fields could not be converted faithfully:\n%s", report.String())
+	}
+}
+
+// setupInputConverter imports an EC2 instance when one is given and converts
+// the input machine set otherwise.
+func setupInputConverter() (converter.Converter, error) {
+	if ec2InstancesFilePath != "" {
+		return setupEC2Importer(cloudProviderName)
+	}
+
+	inputMachineSet, err := ioutil.ReadFile(inputMachineSetFilePath)
+	if err != nil {
+		panic("can't read machine yaml")
+	}
+
+	inputMachineTemplate, err := ioutil.ReadFile(inputMachineTemplateFilePath)
+	if err != nil {
+		panic("can't read machine yaml")
+	}
+
+	return setupConverter(cloudProviderName, inputMachineSet, inputMachineTemplate)
 }
 
 func setupConverter(cloudProviderName string, inputMachineSet, inputMachineTemplate []byte) (converter.Converter, error) {
 	switch cloudProviderName {
 	case "aws":
-		return &converter.AWSConverter{
-			MachineSetFile:      inputMachineSet,
-			MachineTemplateFile: inputMachineTemplate,
-		}, nil
+		return setupAWSConverter(inputMachineSet, inputMachineTemplate)
 	// case "gcp":
 	// case "azure":
 	// case "vsphere":
@@ -70,3 +231,314 @@ func setupConverter(cloudProviderName string, inputMachineSet, inputMachineTempl
 		return nil, errors.New("unkown cloud provider name")
 	}
 }
+
+func setupAWSConverter(inputMachineSet, inputMachineTemplate []byte) (*converter.AWSConverter, error) {
+	imageCatalog, err := readOptionalFile(imageCatalogFilePath, "image catalog")
+	if err != nil {
+		return nil, err
+	}
+
+	streamMetadata, err := readOptionalFile(streamMetadataFilePath, "stream metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	bootstrap, err := setupBootstrapOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	var labelTranslations converter.LabelTranslations
+	if labelTranslationsFilePath != "" {
+		labelTranslationsFile, err := readOptionalFile(labelTranslationsFilePath, "label translations")
+		if err != nil {
+			return nil, err
+		}
+		labelTranslations, err = converter.ParseLabelTranslations(labelTranslationsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	machineAutoscaler, err := readOptionalFile(machineAutoscalerFilePath, "machine autoscaler")
+	if err != nil {
+		return nil, err
+	}
+
+	machineHealthCheck, err := readOptionalFile(machineHealthCheckFilePath, "machine health check")
+	if err != nil {
+		return nil, err
+	}
+
+	machines, err := readOptionalFile(machinesFilePath, "machines")
+	if err != nil {
+		return nil, err
+	}
+
+	awsConverter := &converter.AWSConverter{
+		MachineSetFile:         inputMachineSet,
+		MachineTemplateFile:    inputMachineTemplate,
+		ImageCatalogFile:       imageCatalog,
+		Region:                 region,
+		StreamMetadataFile:     streamMetadata,
+		TargetRegion:           targetRegion,
+		TargetAvailabilityZone: targetAvailabilityZone,
+		TargetSubnet:           targetSubnet,
+		Architecture:           architecture,
+		Bootstrap:              bootstrap,
+		LabelTranslations:      labelTranslations,
+
+		MachineAutoscalerFile:   machineAutoscaler,
+		MachineAutoscalerOutput: converter.MachineAutoscalerOutput(machineAutoscalerOutput),
+		MachineHealthCheckFile:  machineHealthCheck,
+
+		MetadataPropagation: converter.MetadataPropagation{
+			AllowPrefixes:            splitList(metadataAllowPrefixes),
+			DenyPrefixes:             splitList(metadataDenyPrefixes),
+			PropagateOwnerReferences: propagateOwnerReferences,
+		},
+		NodeMetadata:  converter.NodeMetadataStrategy(nodeMetadataStrategy),
+		MachinesFile:  machines,
+		IncludeStatus: includeStatus,
+		OutputFormat:  converter.OutputFormat(outputFormat),
+	}
+	if nodeDrainTimeout > 0 {
+		awsConverter.NodeDrainTimeout = &metav1.Duration{Duration: nodeDrainTimeout}
+	}
+
+	return awsConverter, nil
+}
+
+func setupEC2Importer(cloudProviderName string) (*converter.EC2Importer, error) {
+	if cloudProviderName != "aws" {
+		return nil, fmt.Errorf("ec2 instances can't be imported for cloud provider %q", cloudProviderName)
+	}
+
+	instances, err := readOptionalFile(ec2InstancesFilePath, "ec2 instances")
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := readOptionalFile(ec2VolumesFilePath, "ec2 volumes")
+	if err != nil {
+		return nil, err
+	}
+
+	bootstrap, err := setupBootstrapOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	return &converter.EC2Importer{
+		InstancesFile: instances,
+		VolumesFile:   volumes,
+		InstanceID:    ec2InstanceID,
+		Name:          templateName,
+		Namespace:     templateNamespace,
+		Region:        region,
+		Bootstrap:     bootstrap,
+	}, nil
+}
+
+// serveWebhook serves conversion reviews until interrupted. CAPI machine sets
+// can be converted when they reference the input machine template.
+func serveWebhook() error {
+	if cloudProviderName != "aws" {
+		return fmt.Errorf("conversion webhook is not supported for cloud provider %q", cloudProviderName)
+	}
+
+	awsConverter, err := setupAWSConverter(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	machineTemplate, err := readOptionalFile(inputMachineTemplateFilePath, "machine template")
+	if err != nil {
+		fmt.Printf("Serving without a machine template, capi machine sets can't be converted: %v\n", err)
+	}
+
+	server := &webhook.Server{
+		Addr:      webhookAddr,
+		CertFile:  tlsCertFilePath,
+		KeyFile:   tlsKeyFilePath,
+		Converter: *awsConverter,
+	}
+	if machineTemplate != nil {
+		server.MachineTemplate = func(namespace, name string) ([]byte, error) {
+			template := &capi.AWSMachineTemplate{}
+			if err := yaml.Unmarshal(machineTemplate, template); err != nil {
+				return nil, fmt.Errorf("error unmarshalling machine template: %v", err)
+			}
+			if template.Name != name || (template.Namespace != "" && template.Namespace != namespace) {
+				return nil, fmt.Errorf("machine template %s/%s not found", namespace, name)
+			}
+			return machineTemplate, nil
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Serving experimental conversion endpoint on %s%s\n", webhookAddr, webhook.ConvertPath)
+	return server.ListenAndServe(ctx)
+}
+
+// diffConverted writes the differences between the converted objects and the
+// existing ones to stdout, and returns whether there are any.
+func diffConverted(convertedTypes [][]byte) (bool, error) {
+	existing, err := ioutil.ReadFile(diffAgainstFilePath)
+	if err != nil {
+		return false, fmt.Errorf("error reading existing objects: %v", err)
+	}
+	existingObjects, err := diff.ParseObjects(existing)
+	if err != nil {
+		return false, err
+	}
+	convertedObjects, err := diff.ParseObjects(bytes.Join(convertedTypes, []byte("\n---\n")))
+	if err != nil {
+		return false, err
+	}
+
+	differences := diff.Compare(convertedObjects, existingObjects)
+	if err := diff.Write(os.Stdout, diff.OutputFormat(diffOutput), differences); err != nil {
+		return false, err
+	}
+	return len(differences) > 0, nil
+}
+
+// writePlan writes the plan migrating the input machine set to CAPI to stdout.
+func writePlan() error {
+	if cloudProviderName != "aws" {
+		return fmt.Errorf("planning is not supported for cloud provider %q", cloudProviderName)
+	}
+
+	inputMachineSet, err := ioutil.ReadFile(inputMachineSetFilePath)
+	if err != nil {
+		return fmt.Errorf("error reading machineset: %v", err)
+	}
+	awsConverter, err := setupAWSConverter(inputMachineSet, nil)
+	if err != nil {
+		return err
+	}
+	converted, err := awsConverter.ToCAPI()
+	if err != nil {
+		return err
+	}
+	if report := awsConverter.Report(); len(report.Entries) > 0 {
+		fmt.Fprintf(os.Stderr, "Some fields could not be converted faithfully:\n%s", report.String())
+	}
+
+	migrationPlan, err := plan.New(inputMachineSet, converted, plan.Options{
+		MaxSurge:       intstr.Parse(maxSurge),
+		MaxUnavailable: intstr.Parse(maxUnavailable),
+	})
+	if err != nil {
+		return err
+	}
+	out, err := migrationPlan.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// explainField writes how a field of a MAPI machine set or of a CAPI machine
+// template is converted to stdout.
+func explainField(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: converter explain <provider> <field>, e.g. converter explain aws spec.template.spec.providerSpec.value.blockDevices")
+	}
+	if args[0] != "aws" {
+		return fmt.Errorf("explaining fields is not supported for cloud provider %q", args[0])
+	}
+
+	explanation, err := converter.ExplainAWSField(args[1])
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(os.Stdout, explanation.String())
+	return err
+}
+
+// runMirror mirrors the MAPI machine sets of the cluster in the kubeconfig
+// until interrupted.
+func runMirror() error {
+	if cloudProviderName != "aws" {
+		return fmt.Errorf("mirroring is not supported for cloud provider %q", cloudProviderName)
+	}
+
+	awsConverter, err := setupAWSConverter(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("error loading kubeconfig: %v", err)
+	}
+	mgr, err := ctrl.NewManager(config, ctrl.Options{MetricsBindAddress: "0"})
+	if err != nil {
+		return fmt.Errorf("error creating manager: %v", err)
+	}
+
+	machineSetMirror := &controller.MachineSetMirror{
+		Client:    mgr.GetClient(),
+		Converter: *awsConverter,
+		Namespace: mirrorNamespace,
+	}
+	if err := machineSetMirror.SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("error setting up mirror controller: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Mirroring mapi machine sets")
+	return mgr.Start(ctx)
+}
+
+func setupBootstrapOptions() (converter.BootstrapOptions, error) {
+	userData, err := readOptionalFile(userDataFilePath, "user data")
+	if err != nil {
+		return converter.BootstrapOptions{}, err
+	}
+
+	bootstrap := converter.BootstrapOptions{
+		Format:               converter.BootstrapFormat(bootstrapFormat),
+		UserDataFile:         userData,
+		SecureSecretsBackend: capi.SecretBackend(secretsBackend),
+		IgnitionVersion:      ignitionVersion,
+		IgnitionStorageType:  capi.IgnitionStorageTypeOption(ignitionStorageType),
+	}
+	if uncompressedUserData {
+		bootstrap.UncompressedUserData = pointer.Bool(true)
+	}
+
+	return bootstrap, nil
+}
+
+// splitList splits a comma separated flag value, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readOptionalFile returns nil when no path was given.
+func readOptionalFile(path, description string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %v", description, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package ami
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Image is an AMI known to a catalog.
+type Image struct {
+	// ID is the AMI ID, e.g. ami-0123456789abcdef0.
+	ID string `json:"id"`
+
+	// Name is the AMI name the lookup format is matched against.
+	Name string `json:"name"`
+
+	// OwnerID is the AWS account that owns the image. Images without an owner
+	// match any lookup organization.
+	// +optional
+	OwnerID string `json:"ownerId,omitempty"`
+
+	// Architecture is the image architecture, e.g. x86_64 or aarch64.
+	// +optional
+	Architecture string `json:"architecture,omitempty"`
+
+	// CreationDate is used to pick the newest image when several match.
+	// +optional
+	CreationDate string `json:"creationDate,omitempty"`
+}
+
+// Catalog is an offline list of images per region, used in place of the
+// DescribeImages calls CAPA makes to resolve image lookups.
+type Catalog struct {
+	Regions map[string][]Image `json:"regions"`
+}
+
+// ParseCatalog reads either a catalog document or CoreOS stream metadata.
+func ParseCatalog(data []byte) (*Catalog, error) {
+	probe := struct {
+		Architectures json.RawMessage `json:"architectures"`
+	}{}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("error unmarshalling image catalog: %v", err)
+	}
+
+	if probe.Architectures != nil {
+		stream, err := ParseStreamMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		return stream.Catalog(), nil
+	}
+
+	catalog := &Catalog{}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("error unmarshalling image catalog: %v", err)
+	}
+
+	if len(catalog.Regions) == 0 {
+		return nil, errors.New("image catalog has no regions")
+	}
+
+	return catalog, nil
+}
@@ -0,0 +1,156 @@
+package ami
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// DefaultImageLookupFormat is the image name format CAPA uses when none is set.
+	DefaultImageLookupFormat = "capa-ami-{{.BaseOS}}-?{{.K8sVersion}}-*"
+
+	// DefaultImageLookupOrg is the AWS account CAPA publishes its images from.
+	DefaultImageLookupOrg = "258751437250"
+
+	// DefaultImageLookupBaseOS is the base OS CAPA looks up when none is set.
+	DefaultImageLookupBaseOS = "ubuntu-18.04"
+)
+
+// Lookup holds the CAPA image lookup parameters of a machine.
+type Lookup struct {
+	Format     string
+	Org        string
+	BaseOS     string
+	K8sVersion string
+	Region     string
+
+	// Architecture is the image architecture, e.g. x86_64 or aarch64. Images
+	// of other architectures are skipped, images without one match any.
+	// +optional
+	Architecture string
+}
+
+// instanceFamilyPattern splits an instance family, e.g. m6gd, into its
+// series, generation and attributes.
+var instanceFamilyPattern = regexp.MustCompile(`^([a-z]+)(\d+)([a-z-]*)$`)
+
+// ArchitectureForInstanceType returns the image architecture an instance type
+// runs, aarch64 for the Graviton families, e.g. a1, m6g or c7gn, and x86_64
+// for the others. Returns an empty string when the type can't be parsed.
+func ArchitectureForInstanceType(instanceType string) string {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	match := instanceFamilyPattern.FindStringSubmatch(family)
+	if match == nil {
+		return ""
+	}
+	if family == "a1" || strings.Contains(match[3], "g") {
+		return "aarch64"
+	}
+	return "x86_64"
+}
+
+// Name expands the lookup format into an image name pattern, applying the
+// same defaults as CAPA.
+func (l Lookup) Name() (string, error) {
+	format := l.Format
+	if format == "" {
+		format = DefaultImageLookupFormat
+	}
+
+	baseOS := l.BaseOS
+	if baseOS == "" {
+		baseOS = DefaultImageLookupBaseOS
+	}
+
+	tmpl, err := template.New("imageLookupFormat").Parse(format)
+	if err != nil {
+		return "", fmt.Errorf("invalid image lookup format %q: %v", format, err)
+	}
+
+	var name bytes.Buffer
+	err = tmpl.Execute(&name, struct {
+		BaseOS     string
+		K8sVersion string
+	}{
+		BaseOS:     baseOS,
+		K8sVersion: strings.TrimPrefix(l.K8sVersion, "v"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error expanding image lookup format %q: %v", format, err)
+	}
+
+	return name.String(), nil
+}
+
+// Resolve finds the newest image in the lookup region whose name matches the
+// expanded lookup format, which is owned by the lookup organization and built
+// for the lookup architecture.
+func (c *Catalog) Resolve(lookup Lookup) (*Image, error) {
+	if lookup.Region == "" {
+		return nil, fmt.Errorf("region is required to resolve an image lookup")
+	}
+
+	name, err := lookup.Name()
+	if err != nil {
+		return nil, err
+	}
+
+	org := lookup.Org
+	if org == "" {
+		org = DefaultImageLookupOrg
+	}
+
+	pattern := wildcardRegexp(name)
+	matches := []Image{}
+	for _, image := range c.Regions[lookup.Region] {
+		if image.OwnerID != "" && image.OwnerID != org {
+			continue
+		}
+		if lookup.Architecture != "" && image.Architecture != "" && image.Architecture != lookup.Architecture {
+			continue
+		}
+		if pattern.MatchString(image.Name) {
+			matches = append(matches, image)
+		}
+	}
+
+	if len(matches) == 0 {
+		if lookup.Architecture != "" {
+			return nil, fmt.Errorf("no %s image matching %q owned by %s found in region %s", lookup.Architecture, name, org, lookup.Region)
+		}
+		return nil, fmt.Errorf("no image matching %q owned by %s found in region %s", name, org, lookup.Region)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].CreationDate != matches[j].CreationDate {
+			return matches[i].CreationDate > matches[j].CreationDate
+		}
+		return matches[i].Name > matches[j].Name
+	})
+
+	return &matches[0], nil
+}
+
+// wildcardRegexp compiles an EC2 filter value, where * matches any sequence of
+// characters and ? matches zero or one character.
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".?")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.MustCompile(expr.String())
+}
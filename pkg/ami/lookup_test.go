@@ -0,0 +1,138 @@
+package ami
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const testStreamMetadata = `{
+  "stream": "stable",
+  "architectures": {
+    "x86_64": {
+      "images": {
+        "aws": {
+          "regions": {
+            "us-east-1": {"release": "48.84.202109241901-0", "image": "ami-0x86east"},
+            "eu-west-1": {"release": "48.84.202109241901-0", "image": "ami-0x86west"}
+          }
+        }
+      }
+    },
+    "aarch64": {
+      "images": {
+        "aws": {
+          "regions": {
+            "us-east-1": {"release": "48.84.202109241901-0", "image": "ami-0armeast"}
+          }
+        }
+      }
+    }
+  }
+}`
+
+const testCatalog = `{
+  "regions": {
+    "us-east-1": [
+      {"id": "ami-old", "name": "capa-ami-ubuntu-18.04-1.21.2-00-1620000000", "ownerId": "258751437250", "creationDate": "2021-05-03T00:00:00.000Z"},
+      {"id": "ami-new", "name": "capa-ami-ubuntu-18.04-1.21.2-00-1630000000", "ownerId": "258751437250", "creationDate": "2021-08-26T00:00:00.000Z"},
+      {"id": "ami-other-org", "name": "capa-ami-ubuntu-18.04-1.21.2-00-1640000000", "ownerId": "123456789012", "creationDate": "2021-12-20T00:00:00.000Z"},
+      {"id": "ami-other-version", "name": "capa-ami-ubuntu-18.04-1.20.0-00-1640000000", "ownerId": "258751437250", "creationDate": "2021-12-20T00:00:00.000Z"}
+    ]
+  }
+}`
+
+func TestParseCatalog(t *testing.T) {
+	g := NewWithT(t)
+
+	catalog, err := ParseCatalog([]byte(testCatalog))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(catalog.Regions["us-east-1"]).To(HaveLen(4))
+
+	catalog, err = ParseCatalog([]byte(testStreamMetadata))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(catalog.Regions["us-east-1"]).To(Equal([]Image{
+		{ID: "ami-0armeast", Name: "rhcos-48.84.202109241901-0-aarch64", Architecture: "aarch64"},
+		{ID: "ami-0x86east", Name: "rhcos-48.84.202109241901-0-x86_64", Architecture: "x86_64"},
+	}))
+	g.Expect(catalog.Regions["eu-west-1"]).To(HaveLen(1))
+
+	_, err = ParseCatalog([]byte(`{}`))
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = ParseCatalog([]byte(`not json`))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLookupName(t *testing.T) {
+	g := NewWithT(t)
+
+	name, err := Lookup{K8sVersion: "v1.21.2"}.Name()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(name).To(Equal("capa-ami-ubuntu-18.04-?1.21.2-*"))
+
+	name, err = Lookup{Format: "rhcos-*-{{.BaseOS}}", BaseOS: "rhel-8"}.Name()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(name).To(Equal("rhcos-*-rhel-8"))
+
+	_, err = Lookup{Format: "{{.Unknown"}.Name()
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestResolve(t *testing.T) {
+	g := NewWithT(t)
+
+	catalog, err := ParseCatalog([]byte(testCatalog))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	image, err := catalog.Resolve(Lookup{K8sVersion: "v1.21.2", Region: "us-east-1"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(image.ID).To(Equal("ami-new"))
+
+	image, err = catalog.Resolve(Lookup{K8sVersion: "v1.21.2", Org: "123456789012", Region: "us-east-1"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(image.ID).To(Equal("ami-other-org"))
+
+	_, err = catalog.Resolve(Lookup{K8sVersion: "v1.22.0", Region: "us-east-1"})
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = catalog.Resolve(Lookup{K8sVersion: "v1.21.2", Region: "eu-west-1"})
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = catalog.Resolve(Lookup{K8sVersion: "v1.21.2"})
+	g.Expect(err).To(HaveOccurred())
+
+	catalog, err = ParseCatalog([]byte(testStreamMetadata))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	image, err = catalog.Resolve(Lookup{Format: "rhcos-*", Architecture: "aarch64", Region: "us-east-1"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(image.ID).To(Equal("ami-0armeast"))
+
+	image, err = catalog.Resolve(Lookup{Format: "rhcos-*", Architecture: "x86_64", Region: "us-east-1"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(image.ID).To(Equal("ami-0x86east"))
+
+	_, err = catalog.Resolve(Lookup{Format: "rhcos-*", Architecture: "aarch64", Region: "eu-west-1"})
+	g.Expect(err).To(MatchError(`no aarch64 image matching "rhcos-*" owned by 258751437250 found in region eu-west-1`))
+}
+
+func TestArchitectureForInstanceType(t *testing.T) {
+	g := NewWithT(t)
+
+	for instanceType, arch := range map[string]string{
+		"m5.large":     "x86_64",
+		"m6i.xlarge":   "x86_64",
+		"g4dn.xlarge":  "x86_64",
+		"x2iedn.large": "x86_64",
+		"a1.large":     "aarch64",
+		"m6g.large":    "aarch64",
+		"c6gn.medium":  "aarch64",
+		"g5g.xlarge":   "aarch64",
+		"is4gen.large": "aarch64",
+		"":             "",
+		"u-6tb1.metal": "",
+	} {
+		g.Expect(ArchitectureForInstanceType(instanceType)).To(Equal(arch), instanceType)
+	}
+}
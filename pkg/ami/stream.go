@@ -0,0 +1,121 @@
+package ami
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// StreamMetadata is the subset of the CoreOS stream metadata format
+// (https://github.com/coreos/stream-metadata-go) needed to look up AWS images.
+type StreamMetadata struct {
+	Stream        string                        `json:"stream"`
+	Architectures map[string]StreamArchitecture `json:"architectures"`
+}
+
+// StreamArchitecture holds the images published for a single architecture.
+type StreamArchitecture struct {
+	Images StreamImages `json:"images"`
+}
+
+// StreamImages holds the cloud images of an architecture.
+type StreamImages struct {
+	AWS *StreamAWSImages `json:"aws,omitempty"`
+}
+
+// StreamAWSImages maps AWS regions to the image published there.
+type StreamAWSImages struct {
+	Regions map[string]StreamRegionImage `json:"regions"`
+}
+
+// StreamRegionImage is an AMI published in a single region.
+type StreamRegionImage struct {
+	Release string `json:"release"`
+	Image   string `json:"image"`
+}
+
+// ParseStreamMetadata unmarshals a CoreOS stream metadata JSON document.
+func ParseStreamMetadata(data []byte) (*StreamMetadata, error) {
+	stream := &StreamMetadata{}
+	if err := json.Unmarshal(data, stream); err != nil {
+		return nil, fmt.Errorf("error unmarshalling stream metadata: %v", err)
+	}
+
+	if len(stream.Architectures) == 0 {
+		return nil, errors.New("stream metadata has no architectures")
+	}
+
+	return stream, nil
+}
+
+// Catalog turns the AWS images of the stream into an image catalog. Stream
+// metadata doesn't carry AMI names, so they're built the same way RHCOS
+// names its public images: rhcos-<release>-<architecture>.
+func (s *StreamMetadata) Catalog() *Catalog {
+	catalog := &Catalog{
+		Regions: map[string][]Image{},
+	}
+
+	for arch, architecture := range s.Architectures {
+		if architecture.Images.AWS == nil {
+			continue
+		}
+		for region, image := range architecture.Images.AWS.Regions {
+			catalog.Regions[region] = append(catalog.Regions[region], Image{
+				ID:           image.Image,
+				Name:         fmt.Sprintf("rhcos-%s-%s", image.Release, arch),
+				Architecture: arch,
+			})
+		}
+	}
+
+	for _, images := range catalog.Regions {
+		sort.Slice(images, func(i, j int) bool {
+			return images[i].Name < images[j].Name
+		})
+	}
+
+	return catalog
+}
+
+// FindImage returns the architecture and region an AMI is published for in the stream.
+func (s *StreamMetadata) FindImage(id string) (string, string, bool) {
+	for _, arch := range sortedKeys(s.Architectures) {
+		aws := s.Architectures[arch].Images.AWS
+		if aws == nil {
+			continue
+		}
+		for region, image := range aws.Regions {
+			if image.Image == id {
+				return arch, region, true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// RegionImage returns the AMI published for an architecture in a region.
+func (s *StreamMetadata) RegionImage(arch, region string) (string, error) {
+	architecture, ok := s.Architectures[arch]
+	if !ok || architecture.Images.AWS == nil {
+		return "", fmt.Errorf("stream metadata has no aws images for architecture %s", arch)
+	}
+
+	image, ok := architecture.Images.AWS.Regions[region]
+	if !ok || image.Image == "" {
+		return "", fmt.Errorf("stream metadata has no %s image for region %s", arch, region)
+	}
+
+	return image.Image, nil
+}
+
+func sortedKeys(architectures map[string]StreamArchitecture) []string {
+	keys := make([]string, 0, len(architectures))
+	for key := range architectures {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,39 @@
+package ami
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestStreamFindImage(t *testing.T) {
+	g := NewWithT(t)
+
+	stream, err := ParseStreamMetadata([]byte(testStreamMetadata))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	arch, region, found := stream.FindImage("ami-0armeast")
+	g.Expect(found).To(BeTrue())
+	g.Expect(arch).To(Equal("aarch64"))
+	g.Expect(region).To(Equal("us-east-1"))
+
+	_, _, found = stream.FindImage("ami-unknown")
+	g.Expect(found).To(BeFalse())
+}
+
+func TestStreamRegionImage(t *testing.T) {
+	g := NewWithT(t)
+
+	stream, err := ParseStreamMetadata([]byte(testStreamMetadata))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	image, err := stream.RegionImage("x86_64", "eu-west-1")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(image).To(Equal("ami-0x86west"))
+
+	_, err = stream.RegionImage("aarch64", "eu-west-1")
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = stream.RegionImage("s390x", "us-east-1")
+	g.Expect(err).To(HaveOccurred())
+}
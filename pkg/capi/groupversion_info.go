@@ -0,0 +1,11 @@
+package capi
+
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
+var (
+	// GroupVersion is group version of the Cluster API core types.
+	GroupVersion = schema.GroupVersion{Group: "cluster.x-k8s.io", Version: "v1alpha4"}
+
+	// InfrastructureGroupVersion is group version of the CAPA infrastructure types.
+	InfrastructureGroupVersion = schema.GroupVersion{Group: "infrastructure.cluster.x-k8s.io", Version: "v1alpha4"}
+)
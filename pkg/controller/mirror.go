@@ -0,0 +1,455 @@
+// Package controller keeps CAPI machine sets and AWSMachineTemplates in sync
+// with the MAPI machine sets they were converted from.
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/converter"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/diff"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// MirroredFromAnnotation is set on the CAPI objects the controller manages
+	// to the namespace/name of their MAPI machine set. Objects without it are
+	// never touched.
+	MirroredFromAnnotation = "mapi-capi-converter.openshift.io/mirrored-from"
+
+	// MirroredMetadataAnnotation lists the label and annotation keys the
+	// controller sets on a mirror. Only these keys are updated, or removed once
+	// the MAPI machine set drops them, other controllers own the rest.
+	MirroredMetadataAnnotation = "mapi-capi-converter.openshift.io/mirrored-metadata"
+
+	// MAPIPausedAnnotation hands authority over a machine set to its CAPI
+	// mirror. The controller then stops mirroring the spec, unpauses the CAPI
+	// objects and writes their status back to the MAPI machine set.
+	MAPIPausedAnnotation = "machine.openshift.io/paused"
+)
+
+var (
+	mapiMachineSetGVK     = mapi.GroupVersion.WithKind("MachineSet")
+	capiMachineSetGVK     = capi.GroupVersion.WithKind("MachineSet")
+	awsMachineTemplateGVK = capi.InfrastructureGroupVersion.WithKind("AWSMachineTemplate")
+)
+
+// MachineSetMirror mirrors MAPI machine sets into CAPI machine sets and
+// AWSMachineTemplates of the same name. While the MAPI machine set is
+// authoritative the mirrors carry capi.PausedAnnotation, so the CAPI
+// controllers leave them alone.
+type MachineSetMirror struct {
+	Client client.Client
+
+	// Converter holds the conversion options, it is copied for every machine
+	// set. Its input files are ignored.
+	Converter converter.AWSConverter
+
+	// Namespace the mirrors are created in. Defaults to the namespace of the
+	// MAPI machine set, which is also the only case they are owned by it.
+	Namespace string
+}
+
+// SetupWithManager watches MAPI machine sets and the CAPI machine sets
+// mirrored from them.
+func (r *MachineSetMirror) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("machineset-mirror").
+		For(newUnstructured(mapiMachineSetGVK)).
+		Watches(&source.Kind{Type: newUnstructured(capiMachineSetGVK)}, handler.EnqueueRequestsFromMapFunc(mirrorSource)).
+		Complete(r)
+}
+
+// mirrorSource maps a mirror to the MAPI machine set it was mirrored from.
+func mirrorSource(object client.Object) []reconcile.Request {
+	parts := strings.SplitN(object.GetAnnotations()[MirroredFromAnnotation], "/", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: parts[0], Name: parts[1]}}}
+}
+
+func (r *MachineSetMirror) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	mapiMachineSet := newUnstructured(mapiMachineSetGVK)
+	if err := r.Client.Get(ctx, req.NamespacedName, mapiMachineSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, r.deleteMirrors(ctx, req.NamespacedName)
+		}
+		return ctrl.Result{}, err
+	}
+
+	if _, paused := mapiMachineSet.GetAnnotations()[MAPIPausedAnnotation]; paused {
+		return ctrl.Result{}, r.handOver(ctx, mapiMachineSet)
+	}
+
+	capiMachineSet, awsMachineTemplate, err := r.convert(mapiMachineSet)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.ensureMirror(ctx, awsMachineTemplate, true); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.ensureMirror(ctx, capiMachineSet, false); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// convert returns the paused mirrors of a MAPI machine set.
+func (r *MachineSetMirror) convert(mapiMachineSet *unstructured.Unstructured) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	raw, err := mapiMachineSet.MarshalJSON()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshalling machineset: %v", err)
+	}
+
+	awsConverter := r.converter()
+	awsConverter.MachineSetFile = raw
+	out, err := awsConverter.ToCAPI()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error converting machineset %s/%s: %v", mapiMachineSet.GetNamespace(), mapiMachineSet.GetName(), err)
+	}
+
+	var capiMachineSet, awsMachineTemplate *unstructured.Unstructured
+	for _, object := range out {
+		mirror, err := unstructuredFromYAML(object)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch mirror.GroupVersionKind() {
+		case capiMachineSetGVK:
+			capiMachineSet = mirror
+		case awsMachineTemplateGVK:
+			awsMachineTemplate = mirror
+		default:
+			continue
+		}
+
+		mirror.SetNamespace(r.mirrorNamespace(mapiMachineSet))
+		mirror.SetOwnerReferences(nil)
+		if mirror.GetNamespace() == mapiMachineSet.GetNamespace() {
+			mirror.SetOwnerReferences([]metav1.OwnerReference{{
+				APIVersion: mapiMachineSetGVK.GroupVersion().String(),
+				Kind:       mapiMachineSetGVK.Kind,
+				Name:       mapiMachineSet.GetName(),
+				UID:        mapiMachineSet.GetUID(),
+			}})
+		}
+		setAnnotation(mirror, MirroredFromAnnotation, client.ObjectKeyFromObject(mapiMachineSet).String())
+		setAnnotation(mirror, capi.PausedAnnotation, "")
+		if err := setMirroredMetadata(mirror); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if capiMachineSet == nil || awsMachineTemplate == nil {
+		return nil, nil, fmt.Errorf("converting machineset %s/%s didn't produce a machineset and machine template", mapiMachineSet.GetNamespace(), mapiMachineSet.GetName())
+	}
+
+	return capiMachineSet, awsMachineTemplate, nil
+}
+
+// ensureMirror creates or updates a mirror. Machine templates are immutable in
+// CAPA, a changed spec is applied by recreating the template. Only the labels
+// and annotations the controller set are updated, and the spec only counts as
+// changed when it differs in a field the conversion sets, so defaulting by the
+// API server doesn't trigger updates.
+func (r *MachineSetMirror) ensureMirror(ctx context.Context, desired *unstructured.Unstructured, immutableSpec bool) error {
+	existing := newUnstructured(desired.GroupVersionKind())
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(desired), existing); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.Client.Create(ctx, desired)
+		}
+		return err
+	}
+
+	if err := checkMirrored(existing, desired.GetAnnotations()[MirroredFromAnnotation]); err != nil {
+		return err
+	}
+
+	specChanged := diff.SpecChanged(desired, existing)
+	if specChanged && immutableSpec {
+		if err := r.Client.Delete(ctx, existing); err != nil {
+			return err
+		}
+		return r.Client.Create(ctx, desired)
+	}
+
+	mirrored := mirroredMetadataOf(existing)
+	labels := mergeMirroredKeys(existing.GetLabels(), desired.GetLabels(), mirrored.Labels)
+	annotations := mergeMirroredKeys(existing.GetAnnotations(), desired.GetAnnotations(), mirrored.Annotations)
+
+	if !specChanged &&
+		equality.Semantic.DeepEqual(existing.GetLabels(), labels) &&
+		equality.Semantic.DeepEqual(existing.GetAnnotations(), annotations) &&
+		equality.Semantic.DeepEqual(existing.GetOwnerReferences(), desired.GetOwnerReferences()) {
+		return nil
+	}
+
+	if specChanged {
+		existing.Object["spec"] = desired.Object["spec"]
+	}
+	existing.SetLabels(labels)
+	existing.SetAnnotations(annotations)
+	existing.SetOwnerReferences(desired.GetOwnerReferences())
+	return r.Client.Update(ctx, existing)
+}
+
+// mirroredMetadata is the value of MirroredMetadataAnnotation.
+type mirroredMetadata struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// setMirroredMetadata records the label and annotation keys of a desired
+// mirror on it.
+func setMirroredMetadata(mirror *unstructured.Unstructured) error {
+	mirrored := mirroredMetadata{
+		Labels:      sortedKeys(mirror.GetLabels()),
+		Annotations: sortedKeys(mirror.GetAnnotations()),
+	}
+	value, err := json.Marshal(mirrored)
+	if err != nil {
+		return fmt.Errorf("error marshalling mirrored metadata: %v", err)
+	}
+	setAnnotation(mirror, MirroredMetadataAnnotation, string(value))
+	return nil
+}
+
+// mirroredMetadataOf returns the label and annotation keys the controller set
+// on a mirror. Mirrors without a valid record have none.
+func mirroredMetadataOf(mirror *unstructured.Unstructured) mirroredMetadata {
+	mirrored := mirroredMetadata{}
+	if value, ok := mirror.GetAnnotations()[MirroredMetadataAnnotation]; ok {
+		_ = json.Unmarshal([]byte(value), &mirrored)
+	}
+	return mirrored
+}
+
+// mergeMirroredKeys sets the desired keys on the existing labels or
+// annotations, and removes the keys mirrored before that aren't desired
+// anymore. Other keys are kept.
+func mergeMirroredKeys(existing, desired map[string]string, mirrored []string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for _, key := range mirrored {
+		if _, ok := desired[key]; !ok {
+			delete(merged, key)
+		}
+	}
+	for key, value := range desired {
+		merged[key] = value
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handOver releases the mirrors of a paused MAPI machine set, unpausing them
+// and dropping their ownerReference to it, and writes the status of the CAPI
+// machine set back to it.
+func (r *MachineSetMirror) handOver(ctx context.Context, mapiMachineSet *unstructured.Unstructured) error {
+	key := types.NamespacedName{Namespace: r.mirrorNamespace(mapiMachineSet), Name: mapiMachineSet.GetName()}
+	mirrorSource := client.ObjectKeyFromObject(mapiMachineSet).String()
+
+	mirrors := map[schema.GroupVersionKind]*unstructured.Unstructured{}
+	for _, gvk := range []schema.GroupVersionKind{awsMachineTemplateGVK, capiMachineSetGVK} {
+		mirror := newUnstructured(gvk)
+		if err := r.Client.Get(ctx, key, mirror); err != nil {
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("machineset %s is paused but its %s mirror doesn't exist", mirrorSource, gvk.Kind)
+			}
+			return err
+		}
+		if err := checkMirrored(mirror, mirrorSource); err != nil {
+			return err
+		}
+		if releaseMirror(mirror, mapiMachineSet) {
+			if err := r.Client.Update(ctx, mirror); err != nil {
+				return err
+			}
+		}
+		mirrors[gvk] = mirror
+	}
+
+	status, err := r.mapiStatus(mirrors[capiMachineSetGVK], mirrors[awsMachineTemplateGVK])
+	if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(mapiMachineSet.Object["status"], status) {
+		return nil
+	}
+
+	mapiMachineSet.Object["status"] = status
+	return r.Client.Status().Update(ctx, mapiMachineSet)
+}
+
+// releaseMirror unpauses a mirror and removes its ownerReference to the MAPI
+// machine set, which would otherwise garbage collect the handed over mirror
+// with the machine set. It returns whether the mirror changed.
+func releaseMirror(mirror, mapiMachineSet *unstructured.Unstructured) bool {
+	changed := false
+	if _, paused := mirror.GetAnnotations()[capi.PausedAnnotation]; paused {
+		annotations := mirror.GetAnnotations()
+		delete(annotations, capi.PausedAnnotation)
+		mirror.SetAnnotations(annotations)
+		changed = true
+	}
+
+	ownerReferences := []metav1.OwnerReference{}
+	for _, ownerReference := range mirror.GetOwnerReferences() {
+		if ownerReference.UID == mapiMachineSet.GetUID() {
+			changed = true
+			continue
+		}
+		ownerReferences = append(ownerReferences, ownerReference)
+	}
+	if changed {
+		mirror.SetOwnerReferences(ownerReferences)
+	}
+	return changed
+}
+
+// mapiStatus converts the status of a CAPI machine set to MAPI.
+func (r *MachineSetMirror) mapiStatus(capiMachineSet, awsMachineTemplate *unstructured.Unstructured) (interface{}, error) {
+	machineSetRaw, err := capiMachineSet.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling machineset: %v", err)
+	}
+	templateRaw, err := awsMachineTemplate.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling machine template: %v", err)
+	}
+
+	awsConverter := r.converter()
+	awsConverter.MachineSetFile = machineSetRaw
+	awsConverter.MachineTemplateFile = templateRaw
+	awsConverter.IncludeStatus = true
+	out, err := awsConverter.ToMAPI()
+	if err != nil {
+		return nil, fmt.Errorf("error converting machineset %s/%s status: %v", capiMachineSet.GetNamespace(), capiMachineSet.GetName(), err)
+	}
+
+	mapiMachineSet, err := unstructuredFromYAML(out[0])
+	if err != nil {
+		return nil, err
+	}
+	return mapiMachineSet.Object["status"], nil
+}
+
+// deleteMirrors deletes the mirrors of a deleted MAPI machine set, unless
+// they were handed over and are authoritative now.
+func (r *MachineSetMirror) deleteMirrors(ctx context.Context, mapiMachineSet types.NamespacedName) error {
+	namespace := r.Namespace
+	if namespace == "" {
+		namespace = mapiMachineSet.Namespace
+	}
+
+	for _, gvk := range []schema.GroupVersionKind{capiMachineSetGVK, awsMachineTemplateGVK} {
+		mirror := newUnstructured(gvk)
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: mapiMachineSet.Name}, mirror); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if mirror.GetAnnotations()[MirroredFromAnnotation] != mapiMachineSet.String() {
+			continue
+		}
+		if _, paused := mirror.GetAnnotations()[capi.PausedAnnotation]; !paused {
+			continue
+		}
+		if err := r.Client.Delete(ctx, mirror); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// converter returns a copy of the conversion options for a single machine set.
+// Owner references point at the MAPI machine set instead of its owners.
+func (r *MachineSetMirror) converter() converter.AWSConverter {
+	awsConverter := r.Converter
+	awsConverter.MachineSetFile = nil
+	awsConverter.MachineTemplateFile = nil
+	awsConverter.MachineAutoscalerFile = nil
+	awsConverter.MachineHealthCheckFile = nil
+	awsConverter.MachinesFile = nil
+	awsConverter.IncludeStatus = false
+	awsConverter.OutputFormat = converter.OutputFormatYAML
+	// Mirrors are owned by their MAPI machine set, see convert.
+	awsConverter.MetadataPropagation.PropagateOwnerReferences = false
+	return awsConverter
+}
+
+func (r *MachineSetMirror) mirrorNamespace(mapiMachineSet *unstructured.Unstructured) string {
+	if r.Namespace != "" {
+		return r.Namespace
+	}
+	return mapiMachineSet.GetNamespace()
+}
+
+// checkMirrored refuses to manage objects the controller didn't create.
+func checkMirrored(object *unstructured.Unstructured, mirrorSource string) error {
+	if mirroredFrom := object.GetAnnotations()[MirroredFromAnnotation]; mirroredFrom != mirrorSource {
+		return fmt.Errorf("%s %s/%s exists but isn't mirrored from machineset %s", object.GetKind(), object.GetNamespace(), object.GetName(), mirrorSource)
+	}
+	return nil
+}
+
+func newUnstructured(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{}
+	object.SetGroupVersionKind(gvk)
+	return object
+}
+
+func unstructuredFromYAML(data []byte) (*unstructured.Unstructured, error) {
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error converting object to json: %v", err)
+	}
+	object := &unstructured.Unstructured{}
+	if err := object.UnmarshalJSON(raw); err != nil {
+		return nil, fmt.Errorf("error unmarshalling object: %v", err)
+	}
+	return object, nil
+}
+
+func setAnnotation(object *unstructured.Unstructured, key, value string) {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	object.SetAnnotations(annotations)
+}
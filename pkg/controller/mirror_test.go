@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testMachineSet = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: worker-us-east-1a
+  namespace: openshift-machine-api
+  uid: 6b1a3f3e-2c44-4d0a-9d52-2f0f8c1f6c11
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      machine.openshift.io/cluster-api-cluster: cluster
+  template:
+    metadata:
+      labels:
+        machine.openshift.io/cluster-api-cluster: cluster
+    spec:
+      providerSpec:
+        value:
+          ami:
+            id: ami-0123
+          instanceType: m5.large
+          placement:
+            availabilityZone: us-east-1a
+            region: us-east-1
+          subnet:
+            id: subnet-0123
+`
+
+const testCAPIAutoscalerMaxSizeAnnotation = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size"
+
+var testMachineSetKey = types.NamespacedName{Namespace: "openshift-machine-api", Name: "worker-us-east-1a"}
+
+func newTestMirror(g *WithT, objects ...client.Object) *MachineSetMirror {
+	mapiMachineSet, err := unstructuredFromYAML([]byte(testMachineSet))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return &MachineSetMirror{
+		Client: fake.NewClientBuilder().WithObjects(append([]client.Object{mapiMachineSet}, objects...)...).Build(),
+	}
+}
+
+func reconcileMirror(g *WithT, mirror *MachineSetMirror) {
+	_, err := mirror.Reconcile(context.Background(), ctrl.Request{NamespacedName: testMachineSetKey})
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func getObject(g *WithT, c client.Client, object *unstructured.Unstructured) *unstructured.Unstructured {
+	g.Expect(c.Get(context.Background(), testMachineSetKey, object)).To(Succeed())
+	return object
+}
+
+func TestReconcileCreatesPausedMirrors(t *testing.T) {
+	g := NewWithT(t)
+
+	mirror := newTestMirror(g)
+	reconcileMirror(g, mirror)
+
+	capiMachineSet := getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	g.Expect(capiMachineSet.GetAnnotations()).To(HaveKey(capi.PausedAnnotation))
+	g.Expect(capiMachineSet.GetAnnotations()).To(HaveKeyWithValue(MirroredFromAnnotation, testMachineSetKey.String()))
+	g.Expect(capiMachineSet.GetOwnerReferences()).To(HaveLen(1))
+	g.Expect(capiMachineSet.GetOwnerReferences()[0].UID).To(Equal(types.UID("6b1a3f3e-2c44-4d0a-9d52-2f0f8c1f6c11")))
+	replicas, _, _ := unstructured.NestedInt64(capiMachineSet.Object, "spec", "replicas")
+	g.Expect(replicas).To(Equal(int64(2)))
+
+	awsMachineTemplate := getObject(g, mirror.Client, newUnstructured(awsMachineTemplateGVK))
+	g.Expect(awsMachineTemplate.GetAnnotations()).To(HaveKey(capi.PausedAnnotation))
+	instanceType, _, _ := unstructured.NestedString(awsMachineTemplate.Object, "spec", "template", "spec", "instanceType")
+	g.Expect(instanceType).To(Equal("m5.large"))
+}
+
+func TestReconcileMirrorsChanges(t *testing.T) {
+	g := NewWithT(t)
+
+	mirror := newTestMirror(g)
+	reconcileMirror(g, mirror)
+
+	// Mark the template, the fake client doesn't assign UIDs to tell a
+	// recreated object apart.
+	awsMachineTemplate := getObject(g, mirror.Client, newUnstructured(awsMachineTemplateGVK))
+	awsMachineTemplate.SetLabels(map[string]string{"recreated": "false"})
+	g.Expect(mirror.Client.Update(context.Background(), awsMachineTemplate)).To(Succeed())
+
+	mapiMachineSet := getObject(g, mirror.Client, newUnstructured(mapiMachineSetGVK))
+	g.Expect(unstructured.SetNestedField(mapiMachineSet.Object, int64(5), "spec", "replicas")).To(Succeed())
+	g.Expect(unstructured.SetNestedField(mapiMachineSet.Object, "m5.xlarge", "spec", "template", "spec", "providerSpec", "value", "instanceType")).To(Succeed())
+	g.Expect(mirror.Client.Update(context.Background(), mapiMachineSet)).To(Succeed())
+
+	reconcileMirror(g, mirror)
+
+	capiMachineSet := getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	replicas, _, _ := unstructured.NestedInt64(capiMachineSet.Object, "spec", "replicas")
+	g.Expect(replicas).To(Equal(int64(5)))
+
+	// The template is recreated, CAPA doesn't allow changing it.
+	awsMachineTemplate = getObject(g, mirror.Client, newUnstructured(awsMachineTemplateGVK))
+	instanceType, _, _ := unstructured.NestedString(awsMachineTemplate.Object, "spec", "template", "spec", "instanceType")
+	g.Expect(instanceType).To(Equal("m5.xlarge"))
+	g.Expect(awsMachineTemplate.GetLabels()).NotTo(HaveKey("recreated"))
+}
+
+func TestReconcileKeepsForeignMetadataAndDefaults(t *testing.T) {
+	g := NewWithT(t)
+
+	mirror := newTestMirror(g)
+	mapiMachineSet := getObject(g, mirror.Client, newUnstructured(mapiMachineSetGVK))
+	mapiMachineSet.SetLabels(map[string]string{"team": "a"})
+	g.Expect(mirror.Client.Update(context.Background(), mapiMachineSet)).To(Succeed())
+	reconcileMirror(g, mirror)
+
+	// Another controller annotates the machine set and the API server defaults
+	// its delete policy.
+	capiMachineSet := getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	g.Expect(capiMachineSet.GetLabels()).To(HaveKeyWithValue("team", "a"))
+	annotations := capiMachineSet.GetAnnotations()
+	annotations[testCAPIAutoscalerMaxSizeAnnotation] = "5"
+	capiMachineSet.SetAnnotations(annotations)
+	g.Expect(unstructured.SetNestedField(capiMachineSet.Object, string(capi.RandomMachineSetDeletePolicy), "spec", "deletePolicy")).To(Succeed())
+	g.Expect(mirror.Client.Update(context.Background(), capiMachineSet)).To(Succeed())
+	capiMachineSet = getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	awsMachineTemplate := getObject(g, mirror.Client, newUnstructured(awsMachineTemplateGVK))
+
+	reconcileMirror(g, mirror)
+
+	unchanged := getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	g.Expect(unchanged.GetResourceVersion()).To(Equal(capiMachineSet.GetResourceVersion()))
+	g.Expect(getObject(g, mirror.Client, newUnstructured(awsMachineTemplateGVK)).GetResourceVersion()).To(Equal(awsMachineTemplate.GetResourceVersion()))
+
+	// Dropping a label from the MAPI machine set only removes that label.
+	mapiMachineSet = getObject(g, mirror.Client, newUnstructured(mapiMachineSetGVK))
+	mapiMachineSet.SetLabels(nil)
+	g.Expect(mirror.Client.Update(context.Background(), mapiMachineSet)).To(Succeed())
+	reconcileMirror(g, mirror)
+
+	capiMachineSet = getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	g.Expect(capiMachineSet.GetLabels()).NotTo(HaveKey("team"))
+	g.Expect(capiMachineSet.GetAnnotations()).To(HaveKeyWithValue(testCAPIAutoscalerMaxSizeAnnotation, "5"))
+	g.Expect(capiMachineSet.GetAnnotations()).To(HaveKey(capi.PausedAnnotation))
+	deletePolicy, _, _ := unstructured.NestedString(capiMachineSet.Object, "spec", "deletePolicy")
+	g.Expect(deletePolicy).To(Equal(string(capi.RandomMachineSetDeletePolicy)))
+}
+
+func TestMergeMirroredKeys(t *testing.T) {
+	g := NewWithT(t)
+
+	merged := mergeMirroredKeys(
+		map[string]string{"mirrored": "old", "dropped": "x", "foreign": "y"},
+		map[string]string{"mirrored": "new", "added": "z"},
+		[]string{"mirrored", "dropped"},
+	)
+	g.Expect(merged).To(Equal(map[string]string{"mirrored": "new", "added": "z", "foreign": "y"}))
+	g.Expect(mergeMirroredKeys(nil, nil, []string{"dropped"})).To(BeNil())
+}
+
+func TestReconcileRefusesForeignObjects(t *testing.T) {
+	g := NewWithT(t)
+
+	foreign := newUnstructured(capiMachineSetGVK)
+	foreign.SetNamespace(testMachineSetKey.Namespace)
+	foreign.SetName(testMachineSetKey.Name)
+
+	mirror := newTestMirror(g, foreign)
+	_, err := mirror.Reconcile(context.Background(), ctrl.Request{NamespacedName: testMachineSetKey})
+	g.Expect(err).To(MatchError(ContainSubstring("isn't mirrored from machineset openshift-machine-api/worker-us-east-1a")))
+}
+
+func TestReconcileHandsOverPausedMachineSet(t *testing.T) {
+	g := NewWithT(t)
+
+	mirror := newTestMirror(g)
+	reconcileMirror(g, mirror)
+
+	capiMachineSet := getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	g.Expect(unstructured.SetNestedField(capiMachineSet.Object, map[string]interface{}{
+		"replicas":      int64(2),
+		"readyReplicas": int64(1),
+	}, "status")).To(Succeed())
+	g.Expect(mirror.Client.Status().Update(context.Background(), capiMachineSet)).To(Succeed())
+
+	mapiMachineSet := getObject(g, mirror.Client, newUnstructured(mapiMachineSetGVK))
+	setAnnotation(mapiMachineSet, MAPIPausedAnnotation, "")
+	g.Expect(unstructured.SetNestedField(mapiMachineSet.Object, int64(7), "spec", "replicas")).To(Succeed())
+	g.Expect(mirror.Client.Update(context.Background(), mapiMachineSet)).To(Succeed())
+
+	reconcileMirror(g, mirror)
+
+	// The CAPI side is authoritative now, MAPI spec changes aren't mirrored.
+	capiMachineSet = getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+	g.Expect(capiMachineSet.GetAnnotations()).NotTo(HaveKey(capi.PausedAnnotation))
+	g.Expect(capiMachineSet.GetOwnerReferences()).To(BeEmpty())
+	replicas, _, _ := unstructured.NestedInt64(capiMachineSet.Object, "spec", "replicas")
+	g.Expect(replicas).To(Equal(int64(2)))
+
+	awsMachineTemplate := getObject(g, mirror.Client, newUnstructured(awsMachineTemplateGVK))
+	g.Expect(awsMachineTemplate.GetAnnotations()).NotTo(HaveKey(capi.PausedAnnotation))
+	g.Expect(awsMachineTemplate.GetOwnerReferences()).To(BeEmpty())
+
+	mapiMachineSet = getObject(g, mirror.Client, newUnstructured(mapiMachineSetGVK))
+	readyReplicas, _, _ := unstructured.NestedInt64(mapiMachineSet.Object, "status", "readyReplicas")
+	g.Expect(readyReplicas).To(Equal(int64(1)))
+
+	// Handed over mirrors outlive the MAPI machine set.
+	g.Expect(mirror.Client.Delete(context.Background(), mapiMachineSet)).To(Succeed())
+	reconcileMirror(g, mirror)
+	getObject(g, mirror.Client, newUnstructured(capiMachineSetGVK))
+}
+
+func TestReleaseMirror(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiMachineSet, err := unstructuredFromYAML([]byte(testMachineSet))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	otherOwner := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: types.UID("owner-uid")}
+	mirror := newUnstructured(capiMachineSetGVK)
+	mirror.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: mapiMachineSetGVK.GroupVersion().String(), Kind: mapiMachineSetGVK.Kind, Name: mapiMachineSet.GetName(), UID: mapiMachineSet.GetUID()},
+		otherOwner,
+	})
+	g.Expect(releaseMirror(mirror, mapiMachineSet)).To(BeTrue())
+	g.Expect(mirror.GetOwnerReferences()).To(Equal([]metav1.OwnerReference{otherOwner}))
+
+	g.Expect(releaseMirror(mirror, mapiMachineSet)).To(BeFalse())
+}
+
+func TestReconcileDeletesMirrors(t *testing.T) {
+	g := NewWithT(t)
+
+	mirror := newTestMirror(g)
+	reconcileMirror(g, mirror)
+
+	g.Expect(mirror.Client.Delete(context.Background(), getObject(g, mirror.Client, newUnstructured(mapiMachineSetGVK)))).To(Succeed())
+	reconcileMirror(g, mirror)
+
+	for _, object := range []*unstructured.Unstructured{newUnstructured(capiMachineSetGVK), newUnstructured(awsMachineTemplateGVK)} {
+		err := mirror.Client.Get(context.Background(), testMachineSetKey, object)
+		g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	}
+}
+
+func TestMirrorSource(t *testing.T) {
+	g := NewWithT(t)
+
+	object := newUnstructured(capiMachineSetGVK)
+	g.Expect(mirrorSource(object)).To(BeEmpty())
+
+	setAnnotation(object, MirroredFromAnnotation, testMachineSetKey.String())
+	g.Expect(mirrorSource(object)).To(Equal([]ctrl.Request{{NamespacedName: testMachineSetKey}}))
+}
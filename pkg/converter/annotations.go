@@ -0,0 +1,130 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	mapiAutoscalerMinSizeAnnotation = "machine.openshift.io/cluster-api-autoscaler-node-group-min-size"
+	mapiAutoscalerMaxSizeAnnotation = "machine.openshift.io/cluster-api-autoscaler-node-group-max-size"
+	capiAutoscalerMinSizeAnnotation = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-min-size"
+	capiAutoscalerMaxSizeAnnotation = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size"
+
+	mapiCPUCapacityAnnotation     = "machine.openshift.io/vCPU"
+	mapiMemoryCapacityAnnotation  = "machine.openshift.io/memoryMb"
+	mapiGPUCapacityAnnotation     = "machine.openshift.io/GPU"
+	mapiMaxPodsCapacityAnnotation = "machine.openshift.io/maxPods"
+	capiCPUCapacityAnnotation     = "capacity.cluster-autoscaler.kubernetes.io/cpu"
+	capiMemoryCapacityAnnotation  = "capacity.cluster-autoscaler.kubernetes.io/memory"
+	capiGPUCapacityAnnotation     = "capacity.cluster-autoscaler.kubernetes.io/gpu-count"
+	capiMaxPodsCapacityAnnotation = "capacity.cluster-autoscaler.kubernetes.io/maxPods"
+
+	// machineAutoscalerOwnerAnnotation is set by the cluster-autoscaler-operator on
+	// machine sets whose size annotations are managed by a MachineAutoscaler.
+	machineAutoscalerOwnerAnnotation = "autoscaling.openshift.io/machineautoscaler"
+
+	mapiExcludeNodeDrainingAnnotation = "machine.openshift.io/exclude-node-draining"
+	mapiDeleteMachineAnnotation       = "machine.openshift.io/cluster-api-delete-machine"
+
+	mebibyte = 1024 * 1024
+)
+
+// annotationTranslation maps a MAPI annotation to its CAPI counterpart, with
+// optional value conversions for annotations whose units differ.
+type annotationTranslation struct {
+	mapi   string
+	capi   string
+	toCAPI func(string) (string, error)
+	toMAPI func(string) (string, error)
+}
+
+var autoscalerAnnotationTranslations = []annotationTranslation{
+	{mapi: mapiAutoscalerMinSizeAnnotation, capi: capiAutoscalerMinSizeAnnotation},
+	{mapi: mapiAutoscalerMaxSizeAnnotation, capi: capiAutoscalerMaxSizeAnnotation},
+	{mapi: mapiCPUCapacityAnnotation, capi: capiCPUCapacityAnnotation},
+	{mapi: mapiMemoryCapacityAnnotation, capi: capiMemoryCapacityAnnotation, toCAPI: memoryMbToQuantity, toMAPI: quantityToMemoryMb},
+	{mapi: mapiGPUCapacityAnnotation, capi: capiGPUCapacityAnnotation},
+	{mapi: mapiMaxPodsCapacityAnnotation, capi: capiMaxPodsCapacityAnnotation},
+	{mapi: machineAutoscalerOwnerAnnotation, capi: machineAutoscalerOwnerAnnotation},
+}
+
+// machineAnnotationTranslations apply to machine template annotations, which
+// the machine controllers read from each machine.
+var machineAnnotationTranslations = []annotationTranslation{
+	{mapi: mapiExcludeNodeDrainingAnnotation, capi: capi.ExcludeNodeDrainingAnnotation},
+	{mapi: mapiDeleteMachineAnnotation, capi: capi.DeleteMachineAnnotation},
+}
+
+// convertAutoscalerAnnotationsToCAPI renames the autoscaler annotations to their
+// CAPI keys, other annotations are copied verbatim.
+func convertAutoscalerAnnotationsToCAPI(mapiAnnotations map[string]string) (map[string]string, error) {
+	return translateAnnotations(mapiAnnotations, autoscalerAnnotationTranslations, toCAPIAnnotation)
+}
+
+// convertAutoscalerAnnotationsToMAPI renames the autoscaler annotations to their
+// MAPI keys, other annotations are copied verbatim.
+func convertAutoscalerAnnotationsToMAPI(capiAnnotations map[string]string) (map[string]string, error) {
+	return translateAnnotations(capiAnnotations, autoscalerAnnotationTranslations, toMAPIAnnotation)
+}
+
+func translateAnnotations(annotations map[string]string, translations []annotationTranslation, direction func(annotationTranslation) (string, string, func(string) (string, error))) (map[string]string, error) {
+	if annotations == nil {
+		return nil, nil
+	}
+
+	translated := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		translated[key] = value
+	}
+
+	for _, translation := range translations {
+		from, to, convert := direction(translation)
+		value, ok := annotations[from]
+		if !ok {
+			continue
+		}
+		if convert != nil {
+			var err error
+			if value, err = convert(value); err != nil {
+				return nil, fmt.Errorf("invalid %s annotation: %v", from, err)
+			}
+		}
+		delete(translated, from)
+		translated[to] = value
+	}
+
+	return translated, nil
+}
+
+func toCAPIAnnotation(translation annotationTranslation) (string, string, func(string) (string, error)) {
+	return translation.mapi, translation.capi, translation.toCAPI
+}
+
+func toMAPIAnnotation(translation annotationTranslation) (string, string, func(string) (string, error)) {
+	return translation.capi, translation.mapi, translation.toMAPI
+}
+
+// memoryMbToQuantity converts the MAPI memory capacity, a plain number of
+// mebibytes, into the resource quantity the CAPI autoscaler provider expects.
+func memoryMbToQuantity(memoryMb string) (string, error) {
+	mebibytes, err := strconv.ParseInt(memoryMb, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	return resource.NewQuantity(mebibytes*mebibyte, resource.BinarySI).String(), nil
+}
+
+// quantityToMemoryMb converts a CAPI memory capacity quantity into mebibytes,
+// rounded up so that decimal quantities, e.g. 8G, aren't reported smaller than
+// the capacity they describe.
+func quantityToMemoryMb(quantity string) (string, error) {
+	memory, err := resource.ParseQuantity(quantity)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt((memory.Value()+mebibyte-1)/mebibyte, 10), nil
+}
@@ -0,0 +1,61 @@
+package converter
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestConvertAutoscalerAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiAnnotations := map[string]string{
+		mapiAutoscalerMinSizeAnnotation:  "1",
+		mapiAutoscalerMaxSizeAnnotation:  "6",
+		mapiCPUCapacityAnnotation:        "4",
+		mapiMemoryCapacityAnnotation:     "16384",
+		mapiGPUCapacityAnnotation:        "0",
+		mapiMaxPodsCapacityAnnotation:    "250",
+		machineAutoscalerOwnerAnnotation: "openshift-machine-api/worker-us-east-1a",
+		"unrelated":                      "value",
+	}
+
+	capiAnnotations, err := convertAutoscalerAnnotationsToCAPI(mapiAnnotations)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiAnnotations).To(Equal(map[string]string{
+		capiAutoscalerMinSizeAnnotation:  "1",
+		capiAutoscalerMaxSizeAnnotation:  "6",
+		capiCPUCapacityAnnotation:        "4",
+		capiMemoryCapacityAnnotation:     "16Gi",
+		capiGPUCapacityAnnotation:        "0",
+		capiMaxPodsCapacityAnnotation:    "250",
+		machineAutoscalerOwnerAnnotation: "openshift-machine-api/worker-us-east-1a",
+		"unrelated":                      "value",
+	}))
+
+	roundTripped, err := convertAutoscalerAnnotationsToMAPI(capiAnnotations)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(roundTripped).To(Equal(mapiAnnotations))
+
+	_, err = convertAutoscalerAnnotationsToCAPI(map[string]string{mapiMemoryCapacityAnnotation: "16G"})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestQuantityToMemoryMb(t *testing.T) {
+	g := NewWithT(t)
+
+	memoryMb, err := quantityToMemoryMb("8G")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(memoryMb).To(Equal("7630"))
+
+	memoryMb, err = quantityToMemoryMb("1536Mi")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(memoryMb).To(Equal("1536"))
+
+	memoryMb, err = quantityToMemoryMb("1Mi")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(memoryMb).To(Equal("1"))
+
+	_, err = quantityToMemoryMb("lots")
+	g.Expect(err).To(HaveOccurred())
+}
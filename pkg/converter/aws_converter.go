@@ -1,9 +1,15 @@
 package converter
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/ami"
 	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
 	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
 	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/util"
@@ -17,16 +23,108 @@ import (
 const (
 	awsTemplateAPIVersion    = "infrastructure.cluster.x-k8s.io/v1alpha4"
 	awsTemplateKind          = "AWSMachineTemplate"
-	capiMachineSetAPIVersion = "cluster.x-k8s.io"
+	capiMachineSetAPIVersion = "cluster.x-k8s.io/v1alpha4"
 	capiMachineSetKind       = "MachineSet"
-	mapiMachineSetKind       = "machine.openshift.io"
-	mapiMachineSetAPIVersion = "MachineSet"
+	mapiMachineSetKind       = "MachineSet"
+	mapiMachineSetAPIVersion = "machine.openshift.io/v1beta1"
 	workerUserDataSecretName = "worker-user-data"
+
+	// amiRewrittenFromAnnotation records the AMI a converted object used before
+	// it was rewritten for the target region.
+	amiRewrittenFromAnnotation = "mapi-capi-converter.openshift.io/ami-rewritten-from"
+
+	instanceProfileARNResourcePrefix = "instance-profile/"
+
+	// maxNetworkInterfaces mirrors the MaxItems validation on CAPA's AWSMachineSpec.NetworkInterfaces.
+	maxNetworkInterfaces = 2
+
+	mapiProviderSpecPath   = "spec.template.spec.providerSpec.value"
+	capiAWSMachineSpecPath = "spec.template.spec"
 )
 
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-[0-9]+`)
+
 type AWSConverter struct {
 	MachineSetFile      []byte
 	MachineTemplateFile []byte
+
+	// ImageCatalogFile is an image catalog or CoreOS stream metadata document used
+	// to resolve CAPI image lookups into AMI IDs when converting to MAPI.
+	ImageCatalogFile []byte
+
+	// Region is the AWS region of the machines. When empty it's derived from the
+	// availability zone.
+	Region string
+
+	// StreamMetadataFile is a CoreOS stream metadata document used to rewrite AMI
+	// IDs for TargetRegion.
+	StreamMetadataFile []byte
+
+	// TargetRegion is the region the converted machines will run in. AMI IDs are
+	// only rewritten when it's set together with StreamMetadataFile.
+	TargetRegion string
+
+	// TargetAvailabilityZone and TargetSubnet replace the availability zone and
+	// subnet of the machines, which are regional, when TargetRegion differs from
+	// the source region. TargetSubnet is a subnet ID.
+	TargetAvailabilityZone string
+	TargetSubnet           string
+
+	// Architecture is the image architecture to pick from the stream metadata
+	// and the image catalog, e.g. x86_64 or aarch64. When empty it's taken from
+	// the source AMI, or from the instance type when resolving an image lookup.
+	Architecture string
+
+	// Bootstrap configures the cloud-init or Ignition options of the AWSMachineTemplate.
+	Bootstrap BootstrapOptions
+
+	// LabelTranslations is applied to machine set selectors and template labels.
+	// Defaults to DefaultLabelTranslations.
+	LabelTranslations LabelTranslations
+
+	// MachineAutoscalerFile is an optional MachineAutoscaler scaling the input
+	// machine set.
+	MachineAutoscalerFile []byte
+
+	// MachineAutoscalerOutput selects whether MachineAutoscalerFile is converted to
+	// CAPI as annotations or as a retargeted MachineAutoscaler. Defaults to annotations.
+	MachineAutoscalerOutput MachineAutoscalerOutput
+
+	// MachineHealthCheckFile is an optional MachineHealthCheck converted alongside
+	// the machine set. Its selector goes through LabelTranslations.
+	MachineHealthCheckFile []byte
+
+	// MetadataPropagation selects the machine set labels, annotations and
+	// ownerReferences copied onto the converted machine set.
+	MetadataPropagation MetadataPropagation
+
+	// NodeMetadata selects what happens to the node labels, annotations and
+	// taints of the MAPI machine spec. Defaults to reporting them.
+	NodeMetadata NodeMetadataStrategy
+
+	// NodeDrainTimeout limits how long CAPI machines are drained before deletion.
+	// MAPI machines drain until every pod is evicted, which is what nil means.
+	NodeDrainTimeout *metav1.Duration
+
+	// MachinesFile is an optional Machine or list of Machines belonging to the
+	// input machine set.
+	MachinesFile []byte
+
+	// IncludeStatus converts the status of the machine set and machines too,
+	// e.g. for migration dashboards. Otherwise status is left empty.
+	IncludeStatus bool
+
+	// OutputFormat selects what ToCAPI and ToMAPI write. The EC2 request formats
+	// render the input machine spec instead of converting it, i.e. the MAPI
+	// providerSpec for ToCAPI and the CAPI AWSMachineSpec for ToMAPI. Defaults to yaml.
+	OutputFormat OutputFormat
+
+	report ConversionReport
+}
+
+// Report returns the lossy conversions found by the last ToCAPI or ToMAPI call.
+func (converter *AWSConverter) Report() ConversionReport {
+	return converter.report
 }
 
 func (converter *AWSConverter) ConvertAPI(apiType string) ([][]byte, error) {
@@ -41,6 +139,8 @@ func (converter *AWSConverter) ConvertAPI(apiType string) ([][]byte, error) {
 }
 
 func (converter *AWSConverter) ToCAPI() ([][]byte, error) {
+	converter.report = ConversionReport{}
+
 	machineSet := &mapi.MachineSet{}
 	if err := yaml.Unmarshal(converter.MachineSetFile, machineSet); err != nil {
 		return nil, fmt.Errorf("error unmarshalling machineset: %v", err)
@@ -51,24 +151,89 @@ func (converter *AWSConverter) ToCAPI() ([][]byte, error) {
 		return nil, err
 	}
 
-	capiAWSTemplate := convertProviderConfigToAWSMachineTemplate(machineSet.Name, machineSet.Namespace, mapiProviderConfig)
+	if converter.OutputFormat.isEC2Request() {
+		return renderEC2Request(converter.OutputFormat, machineSet.Name, convertProviderConfigToEC2(mapiProviderConfig, &converter.report))
+	}
 
-	capiMachineSet := convertMachineSetToCAPI(machineSet)
+	if err := converter.retargetPlacement(mapiProviderConfig, mapiProviderSpecPath+".placement.availabilityZone", mapiProviderSpecPath+".subnet"); err != nil {
+		return nil, err
+	}
 
-	yamlCAPIAWSTemplate, err := yaml.Marshal(capiAWSTemplate)
+	capiAWSTemplate, err := convertProviderConfigToAWSMachineTemplate(machineSet.Name, machineSet.Namespace, mapiProviderConfig, converter.Bootstrap, &converter.report)
 	if err != nil {
 		return nil, err
 	}
 
-	yamlCAPIMachineSet, err := yaml.Marshal(capiMachineSet)
+	var rewrittenFrom string
+	capiAWSTemplate.Spec.Template.Spec.AMI.ID, rewrittenFrom, err = converter.rewriteAMIForTargetRegion(capiAWSTemplate.Spec.Template.Spec.AMI.ID, mapiProviderSpecPath+".ami.id")
 	if err != nil {
 		return nil, err
 	}
+	if rewrittenFrom != "" {
+		metav1.SetMetaDataAnnotation(&capiAWSTemplate.ObjectMeta, amiRewrittenFromAnnotation, rewrittenFrom)
+	}
 
-	return [][]byte{yamlCAPIAWSTemplate, yamlCAPIMachineSet}, nil
+	capiMachineSet, err := convertMachineSetToCAPI(machineSet, converter.labelTranslations(), converter.MetadataPropagation, &converter.report)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := convertNodeMetadataToCAPI(machineSet.Spec.Template.Spec, converter.NodeMetadata, capiMachineSet, &converter.report); err != nil {
+		return nil, err
+	}
+	capiMachineSet.Spec.Template.Spec.NodeDrainTimeout = converter.NodeDrainTimeout
+	if converter.IncludeStatus {
+		capiMachineSet.Status = convertMachineSetStatusToCAPI(machineSet.Status, capiMachineSet.Spec.Selector)
+	}
+
+	objects := []interface{}{capiAWSTemplate, capiMachineSet}
+
+	if converter.MachineAutoscalerFile != nil {
+		machineAutoscaler, err := convertMachineAutoscalerToCAPI(converter.MachineAutoscalerFile, converter.MachineAutoscalerOutput, machineSet, capiMachineSet)
+		if err != nil {
+			return nil, err
+		}
+		if machineAutoscaler != nil {
+			objects = append(objects, machineAutoscaler)
+		}
+	}
+
+	if converter.MachineHealthCheckFile != nil {
+		machineHealthCheck, err := convertMachineHealthCheckFileToCAPI(converter.MachineHealthCheckFile, capiMachineSet.Spec.ClusterName, converter.labelTranslations())
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, machineHealthCheck)
+	}
+
+	if converter.MachinesFile != nil {
+		capiMachines, err := convertMachinesFileToCAPI(converter.MachinesFile, machineSet.Name, converter.labelTranslations(), converter.MetadataPropagation, converter.IncludeStatus, &converter.report)
+		if err != nil {
+			return nil, err
+		}
+		for _, capiMachine := range capiMachines {
+			objects = append(objects, capiMachine)
+		}
+	}
+
+	return marshalObjects(objects)
+}
+
+// marshalObjects marshals each converted object into its own YAML document.
+func marshalObjects(objects []interface{}) ([][]byte, error) {
+	out := [][]byte{}
+	for _, object := range objects {
+		yamlObject, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, yamlObject)
+	}
+
+	return out, nil
 }
 
-func convertProviderConfigToAWSMachineTemplate(name, namespace string, mapiProviderConfig *mapi.AWSMachineProviderConfig) *capi.AWSMachineTemplate {
+func convertProviderConfigToAWSMachineTemplate(name, namespace string, mapiProviderConfig *mapi.AWSMachineProviderConfig, bootstrap BootstrapOptions, report *ConversionReport) (*capi.AWSMachineTemplate, error) {
 	capiAWSTemplate := &capi.AWSMachineTemplate{}
 	capiAWSTemplate.ObjectMeta = metav1.ObjectMeta{
 		Name:      name,
@@ -78,99 +243,94 @@ func convertProviderConfigToAWSMachineTemplate(name, namespace string, mapiProvi
 		Kind:       awsTemplateKind,
 		APIVersion: awsTemplateAPIVersion,
 	}
-	capiAWSTemplate.Spec.Template.Spec.AMI = convertAWSResourceReferenceToCAPI(mapiProviderConfig.AMI)
-	capiAWSTemplate.Spec.Template.Spec.InstanceType = mapiProviderConfig.InstanceType
-	capiAWSTemplate.Spec.Template.Spec.AdditionalTags = convertAWSTagsToCAPI(mapiProviderConfig.Tags)
-	capiAWSTemplate.Spec.Template.Spec.IAMInstanceProfile = util.DerefString(mapiProviderConfig.IAMInstanceProfile.ID)
-	capiAWSTemplate.Spec.Template.Spec.SSHKeyName = mapiProviderConfig.KeyName
-	capiAWSTemplate.Spec.Template.Spec.PublicIP = mapiProviderConfig.PublicIP
-	capiAWSTemplate.Spec.Template.Spec.FailureDomain = &mapiProviderConfig.Placement.AvailabilityZone
-	capiAWSTemplate.Spec.Template.Spec.Tenancy = string(mapiProviderConfig.Placement.Tenancy)
-	capiAWSTemplate.Spec.Template.Spec.AdditionalSecurityGroups = convertAWSSecurityGroupstoCAPI(mapiProviderConfig.SecurityGroups)
-	capiSubnet := convertAWSResourceReferenceToCAPI(mapiProviderConfig.Subnet)
-	capiAWSTemplate.Spec.Template.Spec.Subnet = &capiSubnet
-	capiAWSTemplate.Spec.Template.Spec.SpotMarketOptions = convertAWSSpotMarketOptionsToCAPI(mapiProviderConfig.SpotMarketOptions)
-	rootVolume, nonRootVolumes := convertAWSBlockDeviceMappingSpecToCAPI(mapiProviderConfig.BlockDevices)
-	capiAWSTemplate.Spec.Template.Spec.RootVolume = rootVolume
-	capiAWSTemplate.Spec.Template.Spec.NonRootVolumes = nonRootVolumes
-	capiAWSTemplate.Spec.Template.Spec.CloudInit = capi.CloudInit{
-		InsecureSkipSecretsManager: false,
-		SecureSecretsBackend:       capi.SecretBackendSecretsManager,
-	}
-
-	return capiAWSTemplate
-}
-
-func convertAWSResourceReferenceToCAPI(mapiReference mapi.AWSResourceReference) capi.AWSResourceReference {
-	return capi.AWSResourceReference{
-		ID:      mapiReference.ID,
-		ARN:     mapiReference.ARN,
-		Filters: convertAWSFiltersToCAPI(mapiReference.Filters),
-	}
-}
-
-func convertAWSFiltersToCAPI(mapiFilters []mapi.Filter) []capi.Filter {
-	capiFilters := []capi.Filter{}
-	for _, filter := range mapiFilters {
-		capiFilters = append(capiFilters, capi.Filter{
-			Name:   filter.Name,
-			Values: filter.Values,
-		})
+	spec, err := convertProviderConfigToAWSMachineSpec(mapiProviderConfig)
+	if err != nil {
+		return nil, err
+	}
+	capiAWSTemplate.Spec.Template.Spec = spec
+	convertAWSDeviceIndexToCAPI(mapiProviderConfig.DeviceIndex, report)
+	convertAWSBlockDevicesToCAPI(mapiProviderConfig.BlockDevices, report)
+	if err := convertBootstrapOptionsToCAPI(bootstrap, &capiAWSTemplate.Spec.Template.Spec, report); err != nil {
+		return nil, err
+	}
+	if err := validateAWSNetworkInterfaces(capiAWSTemplate.Spec.Template.Spec.NetworkInterfaces); err != nil {
+		return nil, err
 	}
-	return capiFilters
+
+	return capiAWSTemplate, nil
 }
 
-func convertAWSTagsToCAPI(mapiTags []mapi.TagSpecification) capi.Tags {
-	capiTags := map[string]string{}
-	for _, tag := range mapiTags {
-		capiTags[tag.Name] = tag.Value
+// convertAWSIAMInstanceProfileToCAPI returns the instance profile name CAPA expects.
+// An ARN is reduced to the name at the end of its resource path, filters can't be
+// resolved offline and are rejected.
+func convertAWSIAMInstanceProfileToCAPI(mapiInstanceProfile *mapi.AWSResourceReference) (string, error) {
+	if mapiInstanceProfile == nil {
+		return "", nil
 	}
-	return capiTags
-}
 
-func convertAWSSecurityGroupstoCAPI(sgs []mapi.AWSResourceReference) []capi.AWSResourceReference {
-	capiSGs := []capi.AWSResourceReference{}
-	for _, sg := range sgs {
-		capiSGs = append(capiSGs, convertAWSResourceReferenceToCAPI(sg))
+	if mapiInstanceProfile.ID != nil {
+		return *mapiInstanceProfile.ID, nil
 	}
-	return capiSGs
-}
 
-func convertAWSSpotMarketOptionsToCAPI(mapiSpotMarketOptions *mapi.SpotMarketOptions) *capi.SpotMarketOptions {
-	if mapiSpotMarketOptions == nil {
-		return nil
+	if mapiInstanceProfile.ARN != nil {
+		return instanceProfileNameFromARN(*mapiInstanceProfile.ARN)
 	}
-	return &capi.SpotMarketOptions{
-		MaxPrice: mapiSpotMarketOptions.MaxPrice,
+
+	if len(mapiInstanceProfile.Filters) > 0 {
+		return "", errors.New("iam instance profile filters are not supported, use an id or arn instead")
 	}
+
+	return "", nil
 }
 
-func convertAWSBlockDeviceMappingSpecToCAPI(mapiBlockDeviceMapping []mapi.BlockDeviceMappingSpec) (*capi.Volume, []capi.Volume) {
-	rootVolume := &capi.Volume{}
-	nonRootVolumes := []capi.Volume{}
+// instanceProfileNameFromARN extracts the profile name from an ARN in the
+// arn:partition:iam::account-id:instance-profile/path/name format.
+func instanceProfileNameFromARN(arn string) (string, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" {
+		return "", fmt.Errorf("invalid iam instance profile arn %q", arn)
+	}
 
-	for _, mapping := range mapiBlockDeviceMapping {
-		if mapping.DeviceName == nil {
-			rootVolume = &capi.Volume{
-				Size:          *mapping.EBS.VolumeSize,
-				Type:          *mapping.EBS.VolumeType,
-				IOPS:          *mapping.EBS.Iops,
-				Encrypted:     *mapping.EBS.Encrypted,
-				EncryptionKey: convertKMSKeyToCAPI(mapping.EBS.KMSKey),
-			}
+	resource := parts[5]
+	if !strings.HasPrefix(resource, instanceProfileARNResourcePrefix) {
+		return "", fmt.Errorf("arn %q is not an iam instance profile", arn)
+	}
+
+	name := resource[strings.LastIndex(resource, "/")+1:]
+	if name == "" {
+		return "", fmt.Errorf("iam instance profile arn %q has no profile name", arn)
+	}
+
+	return name, nil
+}
+
+// convertAWSBlockDevicesToCAPI reports the block devices with a device name but
+// without an EBS volume size, e.g. instance store devices. CAPA volumes need a
+// size of at least 8 GiB, so nonRootVolumesToCAPI skips them.
+func convertAWSBlockDevicesToCAPI(blockDevices []mapi.BlockDeviceMappingSpec, report *ConversionReport) {
+	for i, blockDevice := range blockDevices {
+		if blockDevice.DeviceName == nil || blockDevice.EBS != nil && blockDevice.EBS.VolumeSize != nil && *blockDevice.EBS.VolumeSize != 0 {
 			continue
 		}
-		nonRootVolumes = append(nonRootVolumes, capi.Volume{
-			DeviceName:    *mapping.DeviceName,
-			Size:          *mapping.EBS.VolumeSize,
-			Type:          *mapping.EBS.VolumeType,
-			IOPS:          *mapping.EBS.Iops,
-			Encrypted:     *mapping.EBS.Encrypted,
-			EncryptionKey: convertKMSKeyToCAPI(mapping.EBS.KMSKey),
-		})
+		report.add(fmt.Sprintf("%s.blockDevices[%d]", mapiProviderSpecPath, i), "block device %s has no EBS volume size, CAPA volumes need a size of at least 8 GiB, the device was dropped", *blockDevice.DeviceName)
+	}
+}
+
+// convertAWSDeviceIndexToCAPI checks the MAPI network interface device index.
+// CAPA always attaches the primary interface at index 0 and has no field to
+// override it, so any other index is reported as lost.
+func convertAWSDeviceIndexToCAPI(deviceIndex int64, report *ConversionReport) {
+	if deviceIndex != 0 {
+		report.add(mapiProviderSpecPath+".deviceIndex", "device index %d has no CAPI equivalent, the primary network interface will be attached at index 0", deviceIndex)
 	}
+}
 
-	return rootVolume, nonRootVolumes
+func convertAWSTagsToCAPI(mapiTags []mapi.TagSpecification) capi.Tags {
+	capiTags := map[string]string{}
+	for _, tag := range mapiTags {
+		capiTags[tag.Name] = tag.Value
+	}
+	return capiTags
 }
 
 func convertKMSKeyToCAPI(kmsKey mapi.AWSResourceReference) string {
@@ -185,34 +345,80 @@ func convertKMSKeyToCAPI(kmsKey mapi.AWSResourceReference) string {
 	return ""
 }
 
-func convertMachineSetToCAPI(mapiMachineSet *mapi.MachineSet) *capi.MachineSet {
-	capiMachineSet := &capi.MachineSet{}
-	capiMachineSet.ObjectMeta = metav1.ObjectMeta{
-		Name:      mapiMachineSet.Name,
-		Namespace: mapiMachineSet.Namespace,
+// userDataSecretName returns the user data secret of a MAPI provider config,
+// the worker-user-data secret if it has none.
+func userDataSecretName(mapiProviderConfig *mapi.AWSMachineProviderConfig) string {
+	if mapiProviderConfig.UserDataSecret != nil && mapiProviderConfig.UserDataSecret.Name != "" {
+		return mapiProviderConfig.UserDataSecret.Name
 	}
+	return workerUserDataSecretName
+}
+
+func convertMachineSetToCAPI(mapiMachineSet *mapi.MachineSet, labelTranslations LabelTranslations, propagation MetadataPropagation, report *ConversionReport) (*capi.MachineSet, error) {
+	capiMachineSet := &capi.MachineSet{}
+	capiMachineSet.ObjectMeta = propagateObjectMeta(mapiMachineSet.ObjectMeta, mapiMachineSetAPIVersion, propagation, labelTranslations.toCAPI, report)
 	capiMachineSet.TypeMeta = metav1.TypeMeta{
 		Kind:       capiMachineSetKind,
 		APIVersion: capiMachineSetAPIVersion,
 	}
-	capiMachineSet.Spec.Selector = mapiMachineSet.Spec.Selector
-	capiMachineSet.Spec.Template.Labels = mapiMachineSet.Spec.Template.Labels
-	capiMachineSet.Spec.ClusterName = "" // TODO: this should be fetched from infra object
+	annotations, err := convertAutoscalerAnnotationsToCAPI(capiMachineSet.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	capiMachineSet.Annotations = annotations
+	capiMachineSet.Spec.Selector = translateLabelSelector(mapiMachineSet.Spec.Selector, labelTranslations.toCAPI)
+	capiMachineSet.Spec.Template.ObjectMeta, err = convertMachineTemplateMetaToCAPI(mapiMachineSet.Spec.Template.ObjectMeta, propagation, labelTranslations, report)
+	if err != nil {
+		return nil, err
+	}
+	capiMachineSet.Spec.ClusterName = clusterNameFromLabels(capiMachineSet.Spec.Selector, capiMachineSet.Spec.Template.Labels, capi.ClusterLabelName)
+	if capiMachineSet.Spec.ClusterName != "" {
+		ensureSelectedLabel(&capiMachineSet.Spec.Selector, &capiMachineSet.Spec.Template.Labels, capi.ClusterLabelName, capiMachineSet.Spec.ClusterName)
+	}
+	if !hasSelectorKey(capiMachineSet.Spec.Selector, capi.MachineDeploymentLabelName) {
+		ensureSelectedLabel(&capiMachineSet.Spec.Selector, &capiMachineSet.Spec.Template.Labels, capi.MachineSetLabelName, capiMachineSet.Name)
+	}
 	capiMachineSet.Spec.Replicas = mapiMachineSet.Spec.Replicas
+	capiMachineSet.Spec.MinReadySeconds = mapiMachineSet.Spec.MinReadySeconds
+	capiMachineSet.Spec.DeletePolicy, err = convertDeletePolicy(mapiMachineSet.Spec.DeletePolicy)
+	if err != nil {
+		return nil, err
+	}
+	mapiProviderConfig, err := mapi.ProviderSpecFromRawExtension(mapiMachineSet.Spec.Template.Spec.ProviderSpec.Value)
+	if err != nil {
+		return nil, err
+	}
 	capiMachineSet.Spec.Template.Spec.Bootstrap = capi.Bootstrap{
-		DataSecretName: pointer.String(workerUserDataSecretName),
+		DataSecretName: pointer.String(userDataSecretName(mapiProviderConfig)),
 	}
-	capiMachineSet.Spec.Template.Spec.ClusterName = "" // TODO: this should be fetched from infra object
+	capiMachineSet.Spec.Template.Spec.ClusterName = capiMachineSet.Spec.ClusterName
 	capiMachineSet.Spec.Template.Spec.InfrastructureRef = corev1.ObjectReference{
 		APIVersion: awsTemplateAPIVersion,
 		Kind:       awsTemplateKind,
 		Name:       mapiMachineSet.Name,
 	}
 
-	return capiMachineSet
+	if errs := capiMachineSet.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid machineset after label translation: %v", errs.ToAggregate())
+	}
+
+	return capiMachineSet, nil
+}
+
+// convertDeletePolicy validates the delete policy, which has the same values in
+// both APIs.
+func convertDeletePolicy(deletePolicy string) (string, error) {
+	switch deletePolicy {
+	case "", string(mapi.RandomMachineSetDeletePolicy), string(mapi.NewestMachineSetDeletePolicy), string(mapi.OldestMachineSetDeletePolicy):
+		return deletePolicy, nil
+	default:
+		return "", fmt.Errorf("invalid delete policy %q, must be one of Random, Newest or Oldest", deletePolicy)
+	}
 }
 
 func (converter *AWSConverter) ToMAPI() ([][]byte, error) {
+	converter.report = ConversionReport{}
+
 	machineSet := &capi.MachineSet{}
 	if err := yaml.Unmarshal(converter.MachineSetFile, machineSet); err != nil {
 		return nil, fmt.Errorf("error unmarshalling machineset: %v", err)
@@ -223,155 +429,430 @@ func (converter *AWSConverter) ToMAPI() ([][]byte, error) {
 		return nil, fmt.Errorf("error unmarshalling machine template: %v", err)
 	}
 
-	mapiProviderConfig := convertAWSMachineTemplateToroviderConfig(machineTemplate)
+	if converter.OutputFormat.isEC2Request() {
+		if err := validateAWSNetworkInterfaces(machineTemplate.Spec.Template.Spec.NetworkInterfaces); err != nil {
+			return nil, err
+		}
+		data, err := convertAWSMachineSpecToEC2(machineTemplate.Spec.Template.Spec, &converter.report)
+		if err != nil {
+			return nil, err
+		}
+		return renderEC2Request(converter.OutputFormat, machineSet.Name, data)
+	}
 
-	rawProviderConfig, err := mapi.RawExtensionFromProviderSpec(mapiProviderConfig)
+	mapiProviderConfig, err := convertAWSMachineTemplateToroviderConfig(machineTemplate, &converter.report)
 	if err != nil {
 		return nil, err
 	}
 
-	mapiMachineSet := convertMachineSetToMAPI(machineSet, rawProviderConfig)
+	if isEmptyAWSResourceReference(mapiProviderConfig.AMI) {
+		mapiProviderConfig.AMI, err = converter.resolveAWSImageLookup(machineSet, machineTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// CAPA takes the region from the AWSCluster, MAPI needs it on every machine.
+	mapiProviderConfig.Placement.Region = converter.Region
+	if mapiProviderConfig.Placement.Region == "" {
+		mapiProviderConfig.Placement.Region = regionFromAvailabilityZone(mapiProviderConfig.Placement.AvailabilityZone)
+	}
 
-	yamlMAPIMachineSet, err := yaml.Marshal(mapiMachineSet)
+	if err := converter.retargetPlacement(mapiProviderConfig, capiAWSMachineSpecPath+".failureDomain", capiAWSMachineSpecPath+".subnet"); err != nil {
+		return nil, err
+	}
+
+	var rewrittenFrom string
+	mapiProviderConfig.AMI.ID, rewrittenFrom, err = converter.rewriteAMIForTargetRegion(mapiProviderConfig.AMI.ID, capiAWSMachineSpecPath+".ami.id")
 	if err != nil {
 		return nil, err
 	}
 
-	return [][]byte{yamlMAPIMachineSet}, nil
-}
+	if dataSecretName := machineSet.Spec.Template.Spec.Bootstrap.DataSecretName; dataSecretName != nil && *dataSecretName != "" {
+		mapiProviderConfig.UserDataSecret = &corev1.LocalObjectReference{Name: *dataSecretName}
+	}
+
+	rawProviderConfig, err := marshalProviderConfig(mapiProviderConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	mapiMachineSet, err := convertMachineSetToMAPI(machineSet, rawProviderConfig, converter.labelTranslations(), converter.MetadataPropagation, &converter.report)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := convertNodeMetadataToMAPI(machineSet, mapiMachineSet); err != nil {
+		return nil, err
+	}
+	if converter.IncludeStatus {
+		mapiMachineSet.Status = convertMachineSetStatusToMAPI(machineSet.Status)
+	}
+	if rewrittenFrom != "" {
+		metav1.SetMetaDataAnnotation(&mapiMachineSet.ObjectMeta, amiRewrittenFromAnnotation, rewrittenFrom)
+	}
+
+	objects := []interface{}{mapiMachineSet}
+
+	var machineAutoscaler *mapi.MachineAutoscaler
+	if converter.MachineAutoscalerFile != nil {
+		machineAutoscaler, err = parseMachineAutoscaler(converter.MachineAutoscalerFile, machineSet.Name)
+		if err != nil {
+			return nil, err
+		}
+		machineAutoscaler = retargetMachineAutoscaler(machineAutoscaler, mapiMachineSetAPIVersion, mapiMachineSet.Name)
+	} else {
+		machineAutoscaler, err = machineAutoscalerFromAnnotations(mapiMachineSet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if machineAutoscaler != nil {
+		objects = append(objects, machineAutoscaler)
+	}
+
+	if converter.MachineHealthCheckFile != nil {
+		machineHealthCheck, err := convertMachineHealthCheckFileToMAPI(converter.MachineHealthCheckFile, converter.labelTranslations(), &converter.report)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, machineHealthCheck)
+	}
+
+	if converter.MachinesFile != nil {
+		mapiMachines, err := convertMachinesFileToMAPI(converter.MachinesFile, machineSet.Name, rawProviderConfig, converter.labelTranslations(), converter.MetadataPropagation, converter.IncludeStatus, &converter.report)
+		if err != nil {
+			return nil, err
+		}
+		for _, mapiMachine := range mapiMachines {
+			objects = append(objects, mapiMachine)
+		}
+	}
 
-func convertAWSMachineTemplateToroviderConfig(awsMachineTemplate *capi.AWSMachineTemplate) *mapi.AWSMachineProviderConfig {
-	mapiProviderConfig := &mapi.AWSMachineProviderConfig{}
+	return marshalObjects(objects)
+}
 
-	mapiProviderConfig.AMI = convertAWSResourceReferenceToMAPI(awsMachineTemplate.Spec.Template.Spec.AMI)
-	mapiProviderConfig.InstanceType = awsMachineTemplate.Spec.Template.Spec.InstanceType
-	mapiProviderConfig.Tags = convertAWSTagsToMAPI(awsMachineTemplate.Spec.Template.Spec.AdditionalTags)
-	mapiProviderConfig.IAMInstanceProfile = &mapi.AWSResourceReference{
-		ID: &awsMachineTemplate.Spec.Template.Spec.IAMInstanceProfile,
+func convertAWSMachineTemplateToroviderConfig(awsMachineTemplate *capi.AWSMachineTemplate, report *ConversionReport) (*mapi.AWSMachineProviderConfig, error) {
+	mapiProviderConfig, err := convertAWSMachineSpecToProviderConfig(awsMachineTemplate.Spec.Template.Spec)
+	if err != nil {
+		return nil, err
 	}
-	mapiProviderConfig.KeyName = awsMachineTemplate.Spec.Template.Spec.SSHKeyName
-	mapiProviderConfig.PublicIP = awsMachineTemplate.Spec.Template.Spec.PublicIP
-	mapiProviderConfig.Placement = mapi.Placement{
-		AvailabilityZone: util.DerefString(awsMachineTemplate.Spec.Template.Spec.FailureDomain),
-		Tenancy:          convertAWSTenancyToMAPI(awsMachineTemplate.Spec.Template.Spec.Tenancy),
-		Region:           "", // TODO: fetch region from cluster object
+	mapiProviderConfig.TypeMeta = metav1.TypeMeta{
+		Kind:       awsProviderConfigKind,
+		APIVersion: awsProviderConfigAPIVersion,
+	}
+
+	deviceIndex, err := convertAWSNetworkInterfacesToMAPI(awsMachineTemplate.Spec.Template.Spec.NetworkInterfaces, report)
+	if err != nil {
+		return nil, err
 	}
-	mapiProviderConfig.SecurityGroups = convertAWSSecurityGroupstoMAPI(awsMachineTemplate.Spec.Template.Spec.AdditionalSecurityGroups)
-	mapiProviderConfig.Subnet = convertAWSResourceReferenceToMAPI(*awsMachineTemplate.Spec.Template.Spec.Subnet)
-	mapiProviderConfig.SpotMarketOptions = convertAWSSpotMarketOptionsToMAPI(awsMachineTemplate.Spec.Template.Spec.SpotMarketOptions)
-	mapiProviderConfig.BlockDevices = convertAWSBlockDeviceMappingSpecToMAPI(awsMachineTemplate.Spec.Template.Spec.RootVolume, awsMachineTemplate.Spec.Template.Spec.NonRootVolumes)
-	return mapiProviderConfig
+	mapiProviderConfig.DeviceIndex = deviceIndex
+	return mapiProviderConfig, nil
 }
 
-func convertAWSResourceReferenceToMAPI(mapiReference capi.AWSResourceReference) mapi.AWSResourceReference {
+// resolveAWSImageLookup replaces the CAPA image lookup, which MAPI doesn't
+// support, with the AMI ID it resolves to in the image catalog.
+func (converter *AWSConverter) resolveAWSImageLookup(capiMachineSet *capi.MachineSet, awsMachineTemplate *capi.AWSMachineTemplate) (mapi.AWSResourceReference, error) {
+	if len(converter.ImageCatalogFile) == 0 {
+		return mapi.AWSResourceReference{}, errors.New("ami is not set and the image lookup can't be resolved without an image catalog")
+	}
+
+	catalog, err := ami.ParseCatalog(converter.ImageCatalogFile)
+	if err != nil {
+		return mapi.AWSResourceReference{}, err
+	}
+
+	spec := awsMachineTemplate.Spec.Template.Spec
+	region := converter.Region
+	if region == "" {
+		region = regionFromAvailabilityZone(util.DerefString(spec.FailureDomain))
+	}
+
+	architecture := converter.Architecture
+	if architecture == "" {
+		architecture = ami.ArchitectureForInstanceType(spec.InstanceType)
+	}
+
+	image, err := catalog.Resolve(ami.Lookup{
+		Format:       spec.ImageLookupFormat,
+		Org:          spec.ImageLookupOrg,
+		BaseOS:       spec.ImageLookupBaseOS,
+		K8sVersion:   util.DerefString(capiMachineSet.Spec.Template.Spec.Version),
+		Region:       region,
+		Architecture: architecture,
+	})
+	if err != nil {
+		return mapi.AWSResourceReference{}, fmt.Errorf("error resolving ami: %v", err)
+	}
+
+	converter.report.add(capiAWSMachineSpecPath+".imageLookupFormat", "image lookup is not supported by MAPI, resolved to %s (%s) in %s", image.ID, image.Name, region)
+
 	return mapi.AWSResourceReference{
-		ID:      mapiReference.ID,
-		ARN:     mapiReference.ARN,
-		Filters: convertAWSFiltersToMAPI(mapiReference.Filters),
+		ID: pointer.String(image.ID),
+	}, nil
+}
+
+// retargetPlacement moves the availability zone and subnet of a machine to
+// TargetRegion. Both are regional, so a target region other than the source
+// one is rejected unless TargetAvailabilityZone, and TargetSubnet when the
+// machine has a subnet, are set in it.
+func (converter *AWSConverter) retargetPlacement(providerConfig *mapi.AWSMachineProviderConfig, zoneField, subnetField string) error {
+	sourceRegion := providerConfig.Placement.Region
+	if sourceRegion == "" {
+		sourceRegion = regionFromAvailabilityZone(providerConfig.Placement.AvailabilityZone)
+	}
+	if converter.TargetRegion == "" || converter.TargetRegion == sourceRegion {
+		return nil
+	}
+
+	if converter.TargetAvailabilityZone == "" {
+		return fmt.Errorf("target region %s differs from source region %s, a target availability zone is required", converter.TargetRegion, sourceRegion)
+	}
+	if region := regionFromAvailabilityZone(converter.TargetAvailabilityZone); region != converter.TargetRegion {
+		return fmt.Errorf("target availability zone %s is not in target region %s", converter.TargetAvailabilityZone, converter.TargetRegion)
+	}
+	if !isEmptyAWSResourceReference(providerConfig.Subnet) && converter.TargetSubnet == "" {
+		return fmt.Errorf("target region %s differs from source region %s, a target subnet is required", converter.TargetRegion, sourceRegion)
 	}
+
+	converter.report.add(zoneField, "availability zone %s replaced by %s for region %s", providerConfig.Placement.AvailabilityZone, converter.TargetAvailabilityZone, converter.TargetRegion)
+	providerConfig.Placement.AvailabilityZone = converter.TargetAvailabilityZone
+	providerConfig.Placement.Region = converter.TargetRegion
+	if converter.TargetSubnet != "" {
+		converter.report.add(subnetField, "subnet replaced by %s for region %s", converter.TargetSubnet, converter.TargetRegion)
+		providerConfig.Subnet = mapi.AWSResourceReference{ID: pointer.String(converter.TargetSubnet)}
+	}
+	return nil
 }
 
-func convertAWSFiltersToMAPI(capiFilters []capi.Filter) []mapi.Filter {
-	mapiFilters := []mapi.Filter{}
-	for _, filter := range capiFilters {
-		mapiFilters = append(mapiFilters, mapi.Filter{
-			Name:   filter.Name,
-			Values: filter.Values,
-		})
+// rewriteAMIForTargetRegion returns the ID of the RHCOS image matching amiID
+// in the target region. When the ID was rewritten, the source AMI and its
+// region are returned as well so they can be recorded on the converted object.
+func (converter *AWSConverter) rewriteAMIForTargetRegion(amiID *string, field string) (*string, string, error) {
+	if len(converter.StreamMetadataFile) == 0 || converter.TargetRegion == "" {
+		return amiID, "", nil
+	}
+
+	if amiID == nil {
+		converter.report.add(field, "ami is not set by id and can't be rewritten for region %s", converter.TargetRegion)
+		return amiID, "", nil
+	}
+
+	stream, err := ami.ParseStreamMetadata(converter.StreamMetadataFile)
+	if err != nil {
+		return nil, "", err
 	}
-	return mapiFilters
+
+	arch, sourceRegion, found := stream.FindImage(*amiID)
+	if converter.Architecture != "" {
+		arch = converter.Architecture
+	}
+	if arch == "" {
+		return nil, "", fmt.Errorf("ami %s is not in the stream metadata, set the architecture to rewrite it", *amiID)
+	}
+
+	targetAMI, err := stream.RegionImage(arch, converter.TargetRegion)
+	if err != nil {
+		return nil, "", fmt.Errorf("error rewriting ami %s: %v", *amiID, err)
+	}
+
+	if targetAMI == *amiID {
+		return amiID, "", nil
+	}
+
+	rewrittenFrom := *amiID
+	if found {
+		rewrittenFrom = fmt.Sprintf("%s (%s)", *amiID, sourceRegion)
+	}
+	converter.report.add(field, "ami %s rewritten to %s for %s in region %s", rewrittenFrom, targetAMI, arch, converter.TargetRegion)
+
+	return pointer.String(targetAMI), rewrittenFrom, nil
+}
+
+func (converter *AWSConverter) labelTranslations() LabelTranslations {
+	if converter.LabelTranslations == nil {
+		return DefaultLabelTranslations
+	}
+	return converter.LabelTranslations
+}
+
+func isEmptyAWSResourceReference(reference mapi.AWSResourceReference) bool {
+	return reference.ID == nil && reference.ARN == nil && len(reference.Filters) == 0
 }
 
+// regionFromAvailabilityZone strips the zone suffix from an availability zone,
+// local zone or wavelength zone name, e.g. us-east-1a or us-east-1-nyc-1a.
+func regionFromAvailabilityZone(zone string) string {
+	return regionPattern.FindString(zone)
+}
+
+// convertAWSTagsToMAPI sorts the tags by name, so the output doesn't depend on
+// map iteration order.
 func convertAWSTagsToMAPI(capiTags capi.Tags) []mapi.TagSpecification {
 	mapiTags := []mapi.TagSpecification{}
-	for key, value := range capiTags {
+	for _, key := range sortedKeys(capiTags) {
 		mapiTags = append(mapiTags, mapi.TagSpecification{
 			Name:  key,
-			Value: value,
+			Value: capiTags[key],
 		})
 	}
 	return mapiTags
 }
 
-func convertAWSTenancyToMAPI(capiTenancy string) mapi.InstanceTenancy {
-	switch capiTenancy {
-	case "default":
-		return mapi.DefaultTenancy
-	case "dedicated":
-		return mapi.DedicatedTenancy
-	default:
-		return mapi.HostTenancy
+func convertAWSIAMInstanceProfileToMAPI(capiInstanceProfile string) *mapi.AWSResourceReference {
+	if capiInstanceProfile == "" {
+		return nil
+	}
+	return &mapi.AWSResourceReference{
+		ID: pointer.String(capiInstanceProfile),
 	}
 }
 
-func convertAWSSecurityGroupstoMAPI(sgs []capi.AWSResourceReference) []mapi.AWSResourceReference {
-	mapiSGs := []mapi.AWSResourceReference{}
-	for _, sg := range sgs {
-		mapiSGs = append(mapiSGs, convertAWSResourceReferenceToMAPI(sg))
+// convertAWSNetworkInterfacesToMAPI validates the pre-created ENIs of a CAPA
+// machine and returns the device index for the MAPI instance. Nothing can be
+// mapped: the MAPI provider config has no field referencing an existing ENI,
+// the MAPI AWS provider always creates the primary interface from the subnet,
+// security groups and device index. An ENI can only be attached to a single
+// instance either, so it couldn't be shared by the machines of a machine set.
+func convertAWSNetworkInterfacesToMAPI(networkInterfaces []string, report *ConversionReport) (int64, error) {
+	if err := validateAWSNetworkInterfaces(networkInterfaces); err != nil {
+		return 0, err
+	}
+
+	for i, networkInterface := range networkInterfaces {
+		report.add(fmt.Sprintf("%s.networkInterfaces[%d]", capiAWSMachineSpecPath, i), "network interface %s has no MAPI equivalent, MAPI can't attach existing interfaces and creates its own primary interface at device index 0, attach the interface to the instance after it is created", networkInterface)
 	}
-	return mapiSGs
+
+	return 0, nil
 }
 
-func convertAWSSpotMarketOptionsToMAPI(capiSpotMarketOptions *capi.SpotMarketOptions) *mapi.SpotMarketOptions {
-	if capiSpotMarketOptions == nil {
-		return nil
+// validateAWSNetworkInterfaces mirrors the MaxItems validation of CAPA.
+func validateAWSNetworkInterfaces(networkInterfaces []string) error {
+	if len(networkInterfaces) > maxNetworkInterfaces {
+		return fmt.Errorf("invalid network interfaces: at most %d may be specified, got %d", maxNetworkInterfaces, len(networkInterfaces))
 	}
-	return &mapi.SpotMarketOptions{
-		MaxPrice: capiSpotMarketOptions.MaxPrice,
+	return nil
+}
+
+// convertKMSKeyToMAPI keeps ARNs apart from key IDs, CAPA takes both in a
+// single field.
+func convertKMSKeyToMAPI(kmsKey string) mapi.AWSResourceReference {
+	switch {
+	case kmsKey == "":
+		return mapi.AWSResourceReference{}
+	case strings.HasPrefix(kmsKey, "arn:"):
+		return mapi.AWSResourceReference{ARN: pointer.String(kmsKey)}
+	default:
+		return mapi.AWSResourceReference{ID: pointer.String(kmsKey)}
 	}
 }
 
-func convertAWSBlockDeviceMappingSpecToMAPI(rootVolume *capi.Volume, nonRootVolumes []capi.Volume) []mapi.BlockDeviceMappingSpec {
-	blockDeviceMapping := []mapi.BlockDeviceMappingSpec{}
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-	blockDeviceMapping = append(blockDeviceMapping, mapi.BlockDeviceMappingSpec{
-		EBS: &mapi.EBSBlockDeviceSpec{
-			VolumeSize: &rootVolume.Size,
-			VolumeType: &rootVolume.Type,
-			Iops:       &rootVolume.IOPS,
-			Encrypted:  &rootVolume.Encrypted,
-			KMSKey:     convertKMSKeyToMAPI(rootVolume.EncryptionKey),
-		},
-	})
+// marshalProviderConfig drops the empty fields the non-pointer structs of the
+// provider config add, e.g. `kmsKey: {}` or `metadata: {creationTimestamp: null}`,
+// so the output only holds what the source set.
+func marshalProviderConfig(mapiProviderConfig *mapi.AWSMachineProviderConfig) (*runtime.RawExtension, error) {
+	rawProviderConfig, err := mapi.RawExtensionFromProviderSpec(mapiProviderConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, volume := range nonRootVolumes {
-		blockDeviceMapping = append(blockDeviceMapping, mapi.BlockDeviceMappingSpec{
-			DeviceName: &volume.DeviceName,
-			EBS: &mapi.EBSBlockDeviceSpec{
-				VolumeSize: &volume.Size,
-				VolumeType: &volume.Type,
-				Iops:       &volume.IOPS,
-				Encrypted:  &volume.Encrypted,
-				KMSKey:     convertKMSKeyToMAPI(volume.EncryptionKey),
-			},
-		})
+	decoder := json.NewDecoder(bytes.NewReader(rawProviderConfig.Raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("error unmarshalling providerSpec: %v", err)
+	}
+	raw, err := json.Marshal(pruneEmptyFields(value))
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling providerSpec: %v", err)
 	}
 
-	return blockDeviceMapping
+	return &runtime.RawExtension{Raw: raw}, nil
 }
 
-func convertKMSKeyToMAPI(kmsKey string) mapi.AWSResourceReference {
-	return mapi.AWSResourceReference{
-		ID: &kmsKey,
+// meaningfulEmptyFields are provider config fields whose empty object means
+// something, e.g. an empty spotMarketOptions requests spot instances.
+var meaningfulEmptyFields = map[string]bool{
+	"spotMarketOptions": true,
+}
+
+// pruneEmptyFields drops nulls and empty objects and lists. Returns nil when
+// nothing is left.
+func pruneEmptyFields(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if meaningfulEmptyFields[key] && field != nil {
+				continue
+			}
+			if field = pruneEmptyFields(field); field == nil {
+				delete(value, key)
+			} else {
+				value[key] = field
+			}
+		}
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	case []interface{}:
+		if len(value) == 0 {
+			return nil
+		}
+		for i, item := range value {
+			value[i] = pruneEmptyFields(item)
+		}
+		return value
+	default:
+		return value
 	}
 }
 
-func convertMachineSetToMAPI(capiMachineSet *capi.MachineSet, rawProviderConfig *runtime.RawExtension) *mapi.MachineSet {
+func convertMachineSetToMAPI(capiMachineSet *capi.MachineSet, rawProviderConfig *runtime.RawExtension, labelTranslations LabelTranslations, propagation MetadataPropagation, report *ConversionReport) (*mapi.MachineSet, error) {
 	mapiMachineSet := &mapi.MachineSet{}
-	mapiMachineSet.ObjectMeta = metav1.ObjectMeta{
-		Name:      capiMachineSet.Name,
-		Namespace: capiMachineSet.Namespace,
-	}
+	mapiMachineSet.ObjectMeta = propagateObjectMeta(capiMachineSet.ObjectMeta, capiMachineSetAPIVersion, propagation, labelTranslations.toMAPI, report)
 	mapiMachineSet.TypeMeta = metav1.TypeMeta{
 		Kind:       mapiMachineSetKind,
 		APIVersion: mapiMachineSetAPIVersion,
 	}
-	mapiMachineSet.Spec.Selector = capiMachineSet.Spec.Selector
-	mapiMachineSet.Spec.Template.Labels = capiMachineSet.Spec.Template.Labels
+	annotations, err := convertAutoscalerAnnotationsToMAPI(mapiMachineSet.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	mapiMachineSet.Annotations = annotations
+	mapiMachineSet.Spec.Selector = translateLabelSelector(capiMachineSet.Spec.Selector, labelTranslations.toMAPI)
+	mapiMachineSet.Spec.Template.ObjectMeta, err = convertMachineTemplateMetaToMAPI(capiMachineSet.Spec.Template.ObjectMeta, propagation, labelTranslations)
+	if err != nil {
+		return nil, err
+	}
+	if capiMachineSet.Spec.ClusterName != "" {
+		ensureSelectedLabel(&mapiMachineSet.Spec.Selector, &mapiMachineSet.Spec.Template.Labels, mapi.MachineClusterIDLabel, capiMachineSet.Spec.ClusterName)
+	}
 	mapiMachineSet.Spec.Replicas = capiMachineSet.Spec.Replicas
+	mapiMachineSet.Spec.MinReadySeconds = capiMachineSet.Spec.MinReadySeconds
+	mapiMachineSet.Spec.DeletePolicy, err = convertDeletePolicy(capiMachineSet.Spec.DeletePolicy)
+	if err != nil {
+		return nil, err
+	}
+	if capiMachineSet.Spec.Template.Spec.NodeDrainTimeout != nil {
+		report.add("spec.template.spec.nodeDrainTimeout", "MAPI machines drain until all pods are evicted, the %s timeout was dropped", capiMachineSet.Spec.Template.Spec.NodeDrainTimeout.Duration)
+	}
 	mapiMachineSet.Spec.Template.Spec.ProviderSpec = mapi.ProviderSpec{
 		Value: rawProviderConfig,
 	}
 
-	return mapiMachineSet
+	if errs := mapiMachineSet.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid machineset after label translation: %v", errs.ToAggregate())
+	}
+
+	return mapiMachineSet, nil
 }
@@ -2,6 +2,7 @@ package converter
 
 import (
 	"testing"
+	"time"
 
 	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
 	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
@@ -9,6 +10,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
 )
 
 func TestConvertProviderConfigToAWSMachineTemplate(t *testing.T) {
@@ -74,24 +76,36 @@ func TestConvertProviderConfigToAWSMachineTemplate(t *testing.T) {
 		},
 	}
 
-	capiAWSMachineTemplate := convertProviderConfigToAWSMachineTemplate(name, namespace, mapiProviderConfig)
+	capiAWSMachineTemplate, err := convertProviderConfigToAWSMachineTemplate(name, namespace, mapiProviderConfig, BootstrapOptions{}, nil)
+	g.Expect(err).NotTo(HaveOccurred())
 
 	g.Expect(capiAWSMachineTemplate).ToNot(BeNil())
-	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.AMI).To(Equal(convertAWSResourceReferenceToCAPI(mapiProviderConfig.AMI)))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.AMI).To(Equal(capi.AWSResourceReference{ID: pointer.String("testID")}))
 	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.InstanceType).To(Equal(mapiProviderConfig.InstanceType))
-	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.AdditionalTags).To(Equal(convertAWSTagsToCAPI(mapiProviderConfig.Tags)))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.AdditionalTags).To(Equal(capi.Tags{"testName": "testValue"}))
 	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.IAMInstanceProfile).To(Equal(*mapiProviderConfig.IAMInstanceProfile.ID))
 	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.SSHKeyName).To(Equal(mapiProviderConfig.KeyName))
 	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.PublicIP).To(Equal(mapiProviderConfig.PublicIP))
 	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.FailureDomain).To(Equal(&mapiProviderConfig.Placement.AvailabilityZone))
 	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.Tenancy).To(Equal(string(mapiProviderConfig.Placement.Tenancy)))
-	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.AdditionalSecurityGroups).To(Equal(convertAWSSecurityGroupstoCAPI(mapiProviderConfig.SecurityGroups)))
-	capiSubnet := convertAWSResourceReferenceToCAPI(mapiProviderConfig.Subnet)
-	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.Subnet).To(Equal(&capiSubnet))
-	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.SpotMarketOptions).To(Equal(convertAWSSpotMarketOptionsToCAPI(mapiProviderConfig.SpotMarketOptions)))
-	rootVolume, nonRootVolumes := convertAWSBlockDeviceMappingSpecToCAPI(mapiProviderConfig.BlockDevices)
-	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.RootVolume).To(Equal(rootVolume))
-	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.NonRootVolumes).To(Equal(nonRootVolumes))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.AdditionalSecurityGroups).To(Equal([]capi.AWSResourceReference{{ID: pointer.String("testID")}}))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.Subnet).To(Equal(&capi.AWSResourceReference{ID: pointer.String("testID")}))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.SpotMarketOptions).To(Equal(&capi.SpotMarketOptions{MaxPrice: pointer.String("1")}))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.RootVolume).To(Equal(&capi.Volume{
+		Size:          1,
+		Type:          "type1",
+		IOPS:          1,
+		EncryptionKey: "test1",
+	}))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.NonRootVolumes).To(Equal([]capi.Volume{
+		{
+			DeviceName:    "nonrootdevice",
+			Size:          2,
+			Type:          "type2",
+			IOPS:          2,
+			EncryptionKey: "test2",
+		},
+	}))
 	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.CloudInit).To(Equal(capi.CloudInit{
 		InsecureSkipSecretsManager: false,
 		SecureSecretsBackend:       capi.SecretBackendSecretsManager,
@@ -107,80 +121,134 @@ func TestConvertMachineSetToCAPI(t *testing.T) {
 			Namespace: "testNamespace",
 		},
 		Spec: mapi.MachineSetSpec{
-			Replicas: pointer.Int32(1),
+			Replicas:        pointer.Int32(1),
+			MinReadySeconds: 30,
+			DeletePolicy:    string(mapi.OldestMachineSetDeletePolicy),
 			Selector: metav1.LabelSelector{
 				MatchLabels: map[string]string{"label": "value"},
 			},
 			Template: mapi.MachineTemplateSpec{
-				Spec: mapi.MachineSpec{
-					ObjectMeta: mapi.ObjectMeta{
-						Labels: map[string]string{"label": "value"},
-					},
+				ObjectMeta: mapi.ObjectMeta{
+					Labels:      map[string]string{"label": "value"},
+					Annotations: map[string]string{mapiExcludeNodeDrainingAnnotation: ""},
 				},
 			},
 		},
 	}
 
-	capiMachineSet := convertMachineSetToCAPI(mapiMachineSet)
+	capiMachineSet, err := convertMachineSetToCAPI(mapiMachineSet, DefaultLabelTranslations, MetadataPropagation{}, &ConversionReport{})
+	g.Expect(err).NotTo(HaveOccurred())
 
 	g.Expect(capiMachineSet.Name).To(Equal(mapiMachineSet.Name))
 	g.Expect(capiMachineSet.Namespace).To(Equal(mapiMachineSet.Namespace))
 	g.Expect(capiMachineSet.Kind).To(Equal(capiMachineSetKind))
 	g.Expect(capiMachineSet.APIVersion).To(Equal(capiMachineSetAPIVersion))
-	g.Expect(capiMachineSet.Spec.Template.Labels).To(Equal(mapiMachineSet.Spec.Template.Labels))
+	g.Expect(capiMachineSet.Spec.Selector.MatchLabels).To(Equal(map[string]string{"label": "value", capi.MachineSetLabelName: mapiMachineSet.Name}))
+	g.Expect(capiMachineSet.Spec.Template.Labels).To(Equal(map[string]string{"label": "value", capi.MachineSetLabelName: mapiMachineSet.Name}))
+	g.Expect(capiMachineSet.Spec.Template.Annotations).To(Equal(map[string]string{capi.ExcludeNodeDrainingAnnotation: ""}))
 	g.Expect(capiMachineSet.Spec.Replicas).To(Equal(mapiMachineSet.Spec.Replicas))
+	g.Expect(capiMachineSet.Spec.MinReadySeconds).To(Equal(mapiMachineSet.Spec.MinReadySeconds))
+	g.Expect(capiMachineSet.Spec.DeletePolicy).To(Equal(string(capi.OldestMachineSetDeletePolicy)))
 	g.Expect(capiMachineSet.Spec.Template.Spec.Bootstrap.DataSecretName).To(Equal(pointer.StringPtr(workerUserDataSecretName)))
 	g.Expect(capiMachineSet.Spec.Template.Spec.InfrastructureRef).To(Equal(corev1.ObjectReference{
 		APIVersion: awsTemplateAPIVersion,
 		Kind:       awsTemplateKind,
 		Name:       mapiMachineSet.Name,
 	}))
+
+	providerSpec, err := mapi.RawExtensionFromProviderSpec(&mapi.AWSMachineProviderConfig{
+		UserDataSecret: &corev1.LocalObjectReference{Name: "worker-user-data-managed"},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	mapiMachineSet.Spec.Template.Spec.ProviderSpec.Value = providerSpec
+	capiMachineSet, err = convertMachineSetToCAPI(mapiMachineSet, DefaultLabelTranslations, MetadataPropagation{}, &ConversionReport{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachineSet.Spec.Template.Spec.Bootstrap.DataSecretName).To(Equal(pointer.StringPtr("worker-user-data-managed")))
 }
 
-func TestConvertAWSResourceReferenceToCAPI(t *testing.T) {
+func TestConvertAWSIAMInstanceProfileToCAPI(t *testing.T) {
 	g := NewWithT(t)
 
-	mapiAWSResourceRefence := mapi.AWSResourceReference{
-		ID:  pointer.StringPtr("testID"),
-		ARN: pointer.StringPtr("testARN"),
+	name, err := convertAWSIAMInstanceProfileToCAPI(nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(name).To(Equal(""))
+
+	name, err = convertAWSIAMInstanceProfileToCAPI(&mapi.AWSResourceReference{
+		ID: pointer.String("testProfile"),
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(name).To(Equal("testProfile"))
+
+	name, err = convertAWSIAMInstanceProfileToCAPI(&mapi.AWSResourceReference{
+		ARN: pointer.String("arn:aws:iam::123456789012:instance-profile/testProfile"),
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(name).To(Equal("testProfile"))
+
+	name, err = convertAWSIAMInstanceProfileToCAPI(&mapi.AWSResourceReference{
+		ARN: pointer.String("arn:aws-us-gov:iam::123456789012:instance-profile/some/path/testProfile"),
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(name).To(Equal("testProfile"))
+
+	_, err = convertAWSIAMInstanceProfileToCAPI(&mapi.AWSResourceReference{
+		ARN: pointer.String("arn:aws:iam::123456789012:role/testRole"),
+	})
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = convertAWSIAMInstanceProfileToCAPI(&mapi.AWSResourceReference{
+		ARN: pointer.String("testProfile"),
+	})
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = convertAWSIAMInstanceProfileToCAPI(&mapi.AWSResourceReference{
 		Filters: []mapi.Filter{
 			{
-				Name:   "testName",
-				Values: []string{"val"},
+				Name:   "tag:Name",
+				Values: []string{"testProfile"},
 			},
 		},
-	}
+	})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestConvertAWSDeviceIndexToCAPI(t *testing.T) {
+	g := NewWithT(t)
 
-	capiAWSResourceRefence := convertAWSResourceReferenceToCAPI(mapiAWSResourceRefence)
+	report := &ConversionReport{}
+	convertAWSDeviceIndexToCAPI(0, report)
+	g.Expect(report.Entries).To(BeEmpty())
 
-	g.Expect(capiAWSResourceRefence.ID).To(Equal(mapiAWSResourceRefence.ID))
-	g.Expect(capiAWSResourceRefence.ARN).To(Equal(mapiAWSResourceRefence.ARN))
-	g.Expect(len(capiAWSResourceRefence.Filters)).To(Equal(len(mapiAWSResourceRefence.Filters)))
-	g.Expect(capiAWSResourceRefence.Filters[0].Name).To(Equal(mapiAWSResourceRefence.Filters[0].Name))
-	g.Expect(capiAWSResourceRefence.Filters[0].Values).To(Equal(mapiAWSResourceRefence.Filters[0].Values))
+	convertAWSDeviceIndexToCAPI(1, report)
+	g.Expect(report.Entries).To(HaveLen(1))
+	g.Expect(report.Entries[0].Field).To(Equal("spec.template.spec.providerSpec.value.deviceIndex"))
 }
 
-func TestConvertAWSFiltersToCAPI(t *testing.T) {
+func TestConvertAWSBlockDevicesToCAPI(t *testing.T) {
 	g := NewWithT(t)
 
-	mapiAWSFilters := []mapi.Filter{
+	report := &ConversionReport{}
+	convertAWSBlockDevicesToCAPI([]mapi.BlockDeviceMappingSpec{
+		{EBS: &mapi.EBSBlockDeviceSpec{VolumeSize: pointer.Int64(120)}},
+		{DeviceName: pointer.String("/dev/xvdb"), EBS: &mapi.EBSBlockDeviceSpec{VolumeSize: pointer.Int64(50)}},
+	}, report)
+	g.Expect(report.Entries).To(BeEmpty())
+
+	convertAWSBlockDevicesToCAPI([]mapi.BlockDeviceMappingSpec{
+		{DeviceName: pointer.String("/dev/xvdb"), EBS: &mapi.EBSBlockDeviceSpec{VolumeSize: pointer.Int64(50)}},
+		{DeviceName: pointer.String("/dev/xvdc"), VirtualName: pointer.String("ephemeral0")},
+		{DeviceName: pointer.String("/dev/xvdd"), EBS: &mapi.EBSBlockDeviceSpec{VolumeType: pointer.String("gp3")}},
+	}, report)
+	g.Expect(report.Entries).To(Equal([]ReportEntry{
 		{
-			Name:   "testName1",
-			Values: []string{"val1"},
+			Field:   "spec.template.spec.providerSpec.value.blockDevices[1]",
+			Message: "block device /dev/xvdc has no EBS volume size, CAPA volumes need a size of at least 8 GiB, the device was dropped",
 		},
 		{
-			Name:   "testName2",
-			Values: []string{"val2"},
+			Field:   "spec.template.spec.providerSpec.value.blockDevices[2]",
+			Message: "block device /dev/xvdd has no EBS volume size, CAPA volumes need a size of at least 8 GiB, the device was dropped",
 		},
-	}
-
-	capiAWSFilters := convertAWSFiltersToCAPI(mapiAWSFilters)
-
-	g.Expect(len(capiAWSFilters)).To(Equal(len(mapiAWSFilters)))
-	g.Expect(capiAWSFilters[0].Name).To(Equal(mapiAWSFilters[0].Name))
-	g.Expect(capiAWSFilters[0].Values).To(Equal(mapiAWSFilters[0].Values))
-	g.Expect(capiAWSFilters[1].Name).To(Equal(mapiAWSFilters[1].Name))
-	g.Expect(capiAWSFilters[1].Values).To(Equal(mapiAWSFilters[1].Values))
+	}))
 }
 
 func TestConvertAWSTagsToCAPI(t *testing.T) {
@@ -204,111 +272,6 @@ func TestConvertAWSTagsToCAPI(t *testing.T) {
 	g.Expect(capiAWSTags).To(HaveKeyWithValue(mapiAWSTags[1].Name, mapiAWSTags[1].Value))
 }
 
-func TestConvertAWSSecurityGroupstoCAPI(t *testing.T) {
-	g := NewWithT(t)
-
-	mapiSGs := []mapi.AWSResourceReference{
-		{
-			ID:  pointer.StringPtr("testID1"),
-			ARN: pointer.StringPtr("testARN1"),
-			Filters: []mapi.Filter{
-				{
-					Name:   "testName1",
-					Values: []string{"val1"},
-				},
-			},
-		},
-		{
-			ID:  pointer.StringPtr("testID2"),
-			ARN: pointer.StringPtr("testARN2"),
-			Filters: []mapi.Filter{
-				{
-					Name:   "testName2",
-					Values: []string{"val2"},
-				},
-			},
-		},
-	}
-
-	capiSGs := convertAWSSecurityGroupstoCAPI(mapiSGs)
-
-	g.Expect(len(capiSGs)).To(Equal(len(mapiSGs)))
-	g.Expect(capiSGs[0].ID).To(Equal(mapiSGs[0].ID))
-	g.Expect(capiSGs[0].ARN).To(Equal(mapiSGs[0].ARN))
-	g.Expect(len(capiSGs[0].Filters)).To(Equal(len(mapiSGs[0].Filters)))
-	g.Expect(capiSGs[0].Filters[0].Name).To(Equal(mapiSGs[0].Filters[0].Name))
-	g.Expect(capiSGs[0].Filters[0].Values).To(Equal(mapiSGs[0].Filters[0].Values))
-	g.Expect(capiSGs[1].ID).To(Equal(mapiSGs[1].ID))
-	g.Expect(capiSGs[1].ARN).To(Equal(mapiSGs[1].ARN))
-	g.Expect(len(capiSGs[1].Filters)).To(Equal(len(mapiSGs[1].Filters)))
-	g.Expect(capiSGs[1].Filters[0].Name).To(Equal(mapiSGs[1].Filters[0].Name))
-	g.Expect(capiSGs[1].Filters[0].Values).To(Equal(mapiSGs[1].Filters[0].Values))
-}
-
-func TestConvertAWSSpotMarketOptionsToCAPI(t *testing.T) {
-	g := NewWithT(t)
-
-	capiSpotMarketOptions := convertAWSSpotMarketOptionsToCAPI(nil)
-	g.Expect(capiSpotMarketOptions).To(BeNil())
-
-	mapiSpotMarketOptions := &mapi.SpotMarketOptions{
-		MaxPrice: pointer.String("1"),
-	}
-
-	capiSpotMarketOptions = convertAWSSpotMarketOptionsToCAPI(mapiSpotMarketOptions)
-	g.Expect(capiSpotMarketOptions).ToNot(BeNil())
-	g.Expect(capiSpotMarketOptions.MaxPrice).To(Equal(mapiSpotMarketOptions.MaxPrice))
-}
-
-func TestConvertAWSBlockDeviceMappingSpecToCAPI(t *testing.T) {
-	g := NewWithT(t)
-
-	mapiRootVolume := mapi.BlockDeviceMappingSpec{
-		EBS: &mapi.EBSBlockDeviceSpec{
-			VolumeSize: pointer.Int64(1),
-			VolumeType: pointer.String("type1"),
-			Iops:       pointer.Int64(1),
-			Encrypted:  pointer.Bool(false),
-			KMSKey: mapi.AWSResourceReference{
-				ID: pointer.String("test1"),
-			},
-		},
-	}
-
-	mapiNonRootVolume := mapi.BlockDeviceMappingSpec{
-		DeviceName: pointer.String("nonrootdevice"),
-		EBS: &mapi.EBSBlockDeviceSpec{
-			VolumeSize: pointer.Int64(2),
-			VolumeType: pointer.String("type2"),
-			Iops:       pointer.Int64(2),
-			Encrypted:  pointer.Bool(false),
-			KMSKey: mapi.AWSResourceReference{
-				ID: pointer.String("test2"),
-			},
-		},
-	}
-
-	mapiBlockDeviceMapping := []mapi.BlockDeviceMappingSpec{mapiRootVolume, mapiNonRootVolume}
-
-	capiRootVolume, capiNonRootVolumes := convertAWSBlockDeviceMappingSpecToCAPI(mapiBlockDeviceMapping)
-
-	g.Expect(capiRootVolume).ToNot(BeNil())
-	g.Expect(capiRootVolume.DeviceName).To(Equal(""))
-	g.Expect(capiRootVolume.Encrypted).To(Equal(*mapiRootVolume.EBS.Encrypted))
-	g.Expect(capiRootVolume.EncryptionKey).To(Equal(*mapiRootVolume.EBS.KMSKey.ID))
-	g.Expect(capiRootVolume.IOPS).To(Equal(*mapiRootVolume.EBS.Iops))
-	g.Expect(capiRootVolume.Size).To(Equal(*mapiRootVolume.EBS.VolumeSize))
-	g.Expect(capiRootVolume.Type).To(Equal(*mapiRootVolume.EBS.VolumeType))
-
-	g.Expect(len(capiNonRootVolumes)).To(Equal(1))
-	g.Expect(capiNonRootVolumes[0].DeviceName).To(Equal(*mapiNonRootVolume.DeviceName))
-	g.Expect(capiNonRootVolumes[0].Encrypted).To(Equal(*mapiNonRootVolume.EBS.Encrypted))
-	g.Expect(capiNonRootVolumes[0].EncryptionKey).To(Equal(*mapiNonRootVolume.EBS.KMSKey.ID))
-	g.Expect(capiNonRootVolumes[0].IOPS).To(Equal(*mapiNonRootVolume.EBS.Iops))
-	g.Expect(capiNonRootVolumes[0].Size).To(Equal(*mapiNonRootVolume.EBS.VolumeSize))
-	g.Expect(capiNonRootVolumes[0].Type).To(Equal(*mapiNonRootVolume.EBS.VolumeType))
-}
-
 func TestConvertKMSKeyToCAPI(t *testing.T) {
 	g := NewWithT(t)
 
@@ -377,22 +340,40 @@ func TestConvertAWSMachineTemplateToMAPI(t *testing.T) {
 		},
 	}
 
-	mapiProviderConfig := convertAWSMachineTemplateToroviderConfig(capiAWSMachineTemplate)
+	mapiProviderConfig, err := convertAWSMachineTemplateToroviderConfig(capiAWSMachineTemplate, nil)
+	g.Expect(err).NotTo(HaveOccurred())
 
 	g.Expect(mapiProviderConfig).ToNot(BeNil())
-	g.Expect(mapiProviderConfig.AMI).To(Equal(convertAWSResourceReferenceToMAPI(capiAWSMachineTemplate.Spec.Template.Spec.AMI)))
+	g.Expect(mapiProviderConfig.AMI).To(Equal(mapi.AWSResourceReference{ID: pointer.String("test1")}))
 	g.Expect(mapiProviderConfig.InstanceType).To(Equal(capiAWSMachineTemplate.Spec.Template.Spec.InstanceType))
-	g.Expect(mapiProviderConfig.Tags).To(Equal(convertAWSTagsToMAPI(capiAWSMachineTemplate.Spec.Template.Spec.AdditionalTags)))
+	g.Expect(mapiProviderConfig.Tags).To(Equal([]mapi.TagSpecification{{Name: "testName", Value: "testValue"}}))
 	g.Expect(*mapiProviderConfig.IAMInstanceProfile.ID).To(Equal(capiAWSMachineTemplate.Spec.Template.Spec.IAMInstanceProfile))
 	g.Expect(mapiProviderConfig.KeyName).To(Equal(capiAWSMachineTemplate.Spec.Template.Spec.SSHKeyName))
 	g.Expect(mapiProviderConfig.PublicIP).To(Equal(capiAWSMachineTemplate.Spec.Template.Spec.PublicIP))
 	g.Expect(&mapiProviderConfig.Placement.AvailabilityZone).To(Equal(capiAWSMachineTemplate.Spec.Template.Spec.FailureDomain))
 	g.Expect(string(mapiProviderConfig.Placement.Tenancy)).To(Equal(capiAWSMachineTemplate.Spec.Template.Spec.Tenancy))
-	g.Expect(mapiProviderConfig.SecurityGroups).To(Equal(convertAWSSecurityGroupstoMAPI(capiAWSMachineTemplate.Spec.Template.Spec.AdditionalSecurityGroups)))
-	mapiSubnet := convertAWSResourceReferenceToMAPI(*capiAWSMachineTemplate.Spec.Template.Spec.Subnet)
-	g.Expect(mapiSubnet).To(Equal(mapiProviderConfig.Subnet))
-	g.Expect(mapiProviderConfig.SpotMarketOptions).To(Equal(convertAWSSpotMarketOptionsToMAPI(capiAWSMachineTemplate.Spec.Template.Spec.SpotMarketOptions)))
-	g.Expect(mapiProviderConfig.BlockDevices).To(Equal(convertAWSBlockDeviceMappingSpecToMAPI(capiAWSMachineTemplate.Spec.Template.Spec.RootVolume, capiAWSMachineTemplate.Spec.Template.Spec.NonRootVolumes)))
+	g.Expect(mapiProviderConfig.SecurityGroups).To(Equal([]mapi.AWSResourceReference{{ID: pointer.String("testID")}}))
+	g.Expect(mapiProviderConfig.Subnet).To(Equal(mapi.AWSResourceReference{ID: pointer.String("testID")}))
+	g.Expect(mapiProviderConfig.SpotMarketOptions).To(Equal(&mapi.SpotMarketOptions{MaxPrice: pointer.String("1")}))
+	g.Expect(mapiProviderConfig.BlockDevices).To(Equal([]mapi.BlockDeviceMappingSpec{
+		{
+			EBS: &mapi.EBSBlockDeviceSpec{
+				VolumeSize: pointer.Int64(1),
+				VolumeType: pointer.String("type1"),
+				Iops:       pointer.Int64(1),
+				KMSKey:     mapi.AWSResourceReference{ID: pointer.String("test1")},
+			},
+		},
+		{
+			DeviceName: pointer.String("nonrootdevice"),
+			EBS: &mapi.EBSBlockDeviceSpec{
+				VolumeSize: pointer.Int64(2),
+				VolumeType: pointer.String("type2"),
+				Iops:       pointer.Int64(2),
+				KMSKey:     mapi.AWSResourceReference{ID: pointer.String("test2")},
+			},
+		},
+	}))
 }
 
 func TestConvertMachineSetToMAPI(t *testing.T) {
@@ -404,13 +385,19 @@ func TestConvertMachineSetToMAPI(t *testing.T) {
 			Namespace: "testNamespace",
 		},
 		Spec: capi.MachineSetSpec{
-			Replicas: pointer.Int32(1),
+			Replicas:        pointer.Int32(1),
+			MinReadySeconds: 30,
+			DeletePolicy:    string(capi.NewestMachineSetDeletePolicy),
 			Selector: metav1.LabelSelector{
 				MatchLabels: map[string]string{"label": "value"},
 			},
 			Template: capi.MachineTemplateSpec{
 				ObjectMeta: capi.ObjectMeta{
-					Labels: map[string]string{"label": "value"},
+					Labels:      map[string]string{"label": "value"},
+					Annotations: map[string]string{capi.ExcludeNodeDrainingAnnotation: ""},
+				},
+				Spec: capi.MachineSpec{
+					NodeDrainTimeout: &metav1.Duration{Duration: 10 * time.Minute},
 				},
 			},
 		},
@@ -421,172 +408,408 @@ func TestConvertMachineSetToMAPI(t *testing.T) {
 	})
 	g.Expect(err).NotTo(HaveOccurred())
 
-	mapiMachineSet := convertMachineSetToMAPI(capiMachineSet, rawProviderConfig)
+	report := &ConversionReport{}
+	mapiMachineSet, err := convertMachineSetToMAPI(capiMachineSet, rawProviderConfig, DefaultLabelTranslations, MetadataPropagation{}, report)
+	g.Expect(err).NotTo(HaveOccurred())
 
 	g.Expect(capiMachineSet.Name).To(Equal(mapiMachineSet.Name))
 	g.Expect(capiMachineSet.Namespace).To(Equal(mapiMachineSet.Namespace))
 	g.Expect(mapiMachineSet.Kind).To(Equal(mapiMachineSetKind))
 	g.Expect(mapiMachineSet.APIVersion).To(Equal(mapiMachineSetAPIVersion))
 	g.Expect(capiMachineSet.Spec.Template.Labels).To(Equal(mapiMachineSet.Spec.Template.Labels))
+	g.Expect(mapiMachineSet.Spec.Template.Annotations).To(Equal(map[string]string{mapiExcludeNodeDrainingAnnotation: ""}))
 	g.Expect(capiMachineSet.Spec.Replicas).To(Equal(mapiMachineSet.Spec.Replicas))
+	g.Expect(capiMachineSet.Spec.MinReadySeconds).To(Equal(mapiMachineSet.Spec.MinReadySeconds))
+	g.Expect(mapiMachineSet.Spec.DeletePolicy).To(Equal(string(mapi.NewestMachineSetDeletePolicy)))
 	g.Expect(rawProviderConfig).To(Equal(mapiMachineSet.Spec.Template.Spec.ProviderSpec.Value))
+	g.Expect(report.Entries).To(HaveLen(1))
+	g.Expect(report.Entries[0].Field).To(Equal("spec.template.spec.nodeDrainTimeout"))
 }
 
-func TestConvertAWSResourceReferenceToMAPI(t *testing.T) {
+func TestConvertDeletePolicy(t *testing.T) {
 	g := NewWithT(t)
 
-	capiAWSResourceRefence := capi.AWSResourceReference{
-		ID:  pointer.StringPtr("testID"),
-		ARN: pointer.StringPtr("testARN"),
-		Filters: []capi.Filter{
-			{
-				Name:   "testName",
-				Values: []string{"val"},
-			},
-		},
+	for _, deletePolicy := range []string{"", "Random", "Newest", "Oldest"} {
+		converted, err := convertDeletePolicy(deletePolicy)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(converted).To(Equal(deletePolicy))
 	}
 
-	mapiAWSResourceRefence := convertAWSResourceReferenceToMAPI(capiAWSResourceRefence)
-
-	g.Expect(capiAWSResourceRefence.ID).To(Equal(mapiAWSResourceRefence.ID))
-	g.Expect(capiAWSResourceRefence.ARN).To(Equal(mapiAWSResourceRefence.ARN))
-	g.Expect(len(capiAWSResourceRefence.Filters)).To(Equal(len(mapiAWSResourceRefence.Filters)))
-	g.Expect(capiAWSResourceRefence.Filters[0].Name).To(Equal(mapiAWSResourceRefence.Filters[0].Name))
-	g.Expect(capiAWSResourceRefence.Filters[0].Values).To(Equal(mapiAWSResourceRefence.Filters[0].Values))
+	_, err := convertDeletePolicy("random")
+	g.Expect(err).To(HaveOccurred())
 }
 
-func TestConvertAWSFiltersToMAPI(t *testing.T) {
+func TestResolveAWSImageLookup(t *testing.T) {
 	g := NewWithT(t)
 
-	capiAWSFilters := []capi.Filter{
-		{
-			Name:   "testName1",
-			Values: []string{"val1"},
+	capiMachineSet := &capi.MachineSet{
+		Spec: capi.MachineSetSpec{
+			Template: capi.MachineTemplateSpec{
+				Spec: capi.MachineSpec{
+					Version: pointer.String("v1.21.2"),
+				},
+			},
 		},
-		{
-			Name:   "testName2",
-			Values: []string{"val2"},
+	}
+	capiAWSMachineTemplate := &capi.AWSMachineTemplate{
+		Spec: capi.AWSMachineTemplateSpec{
+			Template: capi.AWSMachineTemplateResource{
+				Spec: capi.AWSMachineSpec{
+					ImageLookupFormat: "rhcos-*",
+					InstanceType:      "m5.large",
+					FailureDomain:     pointer.String("us-east-1a"),
+				},
+			},
 		},
 	}
 
-	mapiAWSFilters := convertAWSFiltersToMAPI(capiAWSFilters)
+	converter := &AWSConverter{}
+	_, err := converter.resolveAWSImageLookup(capiMachineSet, capiAWSMachineTemplate)
+	g.Expect(err).To(HaveOccurred())
+
+	converter.ImageCatalogFile = []byte(`{
+  "architectures": {
+    "x86_64": {
+      "images": {
+        "aws": {
+          "regions": {
+            "us-east-1": {"release": "48.84.202109241901-0", "image": "ami-east"},
+            "eu-west-1": {"release": "48.84.202109241901-0", "image": "ami-west"}
+          }
+        }
+      }
+    },
+    "aarch64": {
+      "images": {
+        "aws": {
+          "regions": {
+            "us-east-1": {"release": "48.84.202109241901-0", "image": "ami-arm-east"}
+          }
+        }
+      }
+    }
+  }
+}`)
+	ami, err := converter.resolveAWSImageLookup(capiMachineSet, capiAWSMachineTemplate)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ami.ID).To(Equal(pointer.String("ami-east")))
+	g.Expect(converter.report.Entries).To(HaveLen(1))
+
+	capiAWSMachineTemplate.Spec.Template.Spec.InstanceType = "m6g.large"
+	ami, err = converter.resolveAWSImageLookup(capiMachineSet, capiAWSMachineTemplate)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ami.ID).To(Equal(pointer.String("ami-arm-east")))
 
-	g.Expect(len(capiAWSFilters)).To(Equal(len(mapiAWSFilters)))
-	g.Expect(capiAWSFilters[0].Name).To(Equal(mapiAWSFilters[0].Name))
-	g.Expect(capiAWSFilters[0].Values).To(Equal(mapiAWSFilters[0].Values))
-	g.Expect(capiAWSFilters[1].Name).To(Equal(mapiAWSFilters[1].Name))
-	g.Expect(capiAWSFilters[1].Values).To(Equal(mapiAWSFilters[1].Values))
+	converter.Architecture = "x86_64"
+	ami, err = converter.resolveAWSImageLookup(capiMachineSet, capiAWSMachineTemplate)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ami.ID).To(Equal(pointer.String("ami-east")))
+
+	converter.Region = "eu-west-1"
+	ami, err = converter.resolveAWSImageLookup(capiMachineSet, capiAWSMachineTemplate)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ami.ID).To(Equal(pointer.String("ami-west")))
+
+	converter.Region = "ap-south-1"
+	_, err = converter.resolveAWSImageLookup(capiMachineSet, capiAWSMachineTemplate)
+	g.Expect(err).To(HaveOccurred())
 }
 
-func TestConvertAWSTagsToMAPI(t *testing.T) {
+const testStreamMetadata = `{
+  "architectures": {
+    "x86_64": {
+      "images": {
+        "aws": {
+          "regions": {
+            "us-east-1": {"release": "48.84.202109241901-0", "image": "ami-x86-east"},
+            "eu-west-1": {"release": "48.84.202109241901-0", "image": "ami-x86-west"}
+          }
+        }
+      }
+    },
+    "aarch64": {
+      "images": {
+        "aws": {
+          "regions": {
+            "eu-west-1": {"release": "48.84.202109241901-0", "image": "ami-arm-west"}
+          }
+        }
+      }
+    }
+  }
+}`
+
+func TestRewriteAMIForTargetRegion(t *testing.T) {
 	g := NewWithT(t)
 
-	capiAWSTags := map[string]string{"tag1": "val1", "tag2": "val2"}
+	converter := &AWSConverter{}
+	amiID, rewrittenFrom, err := converter.rewriteAMIForTargetRegion(pointer.String("ami-x86-east"), "ami")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(amiID).To(Equal(pointer.String("ami-x86-east")))
+	g.Expect(rewrittenFrom).To(BeEmpty())
+
+	converter.StreamMetadataFile = []byte(testStreamMetadata)
+	converter.TargetRegion = "eu-west-1"
+	amiID, rewrittenFrom, err = converter.rewriteAMIForTargetRegion(pointer.String("ami-x86-east"), "ami")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(amiID).To(Equal(pointer.String("ami-x86-west")))
+	g.Expect(rewrittenFrom).To(Equal("ami-x86-east (us-east-1)"))
+	g.Expect(converter.report.Entries).To(HaveLen(1))
 
-	mapiAWSTags := convertAWSTagsToMAPI(capiAWSTags)
+	amiID, rewrittenFrom, err = converter.rewriteAMIForTargetRegion(pointer.String("ami-x86-west"), "ami")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(amiID).To(Equal(pointer.String("ami-x86-west")))
+	g.Expect(rewrittenFrom).To(BeEmpty())
 
-	g.Expect(len(capiAWSTags)).To(Equal(len(mapiAWSTags)))
-	g.Expect(capiAWSTags).To(HaveKeyWithValue(mapiAWSTags[0].Name, mapiAWSTags[0].Value))
-	g.Expect(capiAWSTags).To(HaveKeyWithValue(mapiAWSTags[1].Name, mapiAWSTags[1].Value))
+	_, _, err = converter.rewriteAMIForTargetRegion(pointer.String("ami-custom"), "ami")
+	g.Expect(err).To(HaveOccurred())
+
+	converter.Architecture = "aarch64"
+	amiID, rewrittenFrom, err = converter.rewriteAMIForTargetRegion(pointer.String("ami-custom"), "ami")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(amiID).To(Equal(pointer.String("ami-arm-west")))
+	g.Expect(rewrittenFrom).To(Equal("ami-custom"))
+
+	converter.TargetRegion = "us-east-1"
+	_, _, err = converter.rewriteAMIForTargetRegion(pointer.String("ami-x86-west"), "ami")
+	g.Expect(err).To(HaveOccurred())
 }
 
-func TestConvertAWSSecurityGroupstoMAPI(t *testing.T) {
+func TestToCAPIRewritesAMIForTargetRegion(t *testing.T) {
 	g := NewWithT(t)
 
-	capiSGs := []capi.AWSResourceReference{
-		{
-			ID:  pointer.StringPtr("testID1"),
-			ARN: pointer.StringPtr("testARN1"),
-			Filters: []capi.Filter{
-				{
-					Name:   "testName1",
-					Values: []string{"val1"},
-				},
-			},
-		},
-		{
-			ID:  pointer.StringPtr("testID2"),
-			ARN: pointer.StringPtr("testARN2"),
-			Filters: []capi.Filter{
-				{
-					Name:   "testName2",
-					Values: []string{"val2"},
-				},
-			},
-		},
+	converter := &AWSConverter{
+		MachineSetFile: []byte(`apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: worker
+  namespace: openshift-machine-api
+spec:
+  template:
+    spec:
+      providerSpec:
+        value:
+          ami:
+            id: ami-x86-east
+          placement:
+            availabilityZone: us-east-1a
+            region: us-east-1
+          subnet:
+            id: subnet-east
+`),
+		StreamMetadataFile: []byte(testStreamMetadata),
+		TargetRegion:       "eu-west-1",
 	}
 
-	mapiSGs := convertAWSSecurityGroupstoMAPI(capiSGs)
-
-	g.Expect(len(capiSGs)).To(Equal(len(mapiSGs)))
-	g.Expect(capiSGs[0].ID).To(Equal(mapiSGs[0].ID))
-	g.Expect(capiSGs[0].ARN).To(Equal(mapiSGs[0].ARN))
-	g.Expect(len(capiSGs[0].Filters)).To(Equal(len(mapiSGs[0].Filters)))
-	g.Expect(capiSGs[0].Filters[0].Name).To(Equal(mapiSGs[0].Filters[0].Name))
-	g.Expect(capiSGs[0].Filters[0].Values).To(Equal(mapiSGs[0].Filters[0].Values))
-	g.Expect(capiSGs[1].ID).To(Equal(mapiSGs[1].ID))
-	g.Expect(capiSGs[1].ARN).To(Equal(mapiSGs[1].ARN))
-	g.Expect(len(capiSGs[1].Filters)).To(Equal(len(mapiSGs[1].Filters)))
-	g.Expect(capiSGs[1].Filters[0].Name).To(Equal(mapiSGs[1].Filters[0].Name))
-	g.Expect(capiSGs[1].Filters[0].Values).To(Equal(mapiSGs[1].Filters[0].Values))
+	_, err := converter.ToCAPI()
+	g.Expect(err).To(MatchError("target region eu-west-1 differs from source region us-east-1, a target availability zone is required"))
+
+	converter.TargetAvailabilityZone = "us-east-1b"
+	_, err = converter.ToCAPI()
+	g.Expect(err).To(MatchError("target availability zone us-east-1b is not in target region eu-west-1"))
+
+	converter.TargetAvailabilityZone = "eu-west-1a"
+	_, err = converter.ToCAPI()
+	g.Expect(err).To(MatchError("target region eu-west-1 differs from source region us-east-1, a target subnet is required"))
+
+	converter.TargetSubnet = "subnet-west"
+	out, err := converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(2))
+
+	capiAWSMachineTemplate := &capi.AWSMachineTemplate{}
+	g.Expect(yaml.Unmarshal(out[0], capiAWSMachineTemplate)).To(Succeed())
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.AMI.ID).To(Equal(pointer.String("ami-x86-west")))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.FailureDomain).To(Equal(pointer.String("eu-west-1a")))
+	g.Expect(capiAWSMachineTemplate.Spec.Template.Spec.Subnet).To(Equal(&capi.AWSResourceReference{ID: pointer.String("subnet-west")}))
+	g.Expect(capiAWSMachineTemplate.Annotations).To(HaveKeyWithValue(amiRewrittenFromAnnotation, "ami-x86-east (us-east-1)"))
+	g.Expect(converter.Report().Entries).To(HaveLen(3))
 }
 
-func TestConvertAWSSpotMarketOptionsToMAPI(t *testing.T) {
+func TestToMAPIRewritesAMIForTargetRegion(t *testing.T) {
 	g := NewWithT(t)
 
-	mapiSpotMarketOptions := convertAWSSpotMarketOptionsToMAPI(nil)
-	g.Expect(mapiSpotMarketOptions).To(BeNil())
+	machineTemplate, err := awsMachineTemplateWithSpec(capi.AWSMachineSpec{
+		AMI:           capi.AWSResourceReference{ID: pointer.String("ami-x86-east")},
+		FailureDomain: pointer.String("us-east-1a"),
+	})
+	g.Expect(err).NotTo(HaveOccurred())
 
-	capiSpotMarketOptions := &capi.SpotMarketOptions{
-		MaxPrice: pointer.String("1"),
+	converter := &AWSConverter{
+		MachineSetFile: []byte(`apiVersion: cluster.x-k8s.io/v1alpha4
+kind: MachineSet
+metadata:
+  name: worker
+  namespace: openshift-machine-api
+  annotations:
+    cluster.x-k8s.io/cluster-api-autoscaler-node-group-min-size: "1"
+    cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size: "3"
+spec:
+  clusterName: cluster
+  template:
+    spec:
+      clusterName: cluster
+`),
+		MachineTemplateFile:    machineTemplate,
+		StreamMetadataFile:     []byte(testStreamMetadata),
+		TargetRegion:           "eu-west-1",
+		TargetAvailabilityZone: "eu-west-1b",
 	}
 
-	mapiSpotMarketOptions = convertAWSSpotMarketOptionsToMAPI(capiSpotMarketOptions)
-	g.Expect(capiSpotMarketOptions).ToNot(BeNil())
-	g.Expect(capiSpotMarketOptions.MaxPrice).To(Equal(mapiSpotMarketOptions.MaxPrice))
+	out, err := converter.ToMAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	mapiMachineSet := &mapi.MachineSet{}
+	g.Expect(yaml.Unmarshal(out[0], mapiMachineSet)).To(Succeed())
+	// The rewritten AMI is recorded next to the translated autoscaler annotations.
+	g.Expect(mapiMachineSet.Annotations).To(Equal(map[string]string{
+		mapiAutoscalerMinSizeAnnotation: "1",
+		mapiAutoscalerMaxSizeAnnotation: "3",
+		amiRewrittenFromAnnotation:      "ami-x86-east (us-east-1)",
+	}))
+
+	mapiProviderConfig, err := mapi.ProviderSpecFromRawExtension(mapiMachineSet.Spec.Template.Spec.ProviderSpec.Value)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(mapiProviderConfig.AMI.ID).To(Equal(pointer.String("ami-x86-west")))
+	g.Expect(mapiProviderConfig.Placement).To(Equal(mapi.Placement{AvailabilityZone: "eu-west-1b", Region: "eu-west-1"}))
 }
 
-func TestConvertAWSBlockDeviceMappingSpecToMAPI(t *testing.T) {
+func TestRegionFromAvailabilityZone(t *testing.T) {
 	g := NewWithT(t)
 
-	capiRootVolume := &capi.Volume{
-		Size:          1,
-		IOPS:          1,
-		Type:          "type1",
-		Encrypted:     false,
-		EncryptionKey: "test1",
+	g.Expect(regionFromAvailabilityZone("us-east-1a")).To(Equal("us-east-1"))
+	g.Expect(regionFromAvailabilityZone("us-east-1-nyc-1a")).To(Equal("us-east-1"))
+	g.Expect(regionFromAvailabilityZone("us-gov-west-1b")).To(Equal("us-gov-west-1"))
+	g.Expect(regionFromAvailabilityZone("")).To(Equal(""))
+}
+
+func TestConvertAWSTagsToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	capiAWSTags := map[string]string{"tag2": "val2", "tag1": "val1", "tag3": "val3"}
+
+	mapiAWSTags := convertAWSTagsToMAPI(capiAWSTags)
+
+	g.Expect(mapiAWSTags).To(Equal([]mapi.TagSpecification{
+		{Name: "tag1", Value: "val1"},
+		{Name: "tag2", Value: "val2"},
+		{Name: "tag3", Value: "val3"},
+	}))
+}
+
+const testDeterministicMachineSet = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: worker-a
+  namespace: openshift-machine-api
+  labels:
+    machine.openshift.io/cluster-api-cluster: cluster
+    team: a
+    env: prod
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      machine.openshift.io/cluster-api-cluster: cluster
+      machine.openshift.io/cluster-api-machineset: worker-a
+  template:
+    metadata:
+      labels:
+        machine.openshift.io/cluster-api-cluster: cluster
+        machine.openshift.io/cluster-api-machineset: worker-a
+    spec:
+      providerSpec:
+        value:
+          ami:
+            id: ami-0123
+          instanceType: m5.large
+          blockDevices:
+          - ebs:
+              encrypted: true
+              volumeSize: 120
+              volumeType: gp3
+          placement:
+            availabilityZone: us-east-1a
+            region: us-east-1
+          securityGroups:
+          - filters:
+            - name: tag:Name
+              values:
+              - cluster-worker-sg
+          subnet:
+            id: subnet-0123
+          tags:
+          - name: kubernetes.io/cluster/cluster
+            value: owned
+          - name: e
+            value: "5"
+          - name: b
+            value: "2"
+          - name: d
+            value: "4"
+          - name: a
+            value: "1"
+          - name: c
+            value: "3"
+`
+
+func TestConversionIsDeterministic(t *testing.T) {
+	g := NewWithT(t)
+
+	convert := func(converter *AWSConverter, apiType string) [][]byte {
+		out, err := converter.ConvertAPI(apiType)
+		g.Expect(err).NotTo(HaveOccurred())
+		return out
 	}
 
-	capiNonRootVolumes := []capi.Volume{
-		{
-			DeviceName:    "nonrootdevice",
-			Size:          2,
-			IOPS:          2,
-			Type:          "type2",
-			Encrypted:     false,
-			EncryptionKey: "test2",
-		},
+	capiObjects := convert(&AWSConverter{MachineSetFile: []byte(testDeterministicMachineSet)}, "capi")
+	g.Expect(capiObjects).To(HaveLen(2))
+	toMAPI := &AWSConverter{MachineTemplateFile: capiObjects[0], MachineSetFile: capiObjects[1]}
+	mapiObjects := convert(toMAPI, "mapi")
+
+	for i := 0; i < 20; i++ {
+		g.Expect(convert(&AWSConverter{MachineSetFile: []byte(testDeterministicMachineSet)}, "capi")).To(Equal(capiObjects))
+		g.Expect(convert(toMAPI, "mapi")).To(Equal(mapiObjects))
 	}
 
-	mapiBlockDeviceMapping := convertAWSBlockDeviceMappingSpecToMAPI(capiRootVolume, capiNonRootVolumes)
-
-	g.Expect(len(mapiBlockDeviceMapping)).To(Equal(2))
-
-	g.Expect(mapiBlockDeviceMapping[0]).ToNot(BeNil())
-	g.Expect(capiRootVolume.DeviceName).To(Equal(""))
-	g.Expect(capiRootVolume.Encrypted).To(Equal(*mapiBlockDeviceMapping[0].EBS.Encrypted))
-	g.Expect(capiRootVolume.EncryptionKey).To(Equal(*mapiBlockDeviceMapping[0].EBS.KMSKey.ID))
-	g.Expect(capiRootVolume.IOPS).To(Equal(*mapiBlockDeviceMapping[0].EBS.Iops))
-	g.Expect(capiRootVolume.Size).To(Equal(*mapiBlockDeviceMapping[0].EBS.VolumeSize))
-	g.Expect(capiRootVolume.Type).To(Equal(*mapiBlockDeviceMapping[0].EBS.VolumeType))
-
-	g.Expect(mapiBlockDeviceMapping[1]).ToNot(BeNil())
-	g.Expect(capiNonRootVolumes[0].DeviceName).To(Equal(*mapiBlockDeviceMapping[1].DeviceName))
-	g.Expect(capiNonRootVolumes[0].Encrypted).To(Equal(*mapiBlockDeviceMapping[1].EBS.Encrypted))
-	g.Expect(capiNonRootVolumes[0].EncryptionKey).To(Equal(*mapiBlockDeviceMapping[1].EBS.KMSKey.ID))
-	g.Expect(capiNonRootVolumes[0].IOPS).To(Equal(*mapiBlockDeviceMapping[1].EBS.Iops))
-	g.Expect(capiNonRootVolumes[0].Size).To(Equal(*mapiBlockDeviceMapping[1].EBS.VolumeSize))
-	g.Expect(capiNonRootVolumes[0].Type).To(Equal(*mapiBlockDeviceMapping[1].EBS.VolumeType))
+	mapiMachineSet := &mapi.MachineSet{}
+	g.Expect(yaml.Unmarshal(mapiObjects[0], mapiMachineSet)).To(Succeed())
+	providerSpec := string(mapiMachineSet.Spec.Template.Spec.ProviderSpec.Value.Raw)
+	g.Expect(providerSpec).To(ContainSubstring(`"tags":[{"name":"a","value":"1"},{"name":"b","value":"2"},{"name":"c","value":"3"},{"name":"d","value":"4"},{"name":"e","value":"5"},{"name":"kubernetes.io/cluster/cluster","value":"owned"}]`))
+	g.Expect(providerSpec).To(ContainSubstring(`"blockDevices":[{"ebs":{"encrypted":true,"volumeSize":120,"volumeType":"gp3"}}]`))
+	for _, noise := range []string{`"filters":[]`, `"kmsKey"`, `"metadata"`, `"tenancy"`, `"iops"`, "null", "{}"} {
+		g.Expect(providerSpec).NotTo(ContainSubstring(noise))
+	}
+}
+
+func TestConvertAWSIAMInstanceProfileToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(convertAWSIAMInstanceProfileToMAPI("")).To(BeNil())
+
+	mapiInstanceProfile := convertAWSIAMInstanceProfileToMAPI("testProfile")
+	g.Expect(mapiInstanceProfile).ToNot(BeNil())
+	g.Expect(mapiInstanceProfile.ID).To(Equal(pointer.String("testProfile")))
+	g.Expect(mapiInstanceProfile.ARN).To(BeNil())
+}
+
+func TestConvertAWSNetworkInterfacesToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	report := &ConversionReport{}
+	deviceIndex, err := convertAWSNetworkInterfacesToMAPI(nil, report)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(deviceIndex).To(BeZero())
+	g.Expect(report.Entries).To(BeEmpty())
+
+	deviceIndex, err = convertAWSNetworkInterfacesToMAPI([]string{"eni-1", "eni-2"}, report)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(deviceIndex).To(BeZero())
+	g.Expect(report.Entries).To(HaveLen(2))
+	g.Expect(report.Entries[1].Field).To(Equal("spec.template.spec.networkInterfaces[1]"))
+
+	_, err = convertAWSNetworkInterfacesToMAPI([]string{"eni-1", "eni-2", "eni-3"}, report)
+	g.Expect(err).To(HaveOccurred())
 }
 
 func TestConvertKMSKeyToMAPI(t *testing.T) {
@@ -594,4 +817,9 @@ func TestConvertKMSKeyToMAPI(t *testing.T) {
 
 	kmsKey := convertKMSKeyToMAPI("test1")
 	g.Expect(*kmsKey.ID).To(Equal("test1"))
+
+	arn := "arn:aws:kms:us-east-1:123456789012:key/test1"
+	kmsKey = convertKMSKeyToMAPI(arn)
+	g.Expect(kmsKey.ID).To(BeNil())
+	g.Expect(*kmsKey.ARN).To(Equal(arn))
 }
@@ -0,0 +1,496 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+)
+
+// fieldTransform converts the JSON value of a field for the other API. A nil
+// result leaves the target field unset.
+type fieldTransform func(value interface{}) (interface{}, error)
+
+// fieldMapping maps a field of the MAPI providerSpec to a field of the CAPA
+// AWSMachineSpec. Fields without transforms are copied as they are.
+type fieldMapping struct {
+	// mapi and capi are the dot separated field paths.
+	mapi string
+	capi string
+
+	toCAPI fieldTransform
+	toMAPI fieldTransform
+
+	// items maps the fields of the list items, or of the object, the
+	// transforms convert. Only used for documentation.
+	items []fieldMapping
+
+	// note explains the transforms.
+	note string
+}
+
+// awsFieldMappings declares every field the providerSpec and AWSMachineSpec
+// have in common. Both conversion directions and the field mapping docs are
+// generated from it, fields that are missing here are either lost or reported
+// elsewhere, see MAPIRoundTripLosses and CAPIRoundTripLosses.
+var awsFieldMappings = []fieldMapping{
+	{mapi: "ami", capi: "ami"},
+	{mapi: "instanceType", capi: "instanceType"},
+	{
+		mapi: "tags", capi: "additionalTags",
+		toCAPI: tagsToCAPI, toMAPI: tagsToMAPI,
+		note: "CAPA keeps tags in a map, MAPI lists them sorted by name",
+	},
+	{
+		mapi: "iamInstanceProfile", capi: "iamInstanceProfile",
+		toCAPI: iamInstanceProfileToCAPI, toMAPI: iamInstanceProfileToMAPI,
+		note: "CAPA takes the instance profile name, MAPI ARNs are reduced to it and filters are rejected",
+	},
+	{mapi: "keyName", capi: "sshKeyName"},
+	{mapi: "publicIp", capi: "publicIP"},
+	{mapi: "securityGroups", capi: "additionalSecurityGroups"},
+	{mapi: "subnet", capi: "subnet"},
+	{mapi: "placement.availabilityZone", capi: "failureDomain"},
+	{
+		mapi: "placement.tenancy", capi: "tenancy",
+		toCAPI: validateTenancy, toMAPI: validateTenancy,
+		note: "must be one of default, dedicated or host",
+	},
+	{
+		mapi: "blockDevices", capi: "rootVolume",
+		toCAPI: rootVolumeToCAPI, toMAPI: rootVolumeToMAPI,
+		items: rootVolumeMappings,
+		note:  "the block device without a device name, there can only be one",
+	},
+	{
+		mapi: "blockDevices", capi: "nonRootVolumes",
+		toCAPI: nonRootVolumesToCAPI, toMAPI: nonRootVolumesToMAPI,
+		items: volumeMappings,
+		note:  "the block devices with a device name and an EBS volume size, after the root volume in MAPI",
+	},
+	{mapi: "spotMarketOptions", capi: "spotMarketOptions"},
+}
+
+// rootVolumeMappings maps a MAPI block device to a CAPA volume.
+var rootVolumeMappings = []fieldMapping{
+	{mapi: "ebs.volumeSize", capi: "size", toMAPI: omitZero, note: "0 is omitted in MAPI"},
+	{mapi: "ebs.volumeType", capi: "type"},
+	{mapi: "ebs.iops", capi: "iops", toMAPI: omitZero, note: "0 is omitted in MAPI"},
+	{mapi: "ebs.encrypted", capi: "encrypted", note: "false is omitted in CAPA, left to the account default in MAPI"},
+	{
+		mapi: "ebs.kmsKey", capi: "encryptionKey",
+		toCAPI: kmsKeyToCAPI, toMAPI: kmsKeyToMAPI,
+		note: "CAPA takes a key ID or ARN in a single field, MAPI keeps ARNs apart",
+	},
+}
+
+// volumeMappings maps a MAPI block device to a CAPA non-root volume.
+var volumeMappings = append([]fieldMapping{{mapi: "deviceName", capi: "deviceName"}}, rootVolumeMappings...)
+
+// convertProviderConfigToAWSMachineSpec converts the mapped fields of a MAPI
+// provider config.
+func convertProviderConfigToAWSMachineSpec(mapiProviderConfig *mapi.AWSMachineProviderConfig) (capi.AWSMachineSpec, error) {
+	spec := capi.AWSMachineSpec{}
+	from, err := toJSONObject(mapiProviderConfig)
+	if err != nil {
+		return spec, err
+	}
+	to, err := convertFields(awsFieldMappings, from, true)
+	if err != nil {
+		return spec, err
+	}
+	return spec, fromJSONValue(to, &spec)
+}
+
+// convertAWSMachineSpecToProviderConfig converts the mapped fields of a CAPA
+// machine spec.
+func convertAWSMachineSpecToProviderConfig(spec capi.AWSMachineSpec) (*mapi.AWSMachineProviderConfig, error) {
+	mapiProviderConfig := &mapi.AWSMachineProviderConfig{}
+	from, err := toJSONObject(spec)
+	if err != nil {
+		return nil, err
+	}
+	to, err := convertFields(awsFieldMappings, from, false)
+	if err != nil {
+		return nil, err
+	}
+	return mapiProviderConfig, fromJSONValue(to, mapiProviderConfig)
+}
+
+// convertFields returns the fields of from converted to CAPI, or to MAPI. Empty
+// source fields are skipped, and mappings sharing a target list append to it
+// in table order.
+func convertFields(mappings []fieldMapping, from map[string]interface{}, toCAPI bool) (map[string]interface{}, error) {
+	to := map[string]interface{}{}
+	for _, mapping := range mappings {
+		fromPath, toPath, transform := mapping.mapi, mapping.capi, mapping.toCAPI
+		if !toCAPI {
+			fromPath, toPath, transform = mapping.capi, mapping.mapi, mapping.toMAPI
+		}
+
+		value, ok := getField(from, fromPath)
+		if !ok {
+			continue
+		}
+		if transform != nil {
+			var err error
+			if value, err = transform(value); err != nil {
+				return nil, err
+			}
+		}
+		if value != nil {
+			setField(to, toPath, value)
+		}
+	}
+	return to, nil
+}
+
+func getField(object map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = object
+	for _, field := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = fields[field]; !ok {
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+func setField(object map[string]interface{}, path string, value interface{}) {
+	fields := strings.Split(path, ".")
+	for _, field := range fields[:len(fields)-1] {
+		next, ok := object[field].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			object[field] = next
+		}
+		object = next
+	}
+
+	field := fields[len(fields)-1]
+	existing, existingIsList := object[field].([]interface{})
+	items, isList := value.([]interface{})
+	if existingIsList && isList {
+		value = append(existing, items...)
+	}
+	object[field] = value
+}
+
+// toJSONObject returns the JSON fields of an object, without its empty
+// fields. Numbers are kept as json.Number.
+func toJSONObject(object interface{}) (map[string]interface{}, error) {
+	value, err := toJSONValue(object)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := pruneEmptyFields(value).(map[string]interface{})
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	return fields, nil
+}
+
+func toJSONValue(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var out interface{}
+	if err := decoder.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func fromJSONValue(value interface{}, out interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func tagsToCAPI(value interface{}) (interface{}, error) {
+	tags := []mapi.TagSpecification{}
+	if err := fromJSONValue(value, &tags); err != nil {
+		return nil, fmt.Errorf("error unmarshalling tags: %v", err)
+	}
+	return toJSONValue(convertAWSTagsToCAPI(tags))
+}
+
+func tagsToMAPI(value interface{}) (interface{}, error) {
+	tags := capi.Tags{}
+	if err := fromJSONValue(value, &tags); err != nil {
+		return nil, fmt.Errorf("error unmarshalling additionalTags: %v", err)
+	}
+	return toJSONValue(convertAWSTagsToMAPI(tags))
+}
+
+func iamInstanceProfileToCAPI(value interface{}) (interface{}, error) {
+	instanceProfile := &mapi.AWSResourceReference{}
+	if err := fromJSONValue(value, instanceProfile); err != nil {
+		return nil, fmt.Errorf("error unmarshalling iamInstanceProfile: %v", err)
+	}
+	name, err := convertAWSIAMInstanceProfileToCAPI(instanceProfile)
+	if err != nil || name == "" {
+		return nil, err
+	}
+	return name, nil
+}
+
+func iamInstanceProfileToMAPI(value interface{}) (interface{}, error) {
+	name, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid iamInstanceProfile %v, must be a string", value)
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return toJSONValue(convertAWSIAMInstanceProfileToMAPI(name))
+}
+
+// validateTenancy validates the tenancy, which has the same values in both APIs.
+func validateTenancy(value interface{}) (interface{}, error) {
+	tenancy, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid tenancy %v, must be a string", value)
+	}
+	switch mapi.InstanceTenancy(tenancy) {
+	case mapi.DefaultTenancy, mapi.DedicatedTenancy, mapi.HostTenancy:
+		return tenancy, nil
+	default:
+		return nil, fmt.Errorf("invalid tenancy %q, must be one of default, dedicated or host", tenancy)
+	}
+}
+
+func kmsKeyToCAPI(value interface{}) (interface{}, error) {
+	kmsKey := mapi.AWSResourceReference{}
+	if err := fromJSONValue(value, &kmsKey); err != nil {
+		return nil, fmt.Errorf("error unmarshalling kmsKey: %v", err)
+	}
+	if key := convertKMSKeyToCAPI(kmsKey); key != "" {
+		return key, nil
+	}
+	return nil, nil
+}
+
+func kmsKeyToMAPI(value interface{}) (interface{}, error) {
+	key, _ := value.(string)
+	if key == "" {
+		return nil, nil
+	}
+	return toJSONValue(convertKMSKeyToMAPI(key))
+}
+
+func omitZero(value interface{}) (interface{}, error) {
+	if number, ok := value.(json.Number); ok && number.String() == "0" {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// rootVolumeToCAPI converts the block device without a device name. MAPI
+// attaches such block devices as the root device of the AMI, so only one of
+// them is allowed.
+func rootVolumeToCAPI(value interface{}) (interface{}, error) {
+	rootBlockDevices := []map[string]interface{}{}
+	for _, blockDevice := range blockDevicesOf(value) {
+		if _, ok := getField(blockDevice, "deviceName"); !ok {
+			rootBlockDevices = append(rootBlockDevices, blockDevice)
+		}
+	}
+	switch len(rootBlockDevices) {
+	case 0:
+		return nil, nil
+	case 1:
+		return convertFields(rootVolumeMappings, rootBlockDevices[0], true)
+	default:
+		return nil, fmt.Errorf("invalid blockDevices, %d block devices have no deviceName, only the root volume can omit it", len(rootBlockDevices))
+	}
+}
+
+// nonRootVolumesToCAPI converts the block devices with a device name. Devices
+// without an EBS volume size are skipped, CAPA volumes need a size of at least
+// 8 GiB, see convertAWSBlockDevicesToCAPI.
+func nonRootVolumesToCAPI(value interface{}) (interface{}, error) {
+	volumes := []interface{}{}
+	for _, blockDevice := range blockDevicesOf(value) {
+		if _, ok := getField(blockDevice, "deviceName"); !ok {
+			continue
+		}
+		if size, _ := getField(blockDevice, "ebs.volumeSize"); size == nil || size == float64(0) {
+			continue
+		}
+		volume, err := convertFields(volumeMappings, blockDevice, true)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, volume)
+	}
+	if len(volumes) == 0 {
+		return nil, nil
+	}
+	return volumes, nil
+}
+
+func rootVolumeToMAPI(value interface{}) (interface{}, error) {
+	volume, _ := value.(map[string]interface{})
+	blockDevice, err := convertFields(rootVolumeMappings, volume, false)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{blockDevice}, nil
+}
+
+func nonRootVolumesToMAPI(value interface{}) (interface{}, error) {
+	volumes, _ := value.([]interface{})
+	blockDevices := []interface{}{}
+	for _, volume := range volumes {
+		volume, _ := volume.(map[string]interface{})
+		blockDevice, err := convertFields(volumeMappings, volume, false)
+		if err != nil {
+			return nil, err
+		}
+		blockDevices = append(blockDevices, blockDevice)
+	}
+	return blockDevices, nil
+}
+
+func blockDevicesOf(value interface{}) []map[string]interface{} {
+	items, _ := value.([]interface{})
+	blockDevices := []map[string]interface{}{}
+	for _, item := range items {
+		// Empty block devices are pruned to nil.
+		blockDevice, _ := item.(map[string]interface{})
+		if blockDevice == nil {
+			blockDevice = map[string]interface{}{}
+		}
+		blockDevices = append(blockDevices, blockDevice)
+	}
+	return blockDevices
+}
+
+// fieldMappingRow is a mapping of the docs, with the paths of list items
+// suffixed by [*].
+type fieldMappingRow struct {
+	mapi, capi, note string
+
+	// copied is set when the value and its fields are copied as they are.
+	copied bool
+}
+
+// fieldMappingRows flattens the mappings and the item mappings of their
+// transforms.
+func fieldMappingRows(mappings []fieldMapping, mapiType, capiType reflect.Type, mapiPrefix, capiPrefix string) []fieldMappingRow {
+	rows := []fieldMappingRow{}
+	for _, mapping := range mappings {
+		mapiPath := mapiPrefix + displayPath(mapiType, mapping.mapi)
+		capiPath := capiPrefix + displayPath(capiType, mapping.capi)
+		rows = append(rows, fieldMappingRow{
+			mapi:   mapiPath,
+			capi:   capiPath,
+			note:   mapping.note,
+			copied: mapping.toCAPI == nil && mapping.toMAPI == nil && len(mapping.items) == 0,
+		})
+		if len(mapping.items) > 0 {
+			rows = append(rows, fieldMappingRows(mapping.items, fieldType(mapiType, mapping.mapi), fieldType(capiType, mapping.capi), mapiPath+".", capiPath+".")...)
+		}
+	}
+	return rows
+}
+
+// WriteAWSFieldMappings writes the markdown docs of the AWS field mappings and
+// round trip losses.
+func WriteAWSFieldMappings(w io.Writer) error {
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "# AWS field mappings\n\n")
+	fmt.Fprintf(out, "Generated from pkg/converter/aws_fields.go and pkg/converter/losses.go by `make docs`, don't edit.\n\n")
+	fmt.Fprintf(out, "Paths are relative to the MAPI providerSpec value and to the CAPA AWSMachineSpec, `[*]` matches every list item.\n\n")
+
+	fmt.Fprintf(out, "## Mapped fields\n\n")
+	fmt.Fprintf(out, "| MAPI | CAPI | Notes |\n| --- | --- | --- |\n")
+	rows := fieldMappingRows(awsFieldMappings, reflect.TypeOf(mapi.AWSMachineProviderConfig{}), reflect.TypeOf(capi.AWSMachineSpec{}), "", "")
+	for _, row := range rows {
+		fmt.Fprintf(out, "| `%s` | `%s` | %s |\n", row.mapi, row.capi, row.note)
+	}
+
+	writeFieldLosses(out, "## Fields lost converting MAPI to CAPI and back", MAPIRoundTripLosses)
+	writeFieldLosses(out, "## Fields lost converting CAPI to MAPI and back", CAPIRoundTripLosses)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func writeFieldLosses(out io.Writer, title string, losses []FieldLoss) {
+	fmt.Fprintf(out, "\n%s\n\n| Field | Reason |\n| --- | --- |\n", title)
+	for _, loss := range losses {
+		fmt.Fprintf(out, "| `%s` | %s |\n", loss.Field, loss.Reason)
+	}
+}
+
+// displayPath suffixes the list fields of a path of t with [*].
+func displayPath(t reflect.Type, path string) string {
+	fields := strings.Split(path, ".")
+	for i, field := range fields {
+		t = jsonFields(t)[field]
+		if t != nil && t.Kind() == reflect.Slice {
+			fields[i] += "[*]"
+		}
+		t = elemType(t)
+	}
+	return strings.Join(fields, ".")
+}
+
+// fieldType returns the type of a field of t, or of its items for lists.
+func fieldType(t reflect.Type, path string) reflect.Type {
+	for _, field := range strings.Split(path, ".") {
+		t = elemType(jsonFields(t)[field])
+	}
+	return t
+}
+
+func elemType(t reflect.Type) reflect.Type {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// jsonFields returns the types of the JSON fields of a struct type, including
+// the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	t = elemType(t)
+	if t == nil || t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			for name, fieldType := range jsonFields(field.Type) {
+				fields[name] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
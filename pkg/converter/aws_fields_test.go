@@ -0,0 +1,249 @@
+package converter
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+)
+
+const awsFieldMappingsDoc = "../../docs/aws-field-mappings.md"
+
+// TestAWSFieldMappingsCoverEveryField makes sure a new field of either API is
+// either mapped or declared lost.
+func TestAWSFieldMappingsCoverEveryField(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiType := reflect.TypeOf(mapi.AWSMachineProviderConfig{})
+	capiType := reflect.TypeOf(capi.AWSMachineSpec{})
+	// kind is always AWSMachineProviderConfig, and userDataSecret converts to
+	// the bootstrap data secret of the CAPI machine set.
+	mapiCovered, capiCovered := []string{"kind", "userDataSecret"}, []string{}
+	for _, row := range fieldMappingRows(awsFieldMappings, mapiType, capiType, "", "") {
+		mapiCovered = append(mapiCovered, row.mapi)
+		capiCovered = append(capiCovered, row.capi)
+	}
+	for _, loss := range MAPIRoundTripLosses {
+		mapiCovered = append(mapiCovered, loss.Field)
+	}
+	for _, loss := range CAPIRoundTripLosses {
+		capiCovered = append(capiCovered, loss.Field)
+	}
+
+	g.Expect(uncoveredFields(mapiType, "", mapiCovered)).To(BeEmpty(), "map the fields in aws_fields.go or add them to MAPIRoundTripLosses")
+	g.Expect(uncoveredFields(capiType, "", capiCovered)).To(BeEmpty(), "map the fields in aws_fields.go or add them to CAPIRoundTripLosses")
+}
+
+// uncoveredFields returns the leaf fields of t that no covered path matches.
+// Structs are only covered by their fields, so mapping a list of volumes
+// doesn't cover a new volume field.
+func uncoveredFields(t reflect.Type, prefix string, covered []string) []string {
+	uncovered := []string{}
+	for name, fieldType := range jsonFields(t) {
+		path := prefix + name
+		if fieldType.Kind() == reflect.Slice {
+			path += "[*]"
+		}
+		if isCoveredField(path, covered) {
+			continue
+		}
+		if len(jsonFields(fieldType)) > 0 {
+			uncovered = append(uncovered, uncoveredFields(fieldType, path+".", covered)...)
+			continue
+		}
+		uncovered = append(uncovered, path)
+	}
+	return uncovered
+}
+
+func isCoveredField(path string, covered []string) bool {
+	for _, field := range covered {
+		if isUnderField(path, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestAWSFieldMappingsDescribeTransforms makes sure the docs and explain
+// describe every transform.
+func TestAWSFieldMappingsDescribeTransforms(t *testing.T) {
+	g := NewWithT(t)
+
+	rows := fieldMappingRows(awsFieldMappings, reflect.TypeOf(mapi.AWSMachineProviderConfig{}), reflect.TypeOf(capi.AWSMachineSpec{}), "", "")
+	for _, row := range rows {
+		if !row.copied {
+			g.Expect(row.note).NotTo(BeEmpty(), "describe the transform of %s in a note", row.mapi)
+		}
+	}
+}
+
+func TestAWSFieldMappingsDoc(t *testing.T) {
+	g := NewWithT(t)
+
+	out := &bytes.Buffer{}
+	g.Expect(WriteAWSFieldMappings(out)).To(Succeed())
+	compareGolden(g, awsFieldMappingsDoc, out.Bytes())
+}
+
+func TestDisplayPath(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiType := reflect.TypeOf(mapi.AWSMachineProviderConfig{})
+	g.Expect(displayPath(mapiType, "placement.availabilityZone")).To(Equal("placement.availabilityZone"))
+	g.Expect(displayPath(mapiType, "blockDevices")).To(Equal("blockDevices[*]"))
+	g.Expect(fieldType(mapiType, "blockDevices")).To(Equal(reflect.TypeOf(mapi.BlockDeviceMappingSpec{})))
+}
+
+func TestSetField(t *testing.T) {
+	g := NewWithT(t)
+
+	object := map[string]interface{}{}
+	setField(object, "placement.tenancy", "host")
+	setField(object, "blockDevices", []interface{}{"root"})
+	setField(object, "blockDevices", []interface{}{"data"})
+	g.Expect(object).To(Equal(map[string]interface{}{
+		"placement":    map[string]interface{}{"tenancy": "host"},
+		"blockDevices": []interface{}{"root", "data"},
+	}))
+
+	value, ok := getField(object, "placement.tenancy")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(value).To(Equal("host"))
+	_, ok = getField(object, "placement.region")
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestConvertBlockDevicesToCAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	spec, err := convertProviderConfigToAWSMachineSpec(&mapi.AWSMachineProviderConfig{
+		BlockDevices: []mapi.BlockDeviceMappingSpec{
+			{
+				DeviceName: pointer.String("/dev/xvdb"),
+				EBS: &mapi.EBSBlockDeviceSpec{
+					VolumeSize: pointer.Int64(50),
+					Encrypted:  pointer.Bool(true),
+					KMSKey:     mapi.AWSResourceReference{ARN: pointer.String("arn:aws:kms:us-east-1:123456789012:key/data")},
+				},
+			},
+			{
+				EBS: &mapi.EBSBlockDeviceSpec{
+					VolumeSize: pointer.Int64(120),
+					VolumeType: pointer.String("gp3"),
+					Iops:       pointer.Int64(3000),
+				},
+			},
+			{
+				DeviceName:  pointer.String("/dev/xvdc"),
+				VirtualName: pointer.String("ephemeral0"),
+			},
+		},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.RootVolume).To(Equal(&capi.Volume{Size: 120, Type: "gp3", IOPS: 3000}))
+	// The instance store device has no volume size, CAPA would reject it.
+	g.Expect(spec.NonRootVolumes).To(Equal([]capi.Volume{
+		{DeviceName: "/dev/xvdb", Size: 50, Encrypted: true, EncryptionKey: "arn:aws:kms:us-east-1:123456789012:key/data"},
+	}))
+
+	spec, err = convertProviderConfigToAWSMachineSpec(&mapi.AWSMachineProviderConfig{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.RootVolume).To(BeNil())
+	g.Expect(spec.NonRootVolumes).To(BeNil())
+
+	_, err = convertProviderConfigToAWSMachineSpec(&mapi.AWSMachineProviderConfig{
+		BlockDevices: []mapi.BlockDeviceMappingSpec{
+			{EBS: &mapi.EBSBlockDeviceSpec{VolumeSize: pointer.Int64(120)}},
+			{DeviceName: pointer.String("/dev/xvdb")},
+			{EBS: &mapi.EBSBlockDeviceSpec{VolumeSize: pointer.Int64(50)}},
+		},
+	})
+	g.Expect(err).To(MatchError("invalid blockDevices, 2 block devices have no deviceName, only the root volume can omit it"))
+}
+
+func TestConvertBlockDevicesToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiProviderConfig, err := convertAWSMachineSpecToProviderConfig(capi.AWSMachineSpec{
+		NonRootVolumes: []capi.Volume{{DeviceName: "/dev/xvdb", Encrypted: true, EncryptionKey: "key"}},
+		RootVolume:     &capi.Volume{Size: 120},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	// Unencrypted CAPA volumes leave encryption to the account default.
+	g.Expect(mapiProviderConfig.BlockDevices).To(Equal([]mapi.BlockDeviceMappingSpec{
+		{
+			EBS: &mapi.EBSBlockDeviceSpec{
+				VolumeSize: pointer.Int64(120),
+			},
+		},
+		{
+			DeviceName: pointer.String("/dev/xvdb"),
+			EBS: &mapi.EBSBlockDeviceSpec{
+				Encrypted: pointer.Bool(true),
+				KMSKey:    mapi.AWSResourceReference{ID: pointer.String("key")},
+			},
+		},
+	}))
+}
+
+func TestConvertReferencesToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiProviderConfig, err := convertAWSMachineSpecToProviderConfig(capi.AWSMachineSpec{
+		AMI:                      capi.AWSResourceReference{ARN: pointer.String("arn:aws:ec2:us-east-1::image/ami-1")},
+		Subnet:                   &capi.AWSResourceReference{Filters: []capi.Filter{{Name: "tag:Name", Values: []string{"private-a", "private-b"}}}},
+		AdditionalSecurityGroups: []capi.AWSResourceReference{{ID: pointer.String("sg-1")}, {Filters: []capi.Filter{{Name: "tag:role", Values: []string{"worker"}}}}},
+		Tenancy:                  "dedicated",
+		SpotMarketOptions:        &capi.SpotMarketOptions{},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(mapiProviderConfig.AMI).To(Equal(mapi.AWSResourceReference{ARN: pointer.String("arn:aws:ec2:us-east-1::image/ami-1")}))
+	g.Expect(mapiProviderConfig.Subnet).To(Equal(mapi.AWSResourceReference{Filters: []mapi.Filter{{Name: "tag:Name", Values: []string{"private-a", "private-b"}}}}))
+	g.Expect(mapiProviderConfig.SecurityGroups).To(Equal([]mapi.AWSResourceReference{{ID: pointer.String("sg-1")}, {Filters: []mapi.Filter{{Name: "tag:role", Values: []string{"worker"}}}}}))
+	g.Expect(mapiProviderConfig.Placement.Tenancy).To(Equal(mapi.DedicatedTenancy))
+	g.Expect(mapiProviderConfig.SpotMarketOptions).To(Equal(&mapi.SpotMarketOptions{}))
+
+	mapiProviderConfig, err = convertAWSMachineSpecToProviderConfig(capi.AWSMachineSpec{
+		SpotMarketOptions: &capi.SpotMarketOptions{MaxPrice: pointer.String("0.5")},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(mapiProviderConfig.SpotMarketOptions).To(Equal(&mapi.SpotMarketOptions{MaxPrice: pointer.String("0.5")}))
+	g.Expect(mapiProviderConfig.Placement.Tenancy).To(BeEmpty())
+}
+
+func TestValidateTenancy(t *testing.T) {
+	g := NewWithT(t)
+
+	for _, tenancy := range []mapi.InstanceTenancy{mapi.DefaultTenancy, mapi.DedicatedTenancy, mapi.HostTenancy} {
+		validated, err := validateTenancy(string(tenancy))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(validated).To(Equal(string(tenancy)))
+	}
+
+	_, err := validateTenancy("Dedicated")
+	g.Expect(err).To(MatchError(`invalid tenancy "Dedicated", must be one of default, dedicated or host`))
+	_, err = validateTenancy(true)
+	g.Expect(err).To(MatchError("invalid tenancy true, must be a string"))
+}
+
+func TestConvertFieldsErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := convertProviderConfigToAWSMachineSpec(&mapi.AWSMachineProviderConfig{
+		Placement: mapi.Placement{Tenancy: "shared"},
+	})
+	g.Expect(err).To(MatchError(`invalid tenancy "shared", must be one of default, dedicated or host`))
+
+	_, err = convertProviderConfigToAWSMachineSpec(&mapi.AWSMachineProviderConfig{
+		IAMInstanceProfile: &mapi.AWSResourceReference{Filters: []mapi.Filter{{Name: "tag:role"}}},
+	})
+	g.Expect(err).To(MatchError("iam instance profile filters are not supported, use an id or arn instead"))
+
+	_, err = convertAWSMachineSpecToProviderConfig(capi.AWSMachineSpec{Tenancy: "shared"})
+	g.Expect(err).To(MatchError(`invalid tenancy "shared", must be one of default, dedicated or host`))
+}
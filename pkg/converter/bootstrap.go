@@ -0,0 +1,193 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// BootstrapFormat is the user data format the converted machines boot with.
+type BootstrapFormat string
+
+const (
+	// BootstrapFormatCloudInit bootstraps machines with cloud-init.
+	BootstrapFormatCloudInit BootstrapFormat = "cloud-init"
+
+	// BootstrapFormatIgnition bootstraps machines with Ignition, as OpenShift nodes do.
+	BootstrapFormatIgnition BootstrapFormat = "ignition"
+
+	defaultIgnitionVersion = "2.3"
+	userDataSecretKey      = "userData"
+)
+
+// ignitionVersions mirrors the Enum validation on CAPA's Ignition.Version.
+var ignitionVersions = []string{"2.3", "3.0", "3.1", "3.2", "3.3", "3.4"}
+
+// cloudInitPrefixes are the headers cloud-init recognises at the start of user data.
+var cloudInitPrefixes = []string{
+	"#cloud-config",
+	"#cloud-boothook",
+	"#include",
+	"#!",
+	"Content-Type: multipart/mixed",
+}
+
+// BootstrapOptions configures how the converted AWSMachineTemplate bootstraps its machines.
+type BootstrapOptions struct {
+	// Format selects cloud-init or Ignition. When empty it's detected from
+	// UserDataFile, falling back to cloud-init.
+	Format BootstrapFormat
+
+	// UserDataFile is the user data secret manifest of the machines, or the raw user data.
+	UserDataFile []byte
+
+	// SecureSecretsBackend is the cloud-init secrets backend. Defaults to secrets-manager.
+	SecureSecretsBackend capi.SecretBackend
+
+	// UncompressedUserData disables gzip compression of cloud-init user data.
+	UncompressedUserData *bool
+
+	// IgnitionVersion is the Ignition version of the bootstrap data. Defaults to
+	// the version found in the user data, or 2.3.
+	IgnitionVersion string
+
+	// IgnitionStorageType defaults to UnencryptedUserData, which is how MAPI
+	// passes user data to instances.
+	IgnitionStorageType capi.IgnitionStorageTypeOption
+}
+
+func convertBootstrapOptionsToCAPI(options BootstrapOptions, spec *capi.AWSMachineSpec, report *ConversionReport) error {
+	format := options.Format
+	detectedIgnitionVersion := ""
+	if format == "" && len(options.UserDataFile) > 0 {
+		var err error
+		format, detectedIgnitionVersion, err = detectUserDataFormat(options.UserDataFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case "", BootstrapFormatCloudInit:
+		secretsBackend := options.SecureSecretsBackend
+		switch secretsBackend {
+		case "":
+			secretsBackend = capi.SecretBackendSecretsManager
+		case capi.SecretBackendSecretsManager, capi.SecretBackendSSMParameterStore:
+		default:
+			return fmt.Errorf("invalid secrets backend %q, must be one of %s or %s", secretsBackend, capi.SecretBackendSecretsManager, capi.SecretBackendSSMParameterStore)
+		}
+		spec.CloudInit = capi.CloudInit{
+			InsecureSkipSecretsManager: false,
+			SecureSecretsBackend:       secretsBackend,
+		}
+		spec.UncompressedUserData = options.UncompressedUserData
+	case BootstrapFormatIgnition:
+		version := options.IgnitionVersion
+		if version == "" {
+			version = detectedIgnitionVersion
+		}
+		if version == "" {
+			version = defaultIgnitionVersion
+		}
+		version, err := convertIgnitionVersionToCAPI(version, report)
+		if err != nil {
+			return err
+		}
+		storageType := options.IgnitionStorageType
+		switch storageType {
+		case "":
+			storageType = capi.IgnitionStorageTypeOptionUnencryptedUserData
+		case capi.IgnitionStorageTypeOptionUnencryptedUserData, capi.IgnitionStorageTypeOptionClusterObjectStore:
+		default:
+			return fmt.Errorf("invalid ignition storage type %q, must be one of %s or %s", storageType, capi.IgnitionStorageTypeOptionClusterObjectStore, capi.IgnitionStorageTypeOptionUnencryptedUserData)
+		}
+		spec.Ignition = &capi.Ignition{
+			Version:     version,
+			StorageType: storageType,
+		}
+	default:
+		return fmt.Errorf("unknown bootstrap format %q, can be either %s or %s", format, BootstrapFormatCloudInit, BootstrapFormatIgnition)
+	}
+
+	return nil
+}
+
+// convertIgnitionVersionToCAPI validates a major.minor Ignition version against
+// the versions CAPA generates bootstrap data for. Ignition 2.3 reads older 2.x
+// configs, e.g. the 2.2 configs of OpenShift 4.5 and earlier, so those are
+// mapped to it.
+func convertIgnitionVersionToCAPI(version string, report *ConversionReport) (string, error) {
+	for _, supported := range ignitionVersions {
+		if version == supported {
+			return version, nil
+		}
+	}
+
+	if minor := strings.TrimPrefix(version, "2."); minor != version {
+		if n, err := strconv.Atoi(minor); err == nil && n >= 0 && n < 3 {
+			report.add(capiAWSMachineSpecPath+".ignition.version", "ignition version %s is not supported by CAPA, bootstrap data is generated for %s which reads %s configs", version, defaultIgnitionVersion, version)
+			return defaultIgnitionVersion, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid ignition version %q, must be one of %s", version, strings.Join(ignitionVersions, ", "))
+}
+
+// detectUserDataFormat inspects user data and returns its format, along with
+// the major.minor Ignition version for Ignition configs.
+func detectUserDataFormat(userDataFile []byte) (BootstrapFormat, string, error) {
+	userData, err := userDataFromFile(userDataFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	userData = bytes.TrimSpace(userData)
+
+	ignitionConfig := struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}{}
+	if err := json.Unmarshal(userData, &ignitionConfig); err == nil && ignitionConfig.Ignition.Version != "" {
+		versionParts := strings.SplitN(ignitionConfig.Ignition.Version, ".", 3)
+		if len(versionParts) < 2 {
+			return "", "", fmt.Errorf("invalid ignition version %q", ignitionConfig.Ignition.Version)
+		}
+		return BootstrapFormatIgnition, versionParts[0] + "." + versionParts[1], nil
+	}
+
+	for _, prefix := range cloudInitPrefixes {
+		if bytes.HasPrefix(userData, []byte(prefix)) {
+			return BootstrapFormatCloudInit, "", nil
+		}
+	}
+
+	return "", "", errors.New("unable to detect user data format, set the bootstrap format explicitly")
+}
+
+// userDataFromFile returns the user data held by a Secret manifest, or the
+// file content itself when it isn't a Secret.
+func userDataFromFile(userDataFile []byte) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := yaml.Unmarshal(userDataFile, secret); err != nil || secret.Kind != "Secret" {
+		return userDataFile, nil
+	}
+
+	if userData, ok := secret.Data[userDataSecretKey]; ok {
+		return userData, nil
+	}
+
+	if userData, ok := secret.StringData[userDataSecretKey]; ok {
+		return []byte(userData), nil
+	}
+
+	return nil, fmt.Errorf("user data secret %s has no %s key", secret.Name, userDataSecretKey)
+}
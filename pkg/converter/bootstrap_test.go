@@ -0,0 +1,143 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+)
+
+func TestConvertBootstrapOptionsToCAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	spec := &capi.AWSMachineSpec{}
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{}, spec, nil)).To(Succeed())
+	g.Expect(spec.CloudInit).To(Equal(capi.CloudInit{
+		SecureSecretsBackend: capi.SecretBackendSecretsManager,
+	}))
+	g.Expect(spec.Ignition).To(BeNil())
+
+	spec = &capi.AWSMachineSpec{}
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		Format:               BootstrapFormatCloudInit,
+		SecureSecretsBackend: capi.SecretBackendSSMParameterStore,
+		UncompressedUserData: pointer.Bool(true),
+	}, spec, nil)).To(Succeed())
+	g.Expect(spec.CloudInit.SecureSecretsBackend).To(Equal(capi.SecretBackendSSMParameterStore))
+	g.Expect(spec.UncompressedUserData).To(Equal(pointer.Bool(true)))
+	g.Expect(spec.Ignition).To(BeNil())
+
+	spec = &capi.AWSMachineSpec{}
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		Format: BootstrapFormatIgnition,
+	}, spec, nil)).To(Succeed())
+	g.Expect(spec.CloudInit).To(Equal(capi.CloudInit{}))
+	g.Expect(spec.Ignition).To(Equal(&capi.Ignition{
+		Version:     defaultIgnitionVersion,
+		StorageType: capi.IgnitionStorageTypeOptionUnencryptedUserData,
+	}))
+
+	spec = &capi.AWSMachineSpec{}
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		UserDataFile: []byte(`{"ignition":{"version":"3.1.0","config":{"merge":[{"source":"https://api-int.example.com:22623/config/worker"}]}}}`),
+	}, spec, nil)).To(Succeed())
+	g.Expect(spec.Ignition).To(Equal(&capi.Ignition{
+		Version:     "3.1",
+		StorageType: capi.IgnitionStorageTypeOptionUnencryptedUserData,
+	}))
+
+	spec = &capi.AWSMachineSpec{}
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		UserDataFile:        []byte(`{"ignition":{"version":"3.1.0"}}`),
+		IgnitionVersion:     "3.2",
+		IgnitionStorageType: capi.IgnitionStorageTypeOptionClusterObjectStore,
+	}, spec, nil)).To(Succeed())
+	g.Expect(spec.Ignition).To(Equal(&capi.Ignition{
+		Version:     "3.2",
+		StorageType: capi.IgnitionStorageTypeOptionClusterObjectStore,
+	}))
+
+	report := &ConversionReport{}
+	spec = &capi.AWSMachineSpec{}
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		UserDataFile: []byte(`{"ignition":{"version":"2.2.0"}}`),
+	}, spec, report)).To(Succeed())
+	g.Expect(spec.Ignition.Version).To(Equal("2.3"))
+	g.Expect(report.Entries).To(HaveLen(1))
+
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		Format:          BootstrapFormatIgnition,
+		IgnitionVersion: "3.5",
+	}, &capi.AWSMachineSpec{}, nil)).To(MatchError(`invalid ignition version "3.5", must be one of 2.3, 3.0, 3.1, 3.2, 3.3, 3.4`))
+
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		Format:          BootstrapFormatIgnition,
+		IgnitionVersion: "1.0",
+	}, &capi.AWSMachineSpec{}, nil)).NotTo(Succeed())
+
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		Format:              BootstrapFormatIgnition,
+		IgnitionStorageType: "S3",
+	}, &capi.AWSMachineSpec{}, nil)).To(MatchError(`invalid ignition storage type "S3", must be one of ClusterObjectStore or UnencryptedUserData`))
+
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		SecureSecretsBackend: "vault",
+	}, &capi.AWSMachineSpec{}, nil)).To(MatchError(`invalid secrets backend "vault", must be one of secrets-manager or ssm-parameter-store`))
+
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		Format: "unknown",
+	}, &capi.AWSMachineSpec{}, nil)).NotTo(Succeed())
+
+	g.Expect(convertBootstrapOptionsToCAPI(BootstrapOptions{
+		UserDataFile: []byte("unknown user data"),
+	}, &capi.AWSMachineSpec{}, nil)).NotTo(Succeed())
+}
+
+func TestDetectUserDataFormat(t *testing.T) {
+	g := NewWithT(t)
+
+	format, version, err := detectUserDataFormat([]byte("#cloud-config\nruncmd:\n- echo hello\n"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(format).To(Equal(BootstrapFormatCloudInit))
+	g.Expect(version).To(BeEmpty())
+
+	format, _, err = detectUserDataFormat([]byte("#!/bin/bash\necho hello\n"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(format).To(Equal(BootstrapFormatCloudInit))
+
+	// {"ignition":{"version":"3.2.0"}}
+	format, version, err = detectUserDataFormat([]byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: worker-user-data
+  namespace: openshift-machine-api
+data:
+  userData: eyJpZ25pdGlvbiI6eyJ2ZXJzaW9uIjoiMy4yLjAifX0=
+`))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(format).To(Equal(BootstrapFormatIgnition))
+	g.Expect(version).To(Equal("3.2"))
+
+	format, _, err = detectUserDataFormat([]byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: worker-user-data
+stringData:
+  userData: "#cloud-config"
+`))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(format).To(Equal(BootstrapFormatCloudInit))
+
+	_, _, err = detectUserDataFormat([]byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: worker-user-data
+data:
+  disableTemplating: dHJ1ZQo=
+`))
+	g.Expect(err).To(HaveOccurred())
+
+	_, _, err = detectUserDataFormat([]byte(`{"ignition":{"version":"3"}}`))
+	g.Expect(err).To(HaveOccurred())
+}
@@ -4,4 +4,5 @@ type Converter interface {
 	ToMAPI() ([][]byte, error)
 	ToCAPI() ([][]byte, error)
 	ConvertAPI(apiType string) ([][]byte, error)
+	Report() ConversionReport
 }
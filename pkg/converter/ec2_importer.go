@@ -0,0 +1,294 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/ec2"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	awsProviderConfigKind       = "AWSMachineProviderConfig"
+	awsProviderConfigAPIVersion = "machine.openshift.io/v1beta1"
+
+	ec2InstancePath = "instance"
+
+	// awsReservedTagPrefix is reserved by AWS, such tags can't be set on new instances.
+	awsReservedTagPrefix = "aws:"
+
+	// nameTag is set by both MAPI and CAPA to the name of the machine.
+	nameTag = "Name"
+)
+
+// EC2Importer reconstructs the provider config of an instance captured with
+// `aws ec2 describe-instances`, so hand-built nodes can be adopted into machine
+// management. ToMAPI emits an AWSMachineProviderConfig, ToCAPI an AWSMachineTemplate.
+type EC2Importer struct {
+	// InstancesFile is the describe-instances JSON output.
+	InstancesFile []byte
+
+	// VolumesFile is the optional describe-volumes JSON output of the instance
+	// volumes. Without it volume sizes and types are unknown and volumes are dropped.
+	VolumesFile []byte
+
+	// InstanceID picks the instance to import. It may be empty when
+	// InstancesFile has a single instance.
+	InstanceID string
+
+	// Name and Namespace of the AWSMachineTemplate. Name defaults to the instance ID.
+	Name      string
+	Namespace string
+
+	// Region of the instance. When empty it's derived from the availability zone.
+	Region string
+
+	// Bootstrap configures the cloud-init or Ignition options of the AWSMachineTemplate.
+	Bootstrap BootstrapOptions
+
+	report ConversionReport
+}
+
+// Report returns the lossy conversions found by the last ToCAPI or ToMAPI call.
+func (importer *EC2Importer) Report() ConversionReport {
+	return importer.report
+}
+
+func (importer *EC2Importer) ConvertAPI(apiType string) ([][]byte, error) {
+	switch apiType {
+	case "capi":
+		return importer.ToCAPI()
+	case "mapi":
+		return importer.ToMAPI()
+	default:
+		return nil, errors.New("unkown api type")
+	}
+}
+
+func (importer *EC2Importer) ToMAPI() ([][]byte, error) {
+	importer.report = ConversionReport{}
+
+	mapiProviderConfig, _, err := importer.importProviderConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rawProviderConfig, err := marshalProviderConfig(mapiProviderConfig)
+	if err != nil {
+		return nil, err
+	}
+	out, err := yaml.JSONToYAML(rawProviderConfig.Raw)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{out}, nil
+}
+
+func (importer *EC2Importer) ToCAPI() ([][]byte, error) {
+	importer.report = ConversionReport{}
+
+	mapiProviderConfig, instance, err := importer.importProviderConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	name := importer.Name
+	if name == "" {
+		name = instance.ID
+	}
+
+	capiAWSTemplate, err := convertProviderConfigToAWSMachineTemplate(name, importer.Namespace, mapiProviderConfig, importer.Bootstrap, &importer.report)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalObjects([]interface{}{capiAWSTemplate})
+}
+
+func (importer *EC2Importer) importProviderConfig() (*mapi.AWSMachineProviderConfig, *capi.Instance, error) {
+	instances, err := ec2.ParseInstances(importer.InstancesFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ec2Instance, err := ec2.FindInstance(instances, importer.InstanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var volumes map[string]ec2.Volume
+	if importer.VolumesFile != nil {
+		volumes, err = ec2.ParseVolumes(importer.VolumesFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	instance, err := convertEC2InstanceToCAPI(ec2Instance, volumes, &importer.report)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mapiProviderConfig, err := convertInstanceToProviderConfig(instance, importer.Region, &importer.report)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapiProviderConfig, instance, nil
+}
+
+// convertEC2InstanceToCAPI fills the CAPA instance description from a described
+// instance. Volumes are only known when their describe-volumes output is given.
+func convertEC2InstanceToCAPI(ec2Instance ec2.Instance, volumes map[string]ec2.Volume, report *ConversionReport) (*capi.Instance, error) {
+	instance := &capi.Instance{
+		ID:               ec2Instance.InstanceID,
+		State:            capi.InstanceState(ec2Instance.State.Name),
+		Type:             ec2Instance.InstanceType,
+		SubnetID:         ec2Instance.SubnetID,
+		ImageID:          ec2Instance.ImageID,
+		ENASupport:       ec2Instance.ENASupport,
+		EBSOptimized:     ec2Instance.EBSOptimized,
+		AvailabilityZone: ec2Instance.Placement.AvailabilityZone,
+		Tenancy:          ec2Instance.Placement.Tenancy,
+	}
+
+	if ec2Instance.KeyName != "" {
+		instance.SSHKeyName = pointer.String(ec2Instance.KeyName)
+	}
+	if ec2Instance.PrivateIPAddress != "" {
+		instance.PrivateIP = pointer.String(ec2Instance.PrivateIPAddress)
+	}
+	if ec2Instance.PublicIPAddress != "" {
+		instance.PublicIP = pointer.String(ec2Instance.PublicIPAddress)
+	}
+
+	if ec2Instance.IAMInstanceProfile != nil {
+		profile, err := instanceProfileNameFromARN(ec2Instance.IAMInstanceProfile.ARN)
+		if err != nil {
+			return nil, err
+		}
+		instance.IAMProfile = profile
+	}
+
+	for _, group := range ec2Instance.SecurityGroups {
+		instance.SecurityGroupIDs = append(instance.SecurityGroupIDs, group.GroupID)
+	}
+
+	for _, networkInterface := range ec2Instance.NetworkInterfaces {
+		instance.NetworkInterfaces = append(instance.NetworkInterfaces, networkInterface.NetworkInterfaceID)
+	}
+
+	if len(ec2Instance.Tags) > 0 {
+		instance.Tags = map[string]string{}
+		for _, tag := range ec2Instance.Tags {
+			instance.Tags[tag.Key] = tag.Value
+		}
+	}
+
+	if ec2Instance.InstanceLifecycle == ec2.InstanceLifecycleSpot {
+		// The spot request holds the max price, it's not part of the instance.
+		instance.SpotMarketOptions = &capi.SpotMarketOptions{}
+		report.add(ec2InstancePath+".instanceLifecycle", "spot max price is not part of describe-instances output, the on-demand price will be used as max price")
+	}
+
+	for i, mapping := range ec2Instance.BlockDeviceMappings {
+		if mapping.EBS == nil {
+			continue
+		}
+		instance.VolumeIDs = append(instance.VolumeIDs, mapping.EBS.VolumeID)
+
+		ec2Volume, ok := volumes[mapping.EBS.VolumeID]
+		if !ok {
+			report.add(fmt.Sprintf("%s.blockDeviceMappings[%d]", ec2InstancePath, i), "volume %s is not in the describe-volumes output, its size and type are unknown and it was dropped", mapping.EBS.VolumeID)
+			continue
+		}
+
+		volume := capi.Volume{
+			DeviceName:    mapping.DeviceName,
+			Size:          ec2Volume.Size,
+			Type:          ec2Volume.VolumeType,
+			IOPS:          ec2Volume.IOPS,
+			Encrypted:     ec2Volume.Encrypted,
+			EncryptionKey: ec2Volume.KMSKeyID,
+		}
+		if mapping.DeviceName == ec2Instance.RootDeviceName {
+			volume.DeviceName = ""
+			instance.RootVolume = &volume
+			continue
+		}
+		instance.NonRootVolumes = append(instance.NonRootVolumes, volume)
+	}
+
+	return instance, nil
+}
+
+// convertInstanceToProviderConfig builds the provider config new machines like
+// the instance would be created from. Instance specific state, e.g. addresses,
+// attached ENIs or the Name tag, isn't carried over. The instance is described
+// as the AWSMachineSpec CAPA would launch it from, and converted like one.
+func convertInstanceToProviderConfig(instance *capi.Instance, region string, report *ConversionReport) (*mapi.AWSMachineProviderConfig, error) {
+	spec := capi.AWSMachineSpec{
+		AMI:                capi.AWSResourceReference{ID: pointer.String(instance.ImageID)},
+		InstanceType:       instance.Type,
+		AdditionalTags:     convertInstanceTagsToCAPI(instance.Tags, report),
+		IAMInstanceProfile: instance.IAMProfile,
+		SSHKeyName:         instance.SSHKeyName,
+		PublicIP:           pointer.Bool(instance.PublicIP != nil),
+		Subnet:             &capi.AWSResourceReference{ID: pointer.String(instance.SubnetID)},
+		FailureDomain:      pointer.String(instance.AvailabilityZone),
+		Tenancy:            instance.Tenancy,
+		SpotMarketOptions:  instance.SpotMarketOptions,
+		// Only the volumes in the describe-volumes output are known, no root
+		// volume is invented for the others.
+		RootVolume:     instance.RootVolume,
+		NonRootVolumes: instance.NonRootVolumes,
+	}
+	for _, groupID := range instance.SecurityGroupIDs {
+		spec.AdditionalSecurityGroups = append(spec.AdditionalSecurityGroups, capi.AWSResourceReference{ID: pointer.String(groupID)})
+	}
+
+	mapiProviderConfig, err := convertAWSMachineSpecToProviderConfig(spec)
+	if err != nil {
+		return nil, err
+	}
+	mapiProviderConfig.TypeMeta = metav1.TypeMeta{
+		Kind:       awsProviderConfigKind,
+		APIVersion: awsProviderConfigAPIVersion,
+	}
+	mapiProviderConfig.UserDataSecret = &corev1.LocalObjectReference{Name: workerUserDataSecretName}
+	mapiProviderConfig.Placement.Region = region
+	if mapiProviderConfig.Placement.Region == "" {
+		mapiProviderConfig.Placement.Region = regionFromAvailabilityZone(instance.AvailabilityZone)
+	}
+
+	for i, networkInterface := range instance.NetworkInterfaces {
+		if i == 0 {
+			continue
+		}
+		report.add(fmt.Sprintf("%s.networkInterfaces[%d]", ec2InstancePath, i), "network interface %s belongs to the instance, new machines only get a primary interface", networkInterface)
+	}
+
+	return mapiProviderConfig, nil
+}
+
+// convertInstanceTagsToCAPI drops the tags new instances can't or shouldn't inherit.
+func convertInstanceTagsToCAPI(tags map[string]string, report *ConversionReport) capi.Tags {
+	additionalTags := capi.Tags{}
+	for _, key := range sortedKeys(tags) {
+		value := tags[key]
+		switch {
+		case strings.HasPrefix(key, awsReservedTagPrefix):
+			report.add(fmt.Sprintf("%s.tags[%s]", ec2InstancePath, key), "tags with the %s prefix are reserved by AWS and were dropped", awsReservedTagPrefix)
+		case key == nameTag:
+			report.add(fmt.Sprintf("%s.tags[%s]", ec2InstancePath, key), "the %s tag is set to the machine name and was dropped", nameTag)
+		default:
+			additionalTags[key] = value
+		}
+	}
+	return additionalTags
+}
@@ -0,0 +1,181 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+const testEC2Instances = `{
+  "Reservations": [
+    {
+      "Instances": [
+        {
+          "InstanceId": "i-0123456789abcdef0",
+          "InstanceType": "m5.large",
+          "ImageId": "ami-0123456789abcdef0",
+          "SubnetId": "subnet-0123456789abcdef0",
+          "KeyName": "admin",
+          "State": {"Code": 16, "Name": "running"},
+          "PrivateIpAddress": "10.0.1.2",
+          "IamInstanceProfile": {"Arn": "arn:aws:iam::123456789012:instance-profile/cluster-worker-profile", "Id": "AIPAEXAMPLE"},
+          "SecurityGroups": [{"GroupId": "sg-worker", "GroupName": "cluster-worker-sg"}],
+          "Placement": {"AvailabilityZone": "us-east-1a", "Tenancy": "dedicated"},
+          "RootDeviceName": "/dev/xvda",
+          "BlockDeviceMappings": [
+            {"DeviceName": "/dev/xvda", "Ebs": {"VolumeId": "vol-root"}},
+            {"DeviceName": "/dev/xvdb", "Ebs": {"VolumeId": "vol-data"}},
+            {"DeviceName": "/dev/xvdc", "Ebs": {"VolumeId": "vol-unknown"}}
+          ],
+          "NetworkInterfaces": [
+            {"NetworkInterfaceId": "eni-primary", "Attachment": {"DeviceIndex": 0}},
+            {"NetworkInterfaceId": "eni-secondary", "Attachment": {"DeviceIndex": 1}}
+          ],
+          "Tags": [
+            {"Key": "Name", "Value": "hand-built-worker"},
+            {"Key": "aws:cloudformation:stack-name", "Value": "workers"},
+            {"Key": "kubernetes.io/cluster/cluster", "Value": "owned"}
+          ],
+          "InstanceLifecycle": "spot",
+          "EbsOptimized": true
+        }
+      ]
+    }
+  ]
+}`
+
+const testEC2Volumes = `{
+  "Volumes": [
+    {"VolumeId": "vol-root", "Size": 120, "VolumeType": "gp3", "Iops": 3000, "Encrypted": true, "KmsKeyId": "arn:aws:kms:us-east-1:123456789012:key/abcd"},
+    {"VolumeId": "vol-data", "Size": 500, "VolumeType": "st1", "Encrypted": false}
+  ]
+}`
+
+func TestEC2ImporterToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	importer := &EC2Importer{
+		InstancesFile: []byte(testEC2Instances),
+		VolumesFile:   []byte(testEC2Volumes),
+	}
+
+	out, err := importer.ToMAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(1))
+
+	providerConfig := &mapi.AWSMachineProviderConfig{}
+	g.Expect(yaml.Unmarshal(out[0], providerConfig)).To(Succeed())
+	g.Expect(providerConfig.Kind).To(Equal(awsProviderConfigKind))
+	g.Expect(providerConfig.APIVersion).To(Equal(awsProviderConfigAPIVersion))
+	g.Expect(providerConfig.AMI.ID).To(Equal(pointer.String("ami-0123456789abcdef0")))
+	g.Expect(providerConfig.InstanceType).To(Equal("m5.large"))
+	g.Expect(providerConfig.Tags).To(ConsistOf(mapi.TagSpecification{Name: "kubernetes.io/cluster/cluster", Value: "owned"}))
+	g.Expect(providerConfig.IAMInstanceProfile.ID).To(Equal(pointer.String("cluster-worker-profile")))
+	g.Expect(providerConfig.UserDataSecret.Name).To(Equal(workerUserDataSecretName))
+	g.Expect(providerConfig.KeyName).To(Equal(pointer.String("admin")))
+	g.Expect(providerConfig.PublicIP).To(Equal(pointer.Bool(false)))
+	g.Expect(providerConfig.SecurityGroups).To(Equal([]mapi.AWSResourceReference{{ID: pointer.String("sg-worker")}}))
+	g.Expect(providerConfig.Subnet.ID).To(Equal(pointer.String("subnet-0123456789abcdef0")))
+	g.Expect(providerConfig.Placement).To(Equal(mapi.Placement{
+		Region:           "us-east-1",
+		AvailabilityZone: "us-east-1a",
+		Tenancy:          mapi.DedicatedTenancy,
+	}))
+	g.Expect(providerConfig.SpotMarketOptions).To(Equal(&mapi.SpotMarketOptions{}))
+	g.Expect(providerConfig.BlockDevices).To(Equal([]mapi.BlockDeviceMappingSpec{
+		{
+			EBS: &mapi.EBSBlockDeviceSpec{
+				VolumeSize: pointer.Int64(120),
+				VolumeType: pointer.String("gp3"),
+				Iops:       pointer.Int64(3000),
+				Encrypted:  pointer.Bool(true),
+				KMSKey:     mapi.AWSResourceReference{ARN: pointer.String("arn:aws:kms:us-east-1:123456789012:key/abcd")},
+			},
+		},
+		{
+			DeviceName: pointer.String("/dev/xvdb"),
+			EBS: &mapi.EBSBlockDeviceSpec{
+				VolumeSize: pointer.Int64(500),
+				VolumeType: pointer.String("st1"),
+			},
+		},
+	}))
+
+	fields := []string{}
+	for _, entry := range importer.Report().Entries {
+		fields = append(fields, entry.Field)
+	}
+	g.Expect(fields).To(ConsistOf(
+		"instance.instanceLifecycle",
+		"instance.blockDeviceMappings[2]",
+		"instance.networkInterfaces[1]",
+		"instance.tags[Name]",
+		"instance.tags[aws:cloudformation:stack-name]",
+	))
+}
+
+func TestEC2ImporterToCAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	importer := &EC2Importer{
+		InstancesFile: []byte(testEC2Instances),
+		VolumesFile:   []byte(testEC2Volumes),
+		InstanceID:    "i-0123456789abcdef0",
+		Namespace:     "default",
+	}
+
+	out, err := importer.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(1))
+
+	awsMachineTemplate := &capi.AWSMachineTemplate{}
+	g.Expect(yaml.Unmarshal(out[0], awsMachineTemplate)).To(Succeed())
+	g.Expect(awsMachineTemplate.Name).To(Equal("i-0123456789abcdef0"))
+	g.Expect(awsMachineTemplate.Namespace).To(Equal("default"))
+
+	spec := awsMachineTemplate.Spec.Template.Spec
+	g.Expect(spec.AMI.ID).To(Equal(pointer.String("ami-0123456789abcdef0")))
+	g.Expect(spec.IAMInstanceProfile).To(Equal("cluster-worker-profile"))
+	g.Expect(spec.FailureDomain).To(Equal(pointer.String("us-east-1a")))
+	g.Expect(spec.Tenancy).To(Equal("dedicated"))
+	g.Expect(spec.SpotMarketOptions).To(Equal(&capi.SpotMarketOptions{}))
+	g.Expect(spec.RootVolume).To(Equal(&capi.Volume{
+		Size:          120,
+		Type:          "gp3",
+		IOPS:          3000,
+		Encrypted:     true,
+		EncryptionKey: "arn:aws:kms:us-east-1:123456789012:key/abcd",
+	}))
+	g.Expect(spec.NonRootVolumes).To(Equal([]capi.Volume{{DeviceName: "/dev/xvdb", Size: 500, Type: "st1"}}))
+}
+
+func TestEC2ImporterWithoutVolumes(t *testing.T) {
+	g := NewWithT(t)
+
+	importer := &EC2Importer{InstancesFile: []byte(testEC2Instances)}
+
+	out, err := importer.ToMAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	providerConfig := &mapi.AWSMachineProviderConfig{}
+	g.Expect(yaml.Unmarshal(out[0], providerConfig)).To(Succeed())
+	g.Expect(providerConfig.BlockDevices).To(BeEmpty())
+	report := importer.Report()
+	g.Expect(report.String()).To(ContainSubstring("volume vol-root is not in the describe-volumes output"))
+}
+
+func TestEC2ImporterUnknownInstance(t *testing.T) {
+	g := NewWithT(t)
+
+	importer := &EC2Importer{
+		InstancesFile: []byte(testEC2Instances),
+		InstanceID:    "i-missing",
+	}
+
+	_, err := importer.ToCAPI()
+	g.Expect(err).To(MatchError(ContainSubstring("instance i-missing not found")))
+}
@@ -0,0 +1,293 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/ec2"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/util"
+	"k8s.io/utils/pointer"
+)
+
+// OutputFormat selects what the converter writes.
+type OutputFormat string
+
+const (
+	// OutputFormatYAML writes the converted objects as YAML manifests.
+	OutputFormatYAML OutputFormat = "yaml"
+
+	// OutputFormatRunInstances renders the input machine spec as an EC2
+	// RunInstances request.
+	OutputFormatRunInstances OutputFormat = "run-instances"
+
+	// OutputFormatLaunchTemplate renders the input machine spec as an EC2
+	// CreateLaunchTemplate request.
+	OutputFormatLaunchTemplate OutputFormat = "launch-template"
+)
+
+func (format OutputFormat) isEC2Request() bool {
+	return format != "" && format != OutputFormatYAML
+}
+
+// renderEC2Request wraps the launch data into the request of the output format.
+func renderEC2Request(format OutputFormat, name string, data ec2.LaunchTemplateData) ([][]byte, error) {
+	var request interface{}
+	switch format {
+	case OutputFormatRunInstances:
+		request = ec2.RunInstancesInput{
+			LaunchTemplateData: data,
+			MinCount:           1,
+			MaxCount:           1,
+		}
+	case OutputFormatLaunchTemplate:
+		request = ec2.CreateLaunchTemplateInput{
+			LaunchTemplateName: name,
+			LaunchTemplateData: data,
+		}
+	default:
+		return nil, fmt.Errorf("unknown output format %q, must be one of %s, %s or %s", format, OutputFormatYAML, OutputFormatRunInstances, OutputFormatLaunchTemplate)
+	}
+
+	out, err := json.MarshalIndent(request, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling ec2 request: %v", err)
+	}
+
+	return [][]byte{append(out, '\n')}, nil
+}
+
+// convertProviderConfigToEC2 renders a MAPI providerSpec the way the MAPI
+// actuator launches it. References the request can't carry are reported as
+// unresolved and left empty.
+func convertProviderConfigToEC2(mapiProviderConfig *mapi.AWSMachineProviderConfig, report *ConversionReport) ec2.LaunchTemplateData {
+	data := ec2.LaunchTemplateData{
+		ImageID:      resolveEC2ResourceID(mapiProviderConfig.AMI, mapiProviderSpecPath+".ami", report),
+		InstanceType: mapiProviderConfig.InstanceType,
+		KeyName:      util.DerefString(mapiProviderConfig.KeyName),
+		Placement:    convertPlacementToEC2(mapiProviderConfig.Placement.AvailabilityZone, string(mapiProviderConfig.Placement.Tenancy)),
+	}
+
+	if iamInstanceProfile := mapiProviderConfig.IAMInstanceProfile; iamInstanceProfile != nil {
+		switch {
+		case iamInstanceProfile.ID != nil:
+			data.IAMInstanceProfile = &ec2.IAMInstanceProfileSpecification{Name: *iamInstanceProfile.ID}
+		case iamInstanceProfile.ARN != nil:
+			data.IAMInstanceProfile = &ec2.IAMInstanceProfileSpecification{ARN: *iamInstanceProfile.ARN}
+		default:
+			resolveEC2ResourceID(*iamInstanceProfile, mapiProviderSpecPath+".iamInstanceProfile", report)
+		}
+	}
+
+	networkInterface := ec2.NetworkInterfaceSpecification{
+		DeviceIndex:              mapiProviderConfig.DeviceIndex,
+		SubnetID:                 resolveEC2ResourceID(mapiProviderConfig.Subnet, mapiProviderSpecPath+".subnet", report),
+		AssociatePublicIPAddress: mapiProviderConfig.PublicIP,
+	}
+	for i, securityGroup := range mapiProviderConfig.SecurityGroups {
+		if id := resolveEC2ResourceID(securityGroup, fmt.Sprintf("%s.securityGroups[%d]", mapiProviderSpecPath, i), report); id != "" {
+			networkInterface.Groups = append(networkInterface.Groups, id)
+		}
+	}
+	data.NetworkInterfaces = []ec2.NetworkInterfaceSpecification{networkInterface}
+
+	for i, blockDevice := range mapiProviderConfig.BlockDevices {
+		field := fmt.Sprintf("%s.blockDevices[%d]", mapiProviderSpecPath, i)
+		mapping := ec2.BlockDeviceMappingSpecification{
+			DeviceName: util.DerefString(blockDevice.DeviceName),
+		}
+		if blockDevice.DeviceName == nil {
+			reportUnresolvedRootDeviceName(field+".deviceName", report)
+		}
+		if blockDevice.EBS != nil {
+			mapping.EBS = &ec2.EBSBlockDeviceSpecification{
+				VolumeSize:          blockDevice.EBS.VolumeSize,
+				VolumeType:          util.DerefString(blockDevice.EBS.VolumeType),
+				IOPS:                positiveOrNil(blockDevice.EBS.Iops),
+				Encrypted:           blockDevice.EBS.Encrypted,
+				KMSKeyID:            resolveEC2KMSKey(blockDevice.EBS.KMSKey, field+".ebs.kmsKey", report),
+				DeleteOnTermination: blockDevice.EBS.DeleteOnTermination,
+			}
+			if mapping.EBS.DeleteOnTermination == nil {
+				mapping.EBS.DeleteOnTermination = pointer.Bool(true)
+			}
+		}
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, mapping)
+	}
+
+	if mapiProviderConfig.SpotMarketOptions != nil {
+		data.InstanceMarketOptions = convertSpotMarketOptionsToEC2(util.DerefString(mapiProviderConfig.SpotMarketOptions.MaxPrice))
+	}
+
+	tags := []ec2.Tag{}
+	for _, tag := range mapiProviderConfig.Tags {
+		tags = append(tags, ec2.Tag{Key: tag.Name, Value: tag.Value})
+	}
+	data.TagSpecifications = convertTagsToEC2(tags)
+
+	return data
+}
+
+// convertAWSMachineSpecToEC2 renders a CAPA machine spec the way CAPA launches
+// it. References the request can't carry are reported as unresolved and left empty.
+func convertAWSMachineSpecToEC2(spec capi.AWSMachineSpec, report *ConversionReport) (ec2.LaunchTemplateData, error) {
+	// The references have the same shape in both APIs, they are resolved from
+	// the MAPI ones.
+	mapiProviderConfig, err := convertAWSMachineSpecToProviderConfig(spec)
+	if err != nil {
+		return ec2.LaunchTemplateData{}, err
+	}
+
+	data := ec2.LaunchTemplateData{
+		ImageID:      resolveEC2ResourceID(mapiProviderConfig.AMI, capiAWSMachineSpecPath+".ami", report),
+		InstanceType: spec.InstanceType,
+		KeyName:      util.DerefString(spec.SSHKeyName),
+		Placement:    convertPlacementToEC2(util.DerefString(spec.FailureDomain), spec.Tenancy),
+	}
+	if isEmptyAWSResourceReference(mapiProviderConfig.AMI) {
+		report.add(capiAWSMachineSpecPath+".imageLookupFormat", "image lookup is unresolved, the request needs the AMI id")
+	}
+
+	if spec.IAMInstanceProfile != "" {
+		data.IAMInstanceProfile = &ec2.IAMInstanceProfileSpecification{Name: spec.IAMInstanceProfile}
+	}
+
+	if len(spec.NetworkInterfaces) > 0 {
+		for i, networkInterface := range spec.NetworkInterfaces {
+			data.NetworkInterfaces = append(data.NetworkInterfaces, ec2.NetworkInterfaceSpecification{
+				DeviceIndex:        int64(i),
+				NetworkInterfaceID: networkInterface,
+			})
+		}
+	} else {
+		networkInterface := ec2.NetworkInterfaceSpecification{
+			AssociatePublicIPAddress: spec.PublicIP,
+		}
+		if spec.Subnet != nil {
+			networkInterface.SubnetID = resolveEC2ResourceID(mapiProviderConfig.Subnet, capiAWSMachineSpecPath+".subnet", report)
+		}
+		for i, securityGroup := range mapiProviderConfig.SecurityGroups {
+			if id := resolveEC2ResourceID(securityGroup, fmt.Sprintf("%s.additionalSecurityGroups[%d]", capiAWSMachineSpecPath, i), report); id != "" {
+				networkInterface.Groups = append(networkInterface.Groups, id)
+			}
+		}
+		data.NetworkInterfaces = []ec2.NetworkInterfaceSpecification{networkInterface}
+	}
+
+	if spec.RootVolume != nil {
+		if spec.RootVolume.DeviceName == "" {
+			reportUnresolvedRootDeviceName(capiAWSMachineSpecPath+".rootVolume.deviceName", report)
+		}
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, convertVolumeToEC2(*spec.RootVolume))
+	}
+	for _, volume := range spec.NonRootVolumes {
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, convertVolumeToEC2(volume))
+	}
+
+	if spec.SpotMarketOptions != nil {
+		data.InstanceMarketOptions = convertSpotMarketOptionsToEC2(util.DerefString(spec.SpotMarketOptions.MaxPrice))
+	}
+
+	keys := make([]string, 0, len(spec.AdditionalTags))
+	for key := range spec.AdditionalTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tags := []ec2.Tag{}
+	for _, key := range keys {
+		tags = append(tags, ec2.Tag{Key: key, Value: spec.AdditionalTags[key]})
+	}
+	data.TagSpecifications = convertTagsToEC2(tags)
+
+	return data, nil
+}
+
+// resolveEC2ResourceID returns the ID of a resource reference. Resolving an ARN
+// or filters into an ID needs AWS, so they are reported as unresolved.
+func resolveEC2ResourceID(reference mapi.AWSResourceReference, field string, report *ConversionReport) string {
+	switch {
+	case reference.ID != nil:
+		return *reference.ID
+	case reference.ARN != nil:
+		report.add(field+".arn", "arn %s is unresolved, the request needs the resource id", *reference.ARN)
+	case len(reference.Filters) > 0:
+		report.add(field+".filters", "filters %s are unresolved, the request needs the resource id", formatFilters(reference.Filters))
+	}
+	return ""
+}
+
+// resolveEC2KMSKey returns the KMS key ID or ARN, both are accepted by EC2.
+func resolveEC2KMSKey(kmsKey mapi.AWSResourceReference, field string, report *ConversionReport) string {
+	if kmsKey.ARN != nil && kmsKey.ID == nil {
+		return *kmsKey.ARN
+	}
+	return resolveEC2ResourceID(kmsKey, field, report)
+}
+
+func formatFilters(filters []mapi.Filter) string {
+	formatted := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		formatted = append(formatted, fmt.Sprintf("%s=%s", filter.Name, strings.Join(filter.Values, ",")))
+	}
+	return strings.Join(formatted, " ")
+}
+
+// reportUnresolvedRootDeviceName flags root volumes without a device name. Both
+// actuators look it up from the AMI before launching.
+func reportUnresolvedRootDeviceName(field string, report *ConversionReport) {
+	report.add(field, "the root device name is unresolved, it is the root device name of the AMI")
+}
+
+func convertPlacementToEC2(availabilityZone, tenancy string) *ec2.PlacementSpecification {
+	if availabilityZone == "" && tenancy == "" {
+		return nil
+	}
+	return &ec2.PlacementSpecification{
+		AvailabilityZone: availabilityZone,
+		Tenancy:          tenancy,
+	}
+}
+
+func convertVolumeToEC2(volume capi.Volume) ec2.BlockDeviceMappingSpecification {
+	return ec2.BlockDeviceMappingSpecification{
+		DeviceName: volume.DeviceName,
+		EBS: &ec2.EBSBlockDeviceSpecification{
+			VolumeSize:          positiveOrNil(&volume.Size),
+			VolumeType:          volume.Type,
+			IOPS:                positiveOrNil(&volume.IOPS),
+			Encrypted:           pointer.Bool(volume.Encrypted),
+			KMSKeyID:            volume.EncryptionKey,
+			DeleteOnTermination: pointer.Bool(true),
+		},
+	}
+}
+
+func convertSpotMarketOptionsToEC2(maxPrice string) *ec2.InstanceMarketOptions {
+	return &ec2.InstanceMarketOptions{
+		MarketType: ec2.MarketTypeSpot,
+		SpotOptions: &ec2.SpotOptions{
+			MaxPrice: maxPrice,
+		},
+	}
+}
+
+// convertTagsToEC2 tags both the instance and its volumes, like the actuators do.
+func convertTagsToEC2(tags []ec2.Tag) []ec2.TagSpecification {
+	if len(tags) == 0 {
+		return nil
+	}
+	return []ec2.TagSpecification{
+		{ResourceType: ec2.ResourceTypeInstance, Tags: tags},
+		{ResourceType: ec2.ResourceTypeVolume, Tags: tags},
+	}
+}
+
+func positiveOrNil(value *int64) *int64 {
+	if value == nil || *value <= 0 {
+		return nil
+	}
+	return pointer.Int64(*value)
+}
@@ -0,0 +1,159 @@
+package converter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/ec2"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+)
+
+func TestConvertProviderConfigToEC2(t *testing.T) {
+	g := NewWithT(t)
+
+	report := &ConversionReport{}
+	data := convertProviderConfigToEC2(&mapi.AWSMachineProviderConfig{
+		AMI:                mapi.AWSResourceReference{ID: pointer.String("ami-0123")},
+		InstanceType:       "m5.large",
+		Tags:               []mapi.TagSpecification{{Name: "team", Value: "infra"}},
+		IAMInstanceProfile: &mapi.AWSResourceReference{ID: pointer.String("worker-profile")},
+		KeyName:            pointer.String("admin"),
+		DeviceIndex:        1,
+		PublicIP:           pointer.Bool(true),
+		SecurityGroups: []mapi.AWSResourceReference{
+			{ID: pointer.String("sg-worker")},
+			{Filters: []mapi.Filter{{Name: "tag:Name", Values: []string{"cluster-worker-sg"}}}},
+		},
+		Subnet:    mapi.AWSResourceReference{ARN: pointer.String("arn:aws:ec2:us-east-1:123456789012:subnet/subnet-0123")},
+		Placement: mapi.Placement{AvailabilityZone: "us-east-1a", Tenancy: mapi.DedicatedTenancy},
+		BlockDevices: []mapi.BlockDeviceMappingSpec{
+			{
+				EBS: &mapi.EBSBlockDeviceSpec{
+					VolumeSize: pointer.Int64(120),
+					VolumeType: pointer.String("gp3"),
+					Iops:       pointer.Int64(0),
+					Encrypted:  pointer.Bool(true),
+					KMSKey:     mapi.AWSResourceReference{ARN: pointer.String("arn:aws:kms:us-east-1:123456789012:key/abcd")},
+				},
+			},
+		},
+		SpotMarketOptions: &mapi.SpotMarketOptions{MaxPrice: pointer.String("0.05")},
+	}, report)
+
+	g.Expect(data).To(Equal(ec2.LaunchTemplateData{
+		ImageID:            "ami-0123",
+		InstanceType:       "m5.large",
+		KeyName:            "admin",
+		IAMInstanceProfile: &ec2.IAMInstanceProfileSpecification{Name: "worker-profile"},
+		NetworkInterfaces: []ec2.NetworkInterfaceSpecification{{
+			DeviceIndex:              1,
+			Groups:                   []string{"sg-worker"},
+			AssociatePublicIPAddress: pointer.Bool(true),
+		}},
+		BlockDeviceMappings: []ec2.BlockDeviceMappingSpecification{{
+			EBS: &ec2.EBSBlockDeviceSpecification{
+				VolumeSize:          pointer.Int64(120),
+				VolumeType:          "gp3",
+				Encrypted:           pointer.Bool(true),
+				KMSKeyID:            "arn:aws:kms:us-east-1:123456789012:key/abcd",
+				DeleteOnTermination: pointer.Bool(true),
+			},
+		}},
+		Placement: &ec2.PlacementSpecification{AvailabilityZone: "us-east-1a", Tenancy: "dedicated"},
+		InstanceMarketOptions: &ec2.InstanceMarketOptions{
+			MarketType:  ec2.MarketTypeSpot,
+			SpotOptions: &ec2.SpotOptions{MaxPrice: "0.05"},
+		},
+		TagSpecifications: []ec2.TagSpecification{
+			{ResourceType: ec2.ResourceTypeInstance, Tags: []ec2.Tag{{Key: "team", Value: "infra"}}},
+			{ResourceType: ec2.ResourceTypeVolume, Tags: []ec2.Tag{{Key: "team", Value: "infra"}}},
+		},
+	}))
+
+	g.Expect(report.Entries).To(HaveLen(3))
+	g.Expect(report.Entries[0].Field).To(Equal(mapiProviderSpecPath + ".subnet.arn"))
+	g.Expect(report.Entries[1].Field).To(Equal(mapiProviderSpecPath + ".securityGroups[1].filters"))
+	g.Expect(report.Entries[1].Message).To(ContainSubstring("tag:Name=cluster-worker-sg"))
+	g.Expect(report.Entries[2].Field).To(Equal(mapiProviderSpecPath + ".blockDevices[0].deviceName"))
+}
+
+func TestConvertAWSMachineSpecToEC2(t *testing.T) {
+	g := NewWithT(t)
+
+	report := &ConversionReport{}
+	data, err := convertAWSMachineSpecToEC2(capi.AWSMachineSpec{
+		ImageLookupOrg:     "123456789012",
+		InstanceType:       "m5.large",
+		AdditionalTags:     capi.Tags{"team": "infra", "env": "prod"},
+		IAMInstanceProfile: "worker-profile",
+		FailureDomain:      pointer.String("us-east-1a"),
+		NetworkInterfaces:  []string{"eni-primary", "eni-secondary"},
+		RootVolume:         &capi.Volume{DeviceName: "/dev/xvda", Size: 120, Type: "gp3"},
+		NonRootVolumes:     []capi.Volume{{DeviceName: "/dev/xvdb", Size: 500, Type: "io1", IOPS: 1000, Encrypted: true}},
+	}, report)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(data.ImageID).To(BeEmpty())
+	g.Expect(data.IAMInstanceProfile).To(Equal(&ec2.IAMInstanceProfileSpecification{Name: "worker-profile"}))
+	g.Expect(data.NetworkInterfaces).To(Equal([]ec2.NetworkInterfaceSpecification{
+		{DeviceIndex: 0, NetworkInterfaceID: "eni-primary"},
+		{DeviceIndex: 1, NetworkInterfaceID: "eni-secondary"},
+	}))
+	g.Expect(data.BlockDeviceMappings).To(HaveLen(2))
+	g.Expect(data.BlockDeviceMappings[0].DeviceName).To(Equal("/dev/xvda"))
+	g.Expect(data.BlockDeviceMappings[1].EBS.IOPS).To(Equal(pointer.Int64(1000)))
+	g.Expect(data.Placement).To(Equal(&ec2.PlacementSpecification{AvailabilityZone: "us-east-1a"}))
+	g.Expect(data.InstanceMarketOptions).To(BeNil())
+	g.Expect(data.TagSpecifications[0].Tags).To(Equal([]ec2.Tag{{Key: "env", Value: "prod"}, {Key: "team", Value: "infra"}}))
+
+	g.Expect(report.Entries).To(HaveLen(1))
+	g.Expect(report.Entries[0].Field).To(Equal(capiAWSMachineSpecPath + ".imageLookupFormat"))
+}
+
+func TestToCAPIOutputFormat(t *testing.T) {
+	g := NewWithT(t)
+
+	converter := &AWSConverter{
+		MachineSetFile: []byte(testMachineSet),
+		OutputFormat:   OutputFormatLaunchTemplate,
+	}
+
+	out, err := converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(1))
+
+	request := &ec2.CreateLaunchTemplateInput{}
+	g.Expect(json.Unmarshal(out[0], request)).To(Succeed())
+	g.Expect(request.LaunchTemplateName).To(Equal("worker-us-east-1a"))
+	g.Expect(request.LaunchTemplateData.ImageID).To(Equal("ami-x86-east"))
+
+	converter.OutputFormat = OutputFormatRunInstances
+	out, err = converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(out[0])).To(ContainSubstring(`"MaxCount": 1`))
+
+	converter.OutputFormat = "terraform"
+	_, err = converter.ToCAPI()
+	g.Expect(err).To(MatchError(ContainSubstring(`unknown output format "terraform"`)))
+}
+
+func TestToMAPIOutputFormatValidatesNetworkInterfaces(t *testing.T) {
+	g := NewWithT(t)
+
+	machineTemplate, err := awsMachineTemplateWithSpec(capi.AWSMachineSpec{
+		InstanceType:      "m5.large",
+		NetworkInterfaces: []string{"eni-1", "eni-2", "eni-3"},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	converter := &AWSConverter{
+		MachineTemplateFile: machineTemplate,
+		MachineSetFile:      []byte(testCAPIMachineSet),
+		OutputFormat:        OutputFormatRunInstances,
+	}
+	_, err = converter.ToMAPI()
+	g.Expect(err).To(MatchError("invalid network interfaces: at most 2 may be specified, got 3"))
+}
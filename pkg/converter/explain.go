@@ -0,0 +1,227 @@
+package converter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+)
+
+// FieldExplanation describes how a field of a MAPI machine set or of a CAPA
+// AWSMachineTemplate is converted, from the same mappings and round trip
+// losses the converter uses.
+type FieldExplanation struct {
+	// Field is the path of the explained field, list indexes are replaced by [*].
+	Field string
+
+	// API is the API of the field, MAPI or CAPI.
+	API string
+
+	// Mappings are the fields of the other API the field converts to.
+	Mappings []FieldMappingExplanation
+
+	// Losses are the fields, at, above or under the explained one, that are
+	// lost converting to the other API and back.
+	Losses []FieldLoss
+}
+
+// FieldMappingExplanation describes the conversion of a field to a field of
+// the other API.
+type FieldMappingExplanation struct {
+	// Field is the path of the field in the other API.
+	Field string
+
+	// Transform describes how the value is converted, in both directions.
+	Transform string
+
+	// Reverse is the field the converted field converts back to.
+	Reverse string
+
+	// ReverseLosses are the fields that are lost converting the converted
+	// field back and forth again.
+	ReverseLosses []FieldLoss
+}
+
+// Lossy returns whether any of the explained fields is lost in a round trip.
+func (e *FieldExplanation) Lossy() bool {
+	if len(e.Losses) > 0 {
+		return true
+	}
+	for _, mapping := range e.Mappings {
+		if len(mapping.ReverseLosses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *FieldExplanation) String() string {
+	other := "CAPI"
+	if e.API == other {
+		other = "MAPI"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s field %s\n", e.API, e.Field)
+	if len(e.Mappings) == 0 {
+		fmt.Fprintf(&sb, "\nNot converted to %s\n", other)
+	}
+	for _, mapping := range e.Mappings {
+		fmt.Fprintf(&sb, "\nConverts to %s %s\n", other, mapping.Field)
+		fmt.Fprintf(&sb, "  Transform: %s\n", mapping.Transform)
+		fmt.Fprintf(&sb, "  Reverse:   %s converts back to %s\n", mapping.Field, mapping.Reverse)
+		writeExplainedLosses(&sb, "  ", "Lost converting it back and forth:", mapping.ReverseLosses)
+	}
+
+	lossy := "no"
+	if e.Lossy() {
+		lossy = "yes"
+	}
+	fmt.Fprintf(&sb, "\nLossy: %s\n", lossy)
+	writeExplainedLosses(&sb, "", fmt.Sprintf("Lost converting %s to %s and back:", e.API, other), e.Losses)
+	return sb.String()
+}
+
+func writeExplainedLosses(sb *strings.Builder, indent, title string, losses []FieldLoss) {
+	if len(losses) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "%s%s\n", indent, title)
+	for _, loss := range losses {
+		fmt.Fprintf(sb, "%s  %s: %s\n", indent, loss.Field, loss.Reason)
+	}
+}
+
+// ExplainAWSField explains a providerSpec field of a MAPI machine set, e.g.
+// spec.template.spec.providerSpec.value.blockDevices, or a spec field of a
+// CAPA AWSMachineTemplate, e.g. spec.template.spec.rootVolume.size.
+func ExplainAWSField(field string) (*FieldExplanation, error) {
+	mapiType := reflect.TypeOf(mapi.AWSMachineProviderConfig{})
+	capiType := reflect.TypeOf(capi.AWSMachineSpec{})
+
+	api, prefix, otherPrefix, t := "MAPI", mapiProviderSpecPath, capiAWSMachineSpecPath, mapiType
+	losses, otherLosses := MAPIRoundTripLosses, CAPIRoundTripLosses
+	if !strings.HasPrefix(field, mapiProviderSpecPath+".") {
+		if !strings.HasPrefix(field, capiAWSMachineSpecPath+".") {
+			return nil, fmt.Errorf("field %q is neither a field of %s of a MAPI machine set nor of %s of an AWSMachineTemplate", field, mapiProviderSpecPath, capiAWSMachineSpecPath)
+		}
+		api, prefix, otherPrefix, t = "CAPI", capiAWSMachineSpecPath, mapiProviderSpecPath, capiType
+		losses, otherLosses = CAPIRoundTripLosses, MAPIRoundTripLosses
+	}
+
+	path, err := normalizeFieldPath(t, strings.TrimPrefix(field, prefix+"."))
+	if err != nil {
+		return nil, fmt.Errorf("invalid field %q: %v", field, err)
+	}
+
+	explanation := &FieldExplanation{
+		Field:  prefix + "." + path,
+		API:    api,
+		Losses: overlappingLosses(prefix, path, losses),
+	}
+	for _, row := range explainedRows(fieldMappingRows(awsFieldMappings, mapiType, capiType, "", ""), path, api == "MAPI") {
+		explanation.Mappings = append(explanation.Mappings, FieldMappingExplanation{
+			Field:         otherPrefix + "." + row.to,
+			Transform:     row.transform,
+			Reverse:       prefix + "." + row.from,
+			ReverseLosses: overlappingLosses(otherPrefix, row.to, otherLosses),
+		})
+	}
+	return explanation, nil
+}
+
+// explainedRow is a mapping of an explained field, from its API to the other.
+type explainedRow struct {
+	from, to, transform string
+}
+
+// explainedRows returns the mappings of a field. Fields without a mapping of
+// their own are explained by the mappings of their fields, or else by the
+// closest mapping of a parent.
+func explainedRows(rows []fieldMappingRow, path string, fromMAPI bool) []explainedRow {
+	exact, children, parents := []explainedRow{}, []explainedRow{}, []explainedRow{}
+	parentDepth := 0
+	for _, row := range rows {
+		from, to := row.mapi, row.capi
+		if !fromMAPI {
+			from, to = row.capi, row.mapi
+		}
+		transform := row.note
+		if row.copied {
+			transform = "copied as is"
+		}
+
+		switch {
+		case from == path:
+			exact = append(exact, explainedRow{from: from, to: to, transform: transform})
+		case isUnderField(from, path):
+			children = append(children, explainedRow{from: from, to: to, transform: transform})
+		case isUnderField(path, from) && len(from) >= parentDepth:
+			if len(from) > parentDepth {
+				parents, parentDepth = parents[:0], len(from)
+			}
+			if row.copied {
+				// The fields of copied values keep their path.
+				suffix := strings.TrimPrefix(path, from)
+				from, to = from+suffix, to+suffix
+			}
+			parents = append(parents, explainedRow{from: from, to: to, transform: transform})
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	if len(children) > 0 {
+		return children
+	}
+	return parents
+}
+
+// overlappingLosses returns the losses of a field, of its fields and of its
+// parents, prefixed with the path of the spec.
+func overlappingLosses(prefix, path string, losses []FieldLoss) []FieldLoss {
+	overlapping := []FieldLoss{}
+	for _, loss := range losses {
+		if isUnderField(loss.Field, path) || isUnderField(path, loss.Field) {
+			overlapping = append(overlapping, prefixFieldLoss(prefix, loss))
+		}
+	}
+	return overlapping
+}
+
+func prefixFieldLoss(prefix string, loss FieldLoss) FieldLoss {
+	loss.Field = prefix + "." + loss.Field
+	if loss.ReplacedBy != "" {
+		loss.ReplacedBy = prefix + "." + loss.ReplacedBy
+	}
+	return loss
+}
+
+// isUnderField returns whether path is field or one of its fields.
+func isUnderField(path, field string) bool {
+	return path == field || strings.HasPrefix(path, field+".") || strings.HasPrefix(path, field+"[")
+}
+
+// normalizeFieldPath checks a path of t and replaces its list indexes by [*],
+// list fields without index are suffixed by [*] too.
+func normalizeFieldPath(t reflect.Type, path string) (string, error) {
+	fields := strings.Split(path, ".")
+	for i, field := range fields {
+		if index := strings.Index(field, "["); index >= 0 {
+			field = field[:index]
+		}
+		fieldType, ok := jsonFields(t)[field]
+		if !ok {
+			return "", fmt.Errorf("unknown field %q", strings.Join(append(fields[:i:i], field), "."))
+		}
+		fields[i] = field
+		if fieldType.Kind() == reflect.Slice {
+			fields[i] += "[*]"
+		}
+		t = elemType(fieldType)
+	}
+	return strings.Join(fields, "."), nil
+}
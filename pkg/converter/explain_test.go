@@ -0,0 +1,174 @@
+package converter
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestExplainAWSField(t *testing.T) {
+	testCases := []struct {
+		name     string
+		field    string
+		expected *FieldExplanation
+		err      string
+	}{
+		{
+			name:  "transformed list",
+			field: "spec.template.spec.providerSpec.value.blockDevices",
+			expected: &FieldExplanation{
+				Field: "spec.template.spec.providerSpec.value.blockDevices[*]",
+				API:   "MAPI",
+				Mappings: []FieldMappingExplanation{
+					{
+						Field:     "spec.template.spec.rootVolume",
+						Transform: "the block device without a device name, there can only be one",
+						Reverse:   "spec.template.spec.providerSpec.value.blockDevices[*]",
+						ReverseLosses: []FieldLoss{
+							{Field: "spec.template.spec.rootVolume.deviceName", Reason: "MAPI takes the root device name from the AMI"},
+						},
+					},
+					{
+						Field:         "spec.template.spec.nonRootVolumes[*]",
+						Transform:     "the block devices with a device name and an EBS volume size, after the root volume in MAPI",
+						Reverse:       "spec.template.spec.providerSpec.value.blockDevices[*]",
+						ReverseLosses: []FieldLoss{},
+					},
+				},
+				Losses: []FieldLoss{
+					{Field: "spec.template.spec.providerSpec.value.blockDevices[*].noDevice", Reason: "CAPA volumes can't suppress a device of the AMI"},
+					{Field: "spec.template.spec.providerSpec.value.blockDevices[*].virtualName", Reason: "CAPA volumes can't be instance store volumes"},
+					{Field: "spec.template.spec.providerSpec.value.blockDevices[*].ebs.deleteOnTermination", Reason: "CAPA always deletes volumes with the instance"},
+					{Field: "spec.template.spec.providerSpec.value.blockDevices[*].ebs.kmsKey.filters", Reason: "CAPA takes a KMS key ID or ARN"},
+				},
+			},
+		},
+		{
+			name:  "field of a copied field",
+			field: "spec.template.spec.providerSpec.value.securityGroups[1].id",
+			expected: &FieldExplanation{
+				Field: "spec.template.spec.providerSpec.value.securityGroups[*].id",
+				API:   "MAPI",
+				Mappings: []FieldMappingExplanation{
+					{
+						Field:         "spec.template.spec.additionalSecurityGroups[*].id",
+						Transform:     "copied as is",
+						Reverse:       "spec.template.spec.providerSpec.value.securityGroups[*].id",
+						ReverseLosses: []FieldLoss{},
+					},
+				},
+				Losses: []FieldLoss{},
+			},
+		},
+		{
+			name:  "parent of mapped fields",
+			field: "spec.template.spec.providerSpec.value.placement",
+			expected: &FieldExplanation{
+				Field: "spec.template.spec.providerSpec.value.placement",
+				API:   "MAPI",
+				Mappings: []FieldMappingExplanation{
+					{
+						Field:         "spec.template.spec.failureDomain",
+						Transform:     "copied as is",
+						Reverse:       "spec.template.spec.providerSpec.value.placement.availabilityZone",
+						ReverseLosses: []FieldLoss{},
+					},
+					{
+						Field:         "spec.template.spec.tenancy",
+						Transform:     "must be one of default, dedicated or host",
+						Reverse:       "spec.template.spec.providerSpec.value.placement.tenancy",
+						ReverseLosses: []FieldLoss{},
+					},
+				},
+				Losses: []FieldLoss{
+					{Field: "spec.template.spec.providerSpec.value.placement.region", Reason: "CAPA takes the region from the AWSCluster, it comes back derived from the availability zone"},
+				},
+			},
+		},
+		{
+			name:  "capi field",
+			field: "spec.template.spec.nonRootVolumes[0].size",
+			expected: &FieldExplanation{
+				Field: "spec.template.spec.nonRootVolumes[*].size",
+				API:   "CAPI",
+				Mappings: []FieldMappingExplanation{
+					{
+						Field:         "spec.template.spec.providerSpec.value.blockDevices[*].ebs.volumeSize",
+						Transform:     "0 is omitted in MAPI",
+						Reverse:       "spec.template.spec.nonRootVolumes[*].size",
+						ReverseLosses: []FieldLoss{},
+					},
+				},
+				Losses: []FieldLoss{},
+			},
+		},
+		{
+			name:  "lost field",
+			field: "spec.template.spec.cloudInit.secureSecretsBackend",
+			expected: &FieldExplanation{
+				Field: "spec.template.spec.cloudInit.secureSecretsBackend",
+				API:   "CAPI",
+				Losses: []FieldLoss{
+					{Field: "spec.template.spec.cloudInit", Reason: "MAPI has no bootstrap options, the Bootstrap options of the converter are applied instead"},
+				},
+			},
+		},
+		{
+			name:  "unknown field",
+			field: "spec.template.spec.providerSpec.value.blockDevices[0].ebs.size",
+			err:   `invalid field "spec.template.spec.providerSpec.value.blockDevices[0].ebs.size": unknown field "blockDevices[*].ebs.size"`,
+		},
+		{
+			name:  "field of another object",
+			field: "spec.replicas",
+			err:   `field "spec.replicas" is neither a field of spec.template.spec.providerSpec.value of a MAPI machine set nor of spec.template.spec of an AWSMachineTemplate`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			explanation, err := ExplainAWSField(tc.field)
+			if tc.err != "" {
+				g.Expect(err).To(MatchError(tc.err))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(explanation).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestFieldExplanationString(t *testing.T) {
+	g := NewWithT(t)
+
+	explanation, err := ExplainAWSField("spec.template.spec.providerSpec.value.iamInstanceProfile.arn")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(explanation.Lossy()).To(BeTrue())
+	g.Expect(explanation.String()).To(Equal(`MAPI field spec.template.spec.providerSpec.value.iamInstanceProfile.arn
+
+Converts to CAPI spec.template.spec.iamInstanceProfile
+  Transform: CAPA takes the instance profile name, MAPI ARNs are reduced to it and filters are rejected
+  Reverse:   spec.template.spec.iamInstanceProfile converts back to spec.template.spec.providerSpec.value.iamInstanceProfile
+
+Lossy: yes
+Lost converting MAPI to CAPI and back:
+  spec.template.spec.providerSpec.value.iamInstanceProfile.arn: CAPA takes the instance profile name, which comes back as iamInstanceProfile.id
+`))
+
+	explanation, err = ExplainAWSField("spec.template.spec.providerSpec.value.deviceIndex")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(explanation.String()).To(Equal(`MAPI field spec.template.spec.providerSpec.value.deviceIndex
+
+Not converted to CAPI
+
+Lossy: yes
+Lost converting MAPI to CAPI and back:
+  spec.template.spec.providerSpec.value.deviceIndex: CAPA always attaches the primary interface at device index 0
+`))
+
+	explanation, err = ExplainAWSField("spec.template.spec.ami")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(explanation.Lossy()).To(BeFalse())
+}
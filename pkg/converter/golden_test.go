@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+const goldenDir = "testdata/golden"
+
+// TestGolden converts the MAPI machine set of every testdata/golden case to
+// CAPI and back, and compares both outputs, with the conversion report as
+// leading comments, to capi.yaml and mapi.yaml. Run with -update to
+// regenerate them.
+func TestGolden(t *testing.T) {
+	cases, err := ioutil.ReadDir(goldenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		if !c.IsDir() {
+			continue
+		}
+		dir := filepath.Join(goldenDir, c.Name())
+
+		t.Run(c.Name(), func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineSet, err := ioutil.ReadFile(filepath.Join(dir, "machineset.yaml"))
+			g.Expect(err).NotTo(HaveOccurred())
+
+			var converter Converter = &AWSConverter{MachineSetFile: machineSet}
+			capiObjects, err := converter.ToCAPI()
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(capiObjects).To(HaveLen(2))
+			report := converter.Report()
+			compareGolden(g, filepath.Join(dir, "capi.yaml"), goldenDocument(capiObjects, report))
+
+			converter = &AWSConverter{MachineTemplateFile: capiObjects[0], MachineSetFile: capiObjects[1]}
+			mapiObjects, err := converter.ToMAPI()
+			g.Expect(err).NotTo(HaveOccurred())
+			report = converter.Report()
+			compareGolden(g, filepath.Join(dir, "mapi.yaml"), goldenDocument(mapiObjects, report))
+		})
+	}
+}
+
+func goldenDocument(objects [][]byte, report ConversionReport) []byte {
+	out := &bytes.Buffer{}
+	for _, entry := range report.Entries {
+		fmt.Fprintf(out, "# %s: %s\n", entry.Field, entry.Message)
+	}
+	for _, object := range objects {
+		fmt.Fprintf(out, "---\n%s", object)
+	}
+	return out.Bytes()
+}
+
+func compareGolden(g *WithT, path string, actual []byte) {
+	if *update {
+		g.Expect(ioutil.WriteFile(path, actual, 0644)).To(Succeed())
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	g.Expect(err).NotTo(HaveOccurred(), "run go test with -update to create the golden file")
+	g.Expect(string(actual)).To(Equal(string(expected)), "%s is outdated, run go test with -update if the change is expected", path)
+}
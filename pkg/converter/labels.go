@@ -0,0 +1,138 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const mapiMachineSetLabelName = "machine.openshift.io/cluster-api-machineset"
+
+// LabelTranslation maps a MAPI label key to its CAPI counterpart.
+type LabelTranslation struct {
+	MAPI string `json:"mapi"`
+	CAPI string `json:"capi"`
+}
+
+// LabelTranslations is the table applied to selectors and template labels.
+// Keys missing from the table are copied verbatim, which is what happens to
+// the machine role and type labels by default as CAPI has no equivalent.
+type LabelTranslations []LabelTranslation
+
+// DefaultLabelTranslations maps the MAPI cluster ID and machine set labels to
+// the labels CAPI controllers select machines by.
+var DefaultLabelTranslations = LabelTranslations{
+	{MAPI: mapi.MachineClusterIDLabel, CAPI: capi.ClusterLabelName},
+	{MAPI: mapiMachineSetLabelName, CAPI: capi.MachineSetLabelName},
+}
+
+// ParseLabelTranslations reads a YAML or JSON list of mapi/capi label key pairs.
+func ParseLabelTranslations(data []byte) (LabelTranslations, error) {
+	translations := LabelTranslations{}
+	if err := yaml.Unmarshal(data, &translations); err != nil {
+		return nil, fmt.Errorf("error unmarshalling label translations: %v", err)
+	}
+
+	mapiKeys := map[string]bool{}
+	capiKeys := map[string]bool{}
+	for _, translation := range translations {
+		if translation.MAPI == "" || translation.CAPI == "" {
+			return nil, fmt.Errorf("label translation %v must set both mapi and capi keys", translation)
+		}
+		if mapiKeys[translation.MAPI] || capiKeys[translation.CAPI] {
+			return nil, fmt.Errorf("label translation %v is ambiguous, keys can only be translated once", translation)
+		}
+		mapiKeys[translation.MAPI] = true
+		capiKeys[translation.CAPI] = true
+	}
+
+	return translations, nil
+}
+
+func (t LabelTranslations) toCAPI(key string) string {
+	for _, translation := range t {
+		if translation.MAPI == key {
+			return translation.CAPI
+		}
+	}
+	return key
+}
+
+func (t LabelTranslations) toMAPI(key string) string {
+	for _, translation := range t {
+		if translation.CAPI == key {
+			return translation.MAPI
+		}
+	}
+	return key
+}
+
+func translateLabels(labels map[string]string, translate func(string) string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+
+	translated := make(map[string]string, len(labels))
+	for key, value := range labels {
+		translated[translate(key)] = value
+	}
+	return translated
+}
+
+func translateLabelSelector(selector metav1.LabelSelector, translate func(string) string) metav1.LabelSelector {
+	translated := metav1.LabelSelector{
+		MatchLabels: translateLabels(selector.MatchLabels, translate),
+	}
+
+	for _, requirement := range selector.MatchExpressions {
+		translated.MatchExpressions = append(translated.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      translate(requirement.Key),
+			Operator: requirement.Operator,
+			Values:   requirement.Values,
+		})
+	}
+
+	return translated
+}
+
+// clusterNameFromLabels returns the value of the cluster label from the selector,
+// or from the template labels when the selector doesn't set it.
+func clusterNameFromLabels(selector metav1.LabelSelector, templateLabels map[string]string, key string) string {
+	if clusterName := selector.MatchLabels[key]; clusterName != "" {
+		return clusterName
+	}
+	return templateLabels[key]
+}
+
+func hasSelectorKey(selector metav1.LabelSelector, key string) bool {
+	if _, ok := selector.MatchLabels[key]; ok {
+		return true
+	}
+	for _, requirement := range selector.MatchExpressions {
+		if requirement.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureSelectedLabel adds a label to both the selector and the template labels
+// unless the selector already constrains the key.
+func ensureSelectedLabel(selector *metav1.LabelSelector, templateLabels *map[string]string, key, value string) {
+	if hasSelectorKey(*selector, key) {
+		return
+	}
+
+	if selector.MatchLabels == nil {
+		selector.MatchLabels = map[string]string{}
+	}
+	selector.MatchLabels[key] = value
+
+	if *templateLabels == nil {
+		*templateLabels = map[string]string{}
+	}
+	(*templateLabels)[key] = value
+}
@@ -0,0 +1,155 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseLabelTranslations(t *testing.T) {
+	g := NewWithT(t)
+
+	translations, err := ParseLabelTranslations([]byte(`
+- mapi: machine.openshift.io/cluster-api-cluster
+  capi: cluster.x-k8s.io/cluster-name
+- mapi: machine.openshift.io/cluster-api-machine-role
+  capi: node-role.kubernetes.io/role
+`))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(translations).To(HaveLen(2))
+	g.Expect(translations.toCAPI(mapi.MachineClusterIDLabel)).To(Equal(capi.ClusterLabelName))
+	g.Expect(translations.toMAPI("node-role.kubernetes.io/role")).To(Equal("machine.openshift.io/cluster-api-machine-role"))
+	g.Expect(translations.toCAPI("other")).To(Equal("other"))
+
+	_, err = ParseLabelTranslations([]byte(`- mapi: machine.openshift.io/cluster-api-cluster`))
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = ParseLabelTranslations([]byte(`
+- mapi: a
+  capi: b
+- mapi: c
+  capi: b
+`))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestTranslateLabelSelector(t *testing.T) {
+	g := NewWithT(t)
+
+	selector := metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			mapi.MachineClusterIDLabel: "cluster",
+			"other":                    "value",
+		},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      mapiMachineSetLabelName,
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{"worker-a", "worker-b"},
+			},
+		},
+	}
+
+	translated := translateLabelSelector(selector, DefaultLabelTranslations.toCAPI)
+	g.Expect(translated.MatchLabels).To(Equal(map[string]string{
+		capi.ClusterLabelName: "cluster",
+		"other":               "value",
+	}))
+	g.Expect(translated.MatchExpressions).To(Equal([]metav1.LabelSelectorRequirement{
+		{
+			Key:      capi.MachineSetLabelName,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   []string{"worker-a", "worker-b"},
+		},
+	}))
+
+	g.Expect(translateLabelSelector(translated, DefaultLabelTranslations.toMAPI)).To(Equal(selector))
+}
+
+func TestConvertMachineSetLabelsRoundTrip(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiLabels := map[string]string{
+		mapi.MachineClusterIDLabel:                      "cluster-x7k2p",
+		mapiMachineSetLabelName:                         "cluster-x7k2p-worker-us-east-1a",
+		"machine.openshift.io/cluster-api-machine-role": "worker",
+		"machine.openshift.io/cluster-api-machine-type": "worker",
+	}
+	mapiMachineSet := &mapi.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster-x7k2p-worker-us-east-1a",
+			Namespace: "openshift-machine-api",
+		},
+		Spec: mapi.MachineSetSpec{
+			Selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					mapi.MachineClusterIDLabel: "cluster-x7k2p",
+					mapiMachineSetLabelName:    "cluster-x7k2p-worker-us-east-1a",
+				},
+			},
+			Template: mapi.MachineTemplateSpec{
+				ObjectMeta: mapi.ObjectMeta{
+					Labels: mapiLabels,
+				},
+			},
+		},
+	}
+
+	capiMachineSet, err := convertMachineSetToCAPI(mapiMachineSet, DefaultLabelTranslations, MetadataPropagation{}, &ConversionReport{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachineSet.Spec.ClusterName).To(Equal("cluster-x7k2p"))
+	g.Expect(capiMachineSet.Spec.Template.Spec.ClusterName).To(Equal("cluster-x7k2p"))
+	g.Expect(capiMachineSet.Spec.Selector.MatchLabels).To(Equal(map[string]string{
+		capi.ClusterLabelName:    "cluster-x7k2p",
+		capi.MachineSetLabelName: "cluster-x7k2p-worker-us-east-1a",
+	}))
+	g.Expect(capiMachineSet.Spec.Template.Labels).To(Equal(map[string]string{
+		capi.ClusterLabelName:                           "cluster-x7k2p",
+		capi.MachineSetLabelName:                        "cluster-x7k2p-worker-us-east-1a",
+		"machine.openshift.io/cluster-api-machine-role": "worker",
+		"machine.openshift.io/cluster-api-machine-type": "worker",
+	}))
+
+	rawProviderConfig, err := mapi.RawExtensionFromProviderSpec(&mapi.AWSMachineProviderConfig{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	roundTripped, err := convertMachineSetToMAPI(capiMachineSet, rawProviderConfig, DefaultLabelTranslations, MetadataPropagation{}, &ConversionReport{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(roundTripped.Spec.Selector).To(Equal(mapiMachineSet.Spec.Selector))
+	g.Expect(roundTripped.Spec.Template.Labels).To(Equal(mapiLabels))
+}
+
+func TestConvertMachineSetToCAPIAddsClusterLabel(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiMachineSet := &mapi.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "worker",
+		},
+		Spec: mapi.MachineSetSpec{
+			Selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{mapiMachineSetLabelName: "worker"},
+			},
+			Template: mapi.MachineTemplateSpec{
+				ObjectMeta: mapi.ObjectMeta{
+					Labels: map[string]string{
+						mapi.MachineClusterIDLabel: "cluster",
+						mapiMachineSetLabelName:    "worker",
+					},
+				},
+			},
+		},
+	}
+
+	capiMachineSet, err := convertMachineSetToCAPI(mapiMachineSet, DefaultLabelTranslations, MetadataPropagation{}, &ConversionReport{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachineSet.Spec.ClusterName).To(Equal("cluster"))
+	g.Expect(capiMachineSet.Spec.Selector.MatchLabels).To(HaveKeyWithValue(capi.ClusterLabelName, "cluster"))
+
+	mapiMachineSet.Spec.Template.Labels = map[string]string{"other": "value"}
+	_, err = convertMachineSetToCAPI(mapiMachineSet, DefaultLabelTranslations, MetadataPropagation{}, &ConversionReport{})
+	g.Expect(err).To(HaveOccurred())
+}
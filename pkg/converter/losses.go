@@ -0,0 +1,48 @@
+package converter
+
+// FieldLoss is a field that doesn't survive a round trip through the other
+// API, i.e. converting it and converting the result back doesn't return it.
+type FieldLoss struct {
+	// Field is the path of the field in the MAPI providerSpec value or in the
+	// CAPA AWSMachineSpec. [*] matches every list item.
+	Field string `json:"field"`
+
+	// Reason explains why the field is lost.
+	Reason string `json:"reason"`
+
+	// ReplacedBy is the field the lost value comes back as, if any.
+	ReplacedBy string `json:"replacedBy,omitempty"`
+}
+
+// MAPIRoundTripLosses lists the providerSpec fields that are lost when
+// converting a MAPI machine set to CAPI and back. Every other field is
+// preserved.
+var MAPIRoundTripLosses = []FieldLoss{
+	{Field: "apiVersion", Reason: "the provider config is always written as machine.openshift.io/v1beta1"},
+	{Field: "metadata", Reason: "the provider config metadata isn't used by MAPI and has no CAPA equivalent"},
+	{Field: "iamInstanceProfile.arn", Reason: "CAPA takes the instance profile name, which comes back as iamInstanceProfile.id", ReplacedBy: "iamInstanceProfile.id"},
+	{Field: "credentialsSecret", Reason: "CAPA uses the credentials of the AWSCluster"},
+	{Field: "deviceIndex", Reason: "CAPA always attaches the primary interface at device index 0"},
+	{Field: "loadBalancers", Reason: "CAPA only registers control plane machines with the AWSCluster load balancers"},
+	{Field: "placement.region", Reason: "CAPA takes the region from the AWSCluster, it comes back derived from the availability zone"},
+	{Field: "blockDevices[*].noDevice", Reason: "CAPA volumes can't suppress a device of the AMI"},
+	{Field: "blockDevices[*].virtualName", Reason: "CAPA volumes can't be instance store volumes"},
+	{Field: "blockDevices[*].ebs.deleteOnTermination", Reason: "CAPA always deletes volumes with the instance"},
+	{Field: "blockDevices[*].ebs.kmsKey.filters", Reason: "CAPA takes a KMS key ID or ARN"},
+}
+
+// CAPIRoundTripLosses lists the AWSMachineSpec fields that are lost when
+// converting a CAPI machine set to MAPI and back. Every other field is
+// preserved.
+var CAPIRoundTripLosses = []FieldLoss{
+	{Field: "providerID", Reason: "the provider ID is set by CAPA on machines"},
+	{Field: "instanceID", Reason: "the instance ID is set by CAPA on machines"},
+	{Field: "imageLookupFormat", Reason: "MAPI has no image lookup, the AMI is resolved from an image catalog"},
+	{Field: "imageLookupOrg", Reason: "MAPI has no image lookup, the AMI is resolved from an image catalog"},
+	{Field: "imageLookupBaseOS", Reason: "MAPI has no image lookup, the AMI is resolved from an image catalog"},
+	{Field: "rootVolume.deviceName", Reason: "MAPI takes the root device name from the AMI"},
+	{Field: "networkInterfaces", Reason: "MAPI creates its own network interface"},
+	{Field: "uncompressedUserData", Reason: "MAPI has no bootstrap options, the Bootstrap options of the converter are applied instead"},
+	{Field: "cloudInit", Reason: "MAPI has no bootstrap options, the Bootstrap options of the converter are applied instead"},
+	{Field: "ignition", Reason: "MAPI has no bootstrap options, the Bootstrap options of the converter are applied instead"},
+}
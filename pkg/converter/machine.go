@@ -0,0 +1,198 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	machineKind           = "Machine"
+	capiMachineAPIVersion = "cluster.x-k8s.io/v1alpha4"
+	mapiMachineAPIVersion = "machine.openshift.io/v1beta1"
+	awsMachineKind        = "AWSMachine"
+)
+
+// splitObjectList returns the items of a List manifest, as printed by
+// `oc get machines -o yaml`, or the manifest itself if it isn't a list.
+func splitObjectList(data []byte) ([][]byte, error) {
+	list := &struct {
+		Items []json.RawMessage `json:"items"`
+	}{}
+	if err := yaml.Unmarshal(data, list); err != nil {
+		return nil, fmt.Errorf("error unmarshalling object list: %v", err)
+	}
+
+	if list.Items == nil {
+		return [][]byte{data}, nil
+	}
+
+	items := make([][]byte, 0, len(list.Items))
+	for _, item := range list.Items {
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// machineReportField scopes a report field to one of several converted machines.
+func machineReportField(name, field string) string {
+	return fmt.Sprintf("machines[%s].%s", name, field)
+}
+
+// addMachineReport adds the entries of the report of a machine to report,
+// under the name of the machine.
+func addMachineReport(report *ConversionReport, name string, machineReport *ConversionReport) {
+	for _, entry := range machineReport.Entries {
+		report.add(machineReportField(name, entry.Field), "%s", entry.Message)
+	}
+}
+
+// convertMachinesFileToCAPI converts the machines of the given MAPI machine set.
+func convertMachinesFileToCAPI(data []byte, machineSetName string, labelTranslations LabelTranslations, propagation MetadataPropagation, includeStatus bool, report *ConversionReport) ([]*capi.Machine, error) {
+	items, err := splitObjectList(data)
+	if err != nil {
+		return nil, err
+	}
+
+	capiMachines := []*capi.Machine{}
+	for _, item := range items {
+		mapiMachine := &mapi.Machine{}
+		if err := yaml.Unmarshal(item, mapiMachine); err != nil {
+			return nil, fmt.Errorf("error unmarshalling machine: %v", err)
+		}
+		if setName, ok := mapiMachine.Labels[mapiMachineSetLabelName]; ok && setName != machineSetName {
+			return nil, fmt.Errorf("machine %s belongs to machineset %s, not %s", mapiMachine.Name, setName, machineSetName)
+		}
+
+		capiMachine, err := convertMachineToCAPI(mapiMachine, labelTranslations, propagation, includeStatus, report)
+		if err != nil {
+			return nil, err
+		}
+		capiMachines = append(capiMachines, capiMachine)
+	}
+
+	return capiMachines, nil
+}
+
+func convertMachineToCAPI(mapiMachine *mapi.Machine, labelTranslations LabelTranslations, propagation MetadataPropagation, includeStatus bool, report *ConversionReport) (*capi.Machine, error) {
+	machineReport := &ConversionReport{}
+	defer addMachineReport(report, mapiMachine.Name, machineReport)
+
+	capiMachine := &capi.Machine{}
+	// The MAPI machine set controlling the machine is dropped with the other
+	// machine.openshift.io owners, the CAPI machine set adopts the machine.
+	capiMachine.ObjectMeta = propagateObjectMeta(mapiMachine.ObjectMeta, mapiMachineAPIVersion, propagation, labelTranslations.toCAPI, machineReport)
+	capiMachine.TypeMeta = metav1.TypeMeta{
+		Kind:       machineKind,
+		APIVersion: capiMachineAPIVersion,
+	}
+	annotations, err := translateAnnotations(capiMachine.Annotations, machineAnnotationTranslations, toCAPIAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	capiMachine.Annotations = annotations
+
+	capiMachine.Spec.ClusterName = mapiMachine.Labels[mapi.MachineClusterIDLabel]
+	if capiMachine.Spec.ClusterName == "" {
+		return nil, fmt.Errorf("machine %s has no %s label", mapiMachine.Name, mapi.MachineClusterIDLabel)
+	}
+	if capiMachine.Labels == nil {
+		capiMachine.Labels = map[string]string{}
+	}
+	capiMachine.Labels[capi.ClusterLabelName] = capiMachine.Spec.ClusterName
+
+	mapiProviderConfig, err := mapi.ProviderSpecFromRawExtension(mapiMachine.Spec.ProviderSpec.Value)
+	if err != nil {
+		return nil, err
+	}
+	capiMachine.Spec.Bootstrap = capi.Bootstrap{
+		DataSecretName: pointer.String(userDataSecretName(mapiProviderConfig)),
+	}
+	capiMachine.Spec.InfrastructureRef = corev1.ObjectReference{
+		APIVersion: awsTemplateAPIVersion,
+		Kind:       awsMachineKind,
+		Name:       mapiMachine.Name,
+		Namespace:  mapiMachine.Namespace,
+	}
+	capiMachine.Spec.ProviderID = mapiMachine.Spec.ProviderID
+	if mapiProviderConfig.Placement.AvailabilityZone != "" {
+		capiMachine.Spec.FailureDomain = pointer.String(mapiProviderConfig.Placement.AvailabilityZone)
+	}
+
+	if includeStatus {
+		capiMachine.Status = convertMachineStatusToCAPI(mapiMachine.Status, machineReport)
+	}
+
+	return capiMachine, nil
+}
+
+// convertMachinesFileToMAPI converts the machines of the given CAPI machine set.
+// They get the provider spec converted from the machine set's AWSMachineTemplate,
+// as their AWSMachines aren't part of the input.
+func convertMachinesFileToMAPI(data []byte, machineSetName string, rawProviderConfig *runtime.RawExtension, labelTranslations LabelTranslations, propagation MetadataPropagation, includeStatus bool, report *ConversionReport) ([]*mapi.Machine, error) {
+	items, err := splitObjectList(data)
+	if err != nil {
+		return nil, err
+	}
+
+	mapiMachines := []*mapi.Machine{}
+	for _, item := range items {
+		capiMachine := &capi.Machine{}
+		if err := yaml.Unmarshal(item, capiMachine); err != nil {
+			return nil, fmt.Errorf("error unmarshalling machine: %v", err)
+		}
+		if setName, ok := capiMachine.Labels[capi.MachineSetLabelName]; ok && setName != machineSetName {
+			return nil, fmt.Errorf("machine %s belongs to machineset %s, not %s", capiMachine.Name, setName, machineSetName)
+		}
+
+		mapiMachine, err := convertMachineToMAPI(capiMachine, rawProviderConfig, labelTranslations, propagation, includeStatus, report)
+		if err != nil {
+			return nil, err
+		}
+		mapiMachines = append(mapiMachines, mapiMachine)
+	}
+
+	return mapiMachines, nil
+}
+
+func convertMachineToMAPI(capiMachine *capi.Machine, rawProviderConfig *runtime.RawExtension, labelTranslations LabelTranslations, propagation MetadataPropagation, includeStatus bool, report *ConversionReport) (*mapi.Machine, error) {
+	machineReport := &ConversionReport{}
+	defer addMachineReport(report, capiMachine.Name, machineReport)
+
+	mapiMachine := &mapi.Machine{}
+	mapiMachine.ObjectMeta = propagateObjectMeta(capiMachine.ObjectMeta, capiMachineAPIVersion, propagation, labelTranslations.toMAPI, machineReport)
+	mapiMachine.TypeMeta = metav1.TypeMeta{
+		Kind:       machineKind,
+		APIVersion: mapiMachineAPIVersion,
+	}
+	annotations, err := translateAnnotations(mapiMachine.Annotations, machineAnnotationTranslations, toMAPIAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	mapiMachine.Annotations = annotations
+
+	if capiMachine.Spec.ClusterName != "" {
+		if mapiMachine.Labels == nil {
+			mapiMachine.Labels = map[string]string{}
+		}
+		mapiMachine.Labels[mapi.MachineClusterIDLabel] = capiMachine.Spec.ClusterName
+	}
+
+	mapiMachine.Spec.ProviderID = capiMachine.Spec.ProviderID
+	mapiMachine.Spec.ProviderSpec = mapi.ProviderSpec{
+		Value: rawProviderConfig,
+	}
+
+	if includeStatus {
+		mapiMachine.Status = convertMachineStatusToMAPI(capiMachine.Status, machineReport)
+	}
+
+	return mapiMachine, nil
+}
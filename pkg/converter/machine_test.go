@@ -0,0 +1,123 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+const testMachines = `apiVersion: v1
+kind: List
+items:
+- apiVersion: machine.openshift.io/v1beta1
+  kind: Machine
+  metadata:
+    name: worker-us-east-1a-x7k2p
+    namespace: openshift-machine-api
+    uid: 0b1e4a2c-6f0d-4f8b-a4a4-7f5e3c3e2a10
+    ownerReferences:
+    - apiVersion: machine.openshift.io/v1beta1
+      kind: MachineSet
+      name: worker-us-east-1a
+      uid: 9d4c2f0e-1b3a-4c5d-8e7f-0a1b2c3d4e5f
+      controller: true
+      blockOwnerDeletion: true
+    labels:
+      machine.openshift.io/cluster-api-cluster: cluster
+      machine.openshift.io/cluster-api-machineset: worker-us-east-1a
+    annotations:
+      machine.openshift.io/cluster-api-delete-machine: "true"
+  spec:
+    providerID: aws:///us-east-1a/i-0123456789abcdef0
+    providerSpec:
+      value:
+        placement:
+          availabilityZone: us-east-1a
+        userDataSecret:
+          name: worker-user-data-managed
+  status:
+    phase: Provisioned
+    addresses:
+    - type: InternalIP
+      address: 10.0.1.2
+`
+
+func TestToCAPIMachinesWithStatus(t *testing.T) {
+	g := NewWithT(t)
+
+	converter := &AWSConverter{
+		MachineSetFile:      []byte(testMachineSet),
+		MachinesFile:        []byte(testMachines),
+		IncludeStatus:       true,
+		MetadataPropagation: MetadataPropagation{PropagateOwnerReferences: true},
+	}
+
+	out, err := converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(3))
+
+	capiMachine := &capi.Machine{}
+	g.Expect(yaml.Unmarshal(out[2], capiMachine)).To(Succeed())
+	g.Expect(capiMachine.Kind).To(Equal(machineKind))
+	g.Expect(capiMachine.APIVersion).To(Equal(capiMachineAPIVersion))
+	g.Expect(capiMachine.UID).To(BeEmpty())
+	// The CAPI machine set adopts the machine, the MAPI one can't own it.
+	g.Expect(capiMachine.OwnerReferences).To(BeEmpty())
+	g.Expect(converter.Report().Entries).To(ContainElement(ReportEntry{
+		Field:   "machines[worker-us-east-1a-x7k2p].metadata.ownerReferences",
+		Message: "ownerReference to MachineSet worker-us-east-1a was dropped, machine.openshift.io objects can't own converted objects",
+	}))
+	g.Expect(capiMachine.Status.BootstrapReady).To(BeTrue())
+	g.Expect(capiMachine.Labels).To(Equal(map[string]string{
+		capi.ClusterLabelName:    "cluster",
+		capi.MachineSetLabelName: "worker-us-east-1a",
+	}))
+	g.Expect(capiMachine.Annotations).To(Equal(map[string]string{capi.DeleteMachineAnnotation: "true"}))
+	g.Expect(capiMachine.Spec.ClusterName).To(Equal("cluster"))
+	g.Expect(capiMachine.Spec.Bootstrap.DataSecretName).To(Equal(pointer.String("worker-user-data-managed")))
+	g.Expect(capiMachine.Spec.InfrastructureRef.Kind).To(Equal(awsMachineKind))
+	g.Expect(capiMachine.Spec.InfrastructureRef.Name).To(Equal("worker-us-east-1a-x7k2p"))
+	g.Expect(capiMachine.Spec.ProviderID).To(Equal(pointer.String("aws:///us-east-1a/i-0123456789abcdef0")))
+	g.Expect(capiMachine.Spec.FailureDomain).To(Equal(pointer.String("us-east-1a")))
+	g.Expect(capiMachine.Status.GetTypedPhase()).To(Equal(capi.MachinePhaseProvisioned))
+	g.Expect(capiMachine.Status.Addresses).To(HaveLen(1))
+
+	converter.IncludeStatus = false
+	out, err = converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	capiMachine = &capi.Machine{}
+	g.Expect(yaml.Unmarshal(out[2], capiMachine)).To(Succeed())
+	g.Expect(capiMachine.Status.Phase).To(BeEmpty())
+}
+
+func TestConvertMachinesFileToCAPIWrongMachineSet(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := convertMachinesFileToCAPI([]byte(testMachines), "worker-us-east-1b", DefaultLabelTranslations, MetadataPropagation{}, false, nil)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestConvertMachineToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	capiMachines, err := convertMachinesFileToCAPI([]byte(testMachines), "worker-us-east-1a", DefaultLabelTranslations, MetadataPropagation{}, true, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachines).To(HaveLen(1))
+
+	report := &ConversionReport{}
+	capiMachines[0].Status.Version = pointer.String("v1.21.1")
+	mapiMachine, err := convertMachineToMAPI(capiMachines[0], nil, DefaultLabelTranslations, MetadataPropagation{}, true, report)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(mapiMachine.Labels).To(Equal(map[string]string{
+		mapi.MachineClusterIDLabel: "cluster",
+		mapiMachineSetLabelName:    "worker-us-east-1a",
+	}))
+	g.Expect(mapiMachine.Annotations).To(Equal(map[string]string{mapiDeleteMachineAnnotation: "true"}))
+	g.Expect(mapiMachine.Status.Phase).To(Equal(pointer.String(mapi.PhaseProvisioned)))
+	g.Expect(report.Entries).To(HaveLen(1))
+	g.Expect(report.Entries[0].Field).To(Equal("machines[worker-us-east-1a-x7k2p].status.version"))
+}
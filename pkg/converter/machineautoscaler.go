@@ -0,0 +1,187 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// MachineAutoscalerOutput selects how a MachineAutoscaler is represented after
+// conversion to CAPI.
+type MachineAutoscalerOutput string
+
+const (
+	// MachineAutoscalerOutputAnnotations folds the MachineAutoscaler into min/max
+	// size annotations on the CAPI MachineSet. This is the default.
+	MachineAutoscalerOutputAnnotations MachineAutoscalerOutput = "annotations"
+
+	// MachineAutoscalerOutputResource emits the MachineAutoscaler retargeted at the
+	// CAPI MachineSet.
+	MachineAutoscalerOutputResource MachineAutoscalerOutput = "machineautoscaler"
+)
+
+// convertMachineAutoscalerToCAPI applies the MachineAutoscaler to the converted
+// CAPI MachineSet, returning the retargeted MachineAutoscaler if one should be emitted.
+func convertMachineAutoscalerToCAPI(data []byte, output MachineAutoscalerOutput, mapiMachineSet *mapi.MachineSet, capiMachineSet *capi.MachineSet) (*mapi.MachineAutoscaler, error) {
+	machineAutoscaler, err := parseMachineAutoscaler(data, mapiMachineSet.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch output {
+	case "", MachineAutoscalerOutputAnnotations:
+		for key, value := range machineAutoscalerAnnotations(machineAutoscaler, capiAutoscalerMinSizeAnnotation, capiAutoscalerMaxSizeAnnotation) {
+			metav1.SetMetaDataAnnotation(&capiMachineSet.ObjectMeta, key, value)
+		}
+		return nil, nil
+	case MachineAutoscalerOutputResource:
+		return retargetMachineAutoscaler(machineAutoscaler, capiMachineSetAPIVersion, capiMachineSet.Name), nil
+	default:
+		return nil, fmt.Errorf("unknown machine autoscaler output %q", output)
+	}
+}
+
+func parseMachineAutoscaler(data []byte, machineSetName string) (*mapi.MachineAutoscaler, error) {
+	machineAutoscaler := &mapi.MachineAutoscaler{}
+	if err := yaml.Unmarshal(data, machineAutoscaler); err != nil {
+		return nil, fmt.Errorf("error unmarshalling machine autoscaler: %v", err)
+	}
+
+	if machineAutoscaler.Kind != "" && machineAutoscaler.Kind != mapi.MachineAutoscalerKind {
+		return nil, fmt.Errorf("expected a %s, got %s", mapi.MachineAutoscalerKind, machineAutoscaler.Kind)
+	}
+	targetRef := machineAutoscaler.Spec.ScaleTargetRef
+	switch targetRef.Kind {
+	case "MachineSet":
+	case "MachineDeployment":
+		return nil, fmt.Errorf("machine autoscaler %s scales MachineDeployment %s, MAPI has no MachineDeployments so it can't target a converted MachineSet", machineAutoscaler.Name, targetRef.Name)
+	default:
+		return nil, fmt.Errorf("machine autoscaler %s scales a %s, only MachineSets are supported", machineAutoscaler.Name, targetRef.Kind)
+	}
+	if targetRef.Name != machineSetName {
+		return nil, fmt.Errorf("machine autoscaler %s targets machineset %s, not %s", machineAutoscaler.Name, targetRef.Name, machineSetName)
+	}
+	if err := validateMachineAutoscalerReplicas(machineAutoscaler); err != nil {
+		return nil, err
+	}
+
+	return machineAutoscaler, nil
+}
+
+// validateMachineAutoscalerReplicas mirrors the validation of the
+// cluster-autoscaler-operator, which requires 0 <= minReplicas <= maxReplicas
+// and at least one replica at most.
+func validateMachineAutoscalerReplicas(machineAutoscaler *mapi.MachineAutoscaler) error {
+	minReplicas, maxReplicas := machineAutoscaler.Spec.MinReplicas, machineAutoscaler.Spec.MaxReplicas
+	if minReplicas < 0 || maxReplicas < 1 || maxReplicas < minReplicas {
+		return fmt.Errorf("machine autoscaler %s has invalid replica bounds %d-%d, must be 0 <= min <= max and max >= 1", machineAutoscaler.Name, minReplicas, maxReplicas)
+	}
+	return nil
+}
+
+// retargetMachineAutoscaler returns a copy of the MachineAutoscaler scaling the
+// given MachineSet. Status is dropped, it belongs to the source cluster.
+func retargetMachineAutoscaler(machineAutoscaler *mapi.MachineAutoscaler, apiVersion, machineSetName string) *mapi.MachineAutoscaler {
+	return &mapi.MachineAutoscaler{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       mapi.MachineAutoscalerKind,
+			APIVersion: mapi.MachineAutoscalerAPIVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      machineAutoscaler.Name,
+			Namespace: machineAutoscaler.Namespace,
+		},
+		Spec: mapi.MachineAutoscalerSpec{
+			MinReplicas: machineAutoscaler.Spec.MinReplicas,
+			MaxReplicas: machineAutoscaler.Spec.MaxReplicas,
+			ScaleTargetRef: mapi.CrossVersionObjectReference{
+				APIVersion: apiVersion,
+				Kind:       "MachineSet",
+				Name:       machineSetName,
+			},
+		},
+	}
+}
+
+// machineAutoscalerAnnotations returns the annotations the cluster-autoscaler-operator
+// would set on a MachineSet owned by the MachineAutoscaler.
+func machineAutoscalerAnnotations(machineAutoscaler *mapi.MachineAutoscaler, minSizeAnnotation, maxSizeAnnotation string) map[string]string {
+	return map[string]string{
+		minSizeAnnotation:                strconv.Itoa(int(machineAutoscaler.Spec.MinReplicas)),
+		maxSizeAnnotation:                strconv.Itoa(int(machineAutoscaler.Spec.MaxReplicas)),
+		machineAutoscalerOwnerAnnotation: machineAutoscaler.Namespace + "/" + machineAutoscaler.Name,
+	}
+}
+
+// machineAutoscalerFromAnnotations reconstructs the MachineAutoscaler owning a
+// MAPI MachineSet. It returns nil when the MachineSet isn't owned by one.
+func machineAutoscalerFromAnnotations(machineSet *mapi.MachineSet) (*mapi.MachineAutoscaler, error) {
+	owner, ok := machineSet.Annotations[machineAutoscalerOwnerAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	namespace, name := machineSet.Namespace, owner
+	if i := strings.Index(owner, "/"); i >= 0 {
+		namespace, name = owner[:i], owner[i+1:]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("invalid %s annotation %q", machineAutoscalerOwnerAnnotation, owner)
+	}
+
+	minReplicas, err := replicasFromAnnotation(machineSet.Annotations, mapiAutoscalerMinSizeAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	maxReplicas, err := replicasFromAnnotation(machineSet.Annotations, mapiAutoscalerMaxSizeAnnotation)
+	if err != nil {
+		return nil, err
+	}
+
+	machineAutoscaler := &mapi.MachineAutoscaler{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       mapi.MachineAutoscalerKind,
+			APIVersion: mapi.MachineAutoscalerAPIVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: mapi.MachineAutoscalerSpec{
+			MinReplicas: minReplicas,
+			MaxReplicas: maxReplicas,
+			ScaleTargetRef: mapi.CrossVersionObjectReference{
+				APIVersion: mapiMachineSetAPIVersion,
+				Kind:       mapiMachineSetKind,
+				Name:       machineSet.Name,
+			},
+		},
+	}
+	if err := validateMachineAutoscalerReplicas(machineAutoscaler); err != nil {
+		return nil, err
+	}
+
+	return machineAutoscaler, nil
+}
+
+func replicasFromAnnotation(annotations map[string]string, key string) (int32, error) {
+	value, ok := annotations[key]
+	if !ok {
+		return 0, fmt.Errorf("machineset is owned by a machine autoscaler but has no %s annotation", key)
+	}
+
+	replicas, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation: %v", key, err)
+	}
+	if replicas < 0 {
+		return 0, fmt.Errorf("invalid %s annotation: replicas can't be negative", key)
+	}
+
+	return int32(replicas), nil
+}
@@ -0,0 +1,178 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const testMachineSet = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: worker-us-east-1a
+  namespace: openshift-machine-api
+spec:
+  template:
+    spec:
+      providerSpec:
+        value:
+          ami:
+            id: ami-x86-east
+          placement:
+            availabilityZone: us-east-1a
+            region: us-east-1
+`
+
+const testMachineAutoscaler = `apiVersion: autoscaling.openshift.io/v1beta1
+kind: MachineAutoscaler
+metadata:
+  name: worker-us-east-1a
+  namespace: openshift-machine-api
+spec:
+  minReplicas: 1
+  maxReplicas: 12
+  scaleTargetRef:
+    apiVersion: machine.openshift.io/v1beta1
+    kind: MachineSet
+    name: worker-us-east-1a
+status:
+  lastTargetRef:
+    apiVersion: machine.openshift.io/v1beta1
+    kind: MachineSet
+    name: worker-us-east-1a
+`
+
+func TestToCAPIMachineAutoscalerAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	converter := &AWSConverter{
+		MachineSetFile:        []byte(testMachineSet),
+		MachineAutoscalerFile: []byte(testMachineAutoscaler),
+	}
+
+	out, err := converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(2))
+
+	capiMachineSet := &capi.MachineSet{}
+	g.Expect(yaml.Unmarshal(out[1], capiMachineSet)).To(Succeed())
+	g.Expect(capiMachineSet.Annotations).To(Equal(map[string]string{
+		capiAutoscalerMinSizeAnnotation:  "1",
+		capiAutoscalerMaxSizeAnnotation:  "12",
+		machineAutoscalerOwnerAnnotation: "openshift-machine-api/worker-us-east-1a",
+	}))
+}
+
+func TestToCAPIMachineAutoscalerResource(t *testing.T) {
+	g := NewWithT(t)
+
+	converter := &AWSConverter{
+		MachineSetFile:          []byte(testMachineSet),
+		MachineAutoscalerFile:   []byte(testMachineAutoscaler),
+		MachineAutoscalerOutput: MachineAutoscalerOutputResource,
+	}
+
+	out, err := converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(3))
+
+	machineAutoscaler := &mapi.MachineAutoscaler{}
+	g.Expect(yaml.Unmarshal(out[2], machineAutoscaler)).To(Succeed())
+	g.Expect(machineAutoscaler.Spec.MinReplicas).To(BeEquivalentTo(1))
+	g.Expect(machineAutoscaler.Spec.MaxReplicas).To(BeEquivalentTo(12))
+	g.Expect(machineAutoscaler.Spec.ScaleTargetRef).To(Equal(mapi.CrossVersionObjectReference{
+		APIVersion: capiMachineSetAPIVersion,
+		Kind:       capiMachineSetKind,
+		Name:       "worker-us-east-1a",
+	}))
+	g.Expect(machineAutoscaler.Status.LastTargetRef).To(BeNil())
+
+	converter.MachineAutoscalerOutput = "hpa"
+	_, err = converter.ToCAPI()
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestParseMachineAutoscaler(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := parseMachineAutoscaler([]byte(testMachineAutoscaler), "worker-us-east-1a")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = parseMachineAutoscaler([]byte(testMachineAutoscaler), "worker-us-east-1b")
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = parseMachineAutoscaler([]byte(`kind: MachineAutoscaler
+metadata:
+  name: worker
+spec:
+  minReplicas: 3
+  maxReplicas: 1
+  scaleTargetRef:
+    kind: MachineSet
+    name: worker
+`), "worker")
+	g.Expect(err).To(MatchError("machine autoscaler worker has invalid replica bounds 3-1, must be 0 <= min <= max and max >= 1"))
+
+	_, err = parseMachineAutoscaler([]byte(`kind: MachineAutoscaler
+metadata:
+  name: worker
+spec:
+  minReplicas: 0
+  maxReplicas: 0
+  scaleTargetRef:
+    kind: MachineSet
+    name: worker
+`), "worker")
+	g.Expect(err).To(MatchError("machine autoscaler worker has invalid replica bounds 0-0, must be 0 <= min <= max and max >= 1"))
+
+	_, err = parseMachineAutoscaler([]byte(`kind: MachineAutoscaler
+metadata:
+  name: worker
+spec:
+  maxReplicas: 3
+  scaleTargetRef:
+    kind: MachineDeployment
+    name: worker
+`), "worker")
+	g.Expect(err).To(MatchError("machine autoscaler worker scales MachineDeployment worker, MAPI has no MachineDeployments so it can't target a converted MachineSet"))
+}
+
+func TestMachineAutoscalerFromAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	machineSet := &mapi.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "worker-us-east-1a",
+			Namespace: "openshift-machine-api",
+		},
+	}
+
+	machineAutoscaler, err := machineAutoscalerFromAnnotations(machineSet)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(machineAutoscaler).To(BeNil())
+
+	machineSet.Annotations = map[string]string{
+		mapiAutoscalerMinSizeAnnotation:  "0",
+		mapiAutoscalerMaxSizeAnnotation:  "4",
+		machineAutoscalerOwnerAnnotation: "openshift-machine-api/autoscale-workers",
+	}
+	machineAutoscaler, err = machineAutoscalerFromAnnotations(machineSet)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(machineAutoscaler.Name).To(Equal("autoscale-workers"))
+	g.Expect(machineAutoscaler.Namespace).To(Equal("openshift-machine-api"))
+	g.Expect(machineAutoscaler.Spec.MinReplicas).To(BeEquivalentTo(0))
+	g.Expect(machineAutoscaler.Spec.MaxReplicas).To(BeEquivalentTo(4))
+	g.Expect(machineAutoscaler.Spec.ScaleTargetRef).To(Equal(mapi.CrossVersionObjectReference{
+		APIVersion: mapiMachineSetAPIVersion,
+		Kind:       mapiMachineSetKind,
+		Name:       "worker-us-east-1a",
+	}))
+
+	delete(machineSet.Annotations, mapiAutoscalerMaxSizeAnnotation)
+	_, err = machineAutoscalerFromAnnotations(machineSet)
+	g.Expect(err).To(HaveOccurred())
+}
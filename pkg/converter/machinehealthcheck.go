@@ -0,0 +1,139 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	machineHealthCheckKind           = "MachineHealthCheck"
+	capiMachineHealthCheckAPIVersion = "cluster.x-k8s.io/v1alpha4"
+	mapiMachineHealthCheckAPIVersion = "machine.openshift.io/v1beta1"
+)
+
+// convertMachineHealthCheckFileToCAPI converts a MAPI MachineHealthCheck manifest.
+// clusterName is used when the health check selector doesn't name the cluster.
+func convertMachineHealthCheckFileToCAPI(data []byte, clusterName string, labelTranslations LabelTranslations) (*capi.MachineHealthCheck, error) {
+	mapiMachineHealthCheck := &mapi.MachineHealthCheck{}
+	if err := yaml.Unmarshal(data, mapiMachineHealthCheck); err != nil {
+		return nil, fmt.Errorf("error unmarshalling machine health check: %v", err)
+	}
+
+	return convertMachineHealthCheckToCAPI(mapiMachineHealthCheck, clusterName, labelTranslations)
+}
+
+func convertMachineHealthCheckToCAPI(mapiMachineHealthCheck *mapi.MachineHealthCheck, clusterName string, labelTranslations LabelTranslations) (*capi.MachineHealthCheck, error) {
+	capiMachineHealthCheck := &capi.MachineHealthCheck{}
+	capiMachineHealthCheck.ObjectMeta = metav1.ObjectMeta{
+		Name:      mapiMachineHealthCheck.Name,
+		Namespace: mapiMachineHealthCheck.Namespace,
+	}
+	capiMachineHealthCheck.TypeMeta = metav1.TypeMeta{
+		Kind:       machineHealthCheckKind,
+		APIVersion: capiMachineHealthCheckAPIVersion,
+	}
+
+	capiMachineHealthCheck.Spec.Selector = translateLabelSelector(mapiMachineHealthCheck.Spec.Selector, labelTranslations.toCAPI)
+	if name := clusterNameFromLabels(capiMachineHealthCheck.Spec.Selector, nil, capi.ClusterLabelName); name != "" {
+		clusterName = name
+	}
+	if clusterName == "" {
+		return nil, errors.New("can't determine the cluster name of the machine health check, its selector has no cluster label")
+	}
+	capiMachineHealthCheck.Spec.ClusterName = clusterName
+
+	// An empty MAPI selector matches every machine, CAPI rejects empty selectors
+	// so match every machine of the cluster instead.
+	if len(capiMachineHealthCheck.Spec.Selector.MatchLabels)+len(capiMachineHealthCheck.Spec.Selector.MatchExpressions) == 0 {
+		capiMachineHealthCheck.Spec.Selector.MatchLabels = map[string]string{capi.ClusterLabelName: clusterName}
+	}
+
+	capiMachineHealthCheck.Spec.UnhealthyConditions = convertUnhealthyConditionsToCAPI(mapiMachineHealthCheck.Spec.UnhealthyConditions)
+	capiMachineHealthCheck.Spec.MaxUnhealthy = mapiMachineHealthCheck.Spec.MaxUnhealthy
+	capiMachineHealthCheck.Spec.NodeStartupTimeout = mapiMachineHealthCheck.Spec.NodeStartupTimeout
+	capiMachineHealthCheck.Spec.RemediationTemplate = copyObjectReference(mapiMachineHealthCheck.Spec.RemediationTemplate)
+
+	return capiMachineHealthCheck, nil
+}
+
+func convertUnhealthyConditionsToCAPI(mapiConditions []mapi.UnhealthyCondition) []capi.UnhealthyCondition {
+	if mapiConditions == nil {
+		return nil
+	}
+
+	capiConditions := []capi.UnhealthyCondition{}
+	for _, condition := range mapiConditions {
+		capiConditions = append(capiConditions, capi.UnhealthyCondition{
+			Type:    condition.Type,
+			Status:  condition.Status,
+			Timeout: condition.Timeout,
+		})
+	}
+
+	return capiConditions
+}
+
+// convertMachineHealthCheckFileToMAPI converts a CAPI MachineHealthCheck manifest.
+func convertMachineHealthCheckFileToMAPI(data []byte, labelTranslations LabelTranslations, report *ConversionReport) (*mapi.MachineHealthCheck, error) {
+	capiMachineHealthCheck := &capi.MachineHealthCheck{}
+	if err := yaml.Unmarshal(data, capiMachineHealthCheck); err != nil {
+		return nil, fmt.Errorf("error unmarshalling machine health check: %v", err)
+	}
+
+	return convertMachineHealthCheckToMAPI(capiMachineHealthCheck, labelTranslations, report), nil
+}
+
+func convertMachineHealthCheckToMAPI(capiMachineHealthCheck *capi.MachineHealthCheck, labelTranslations LabelTranslations, report *ConversionReport) *mapi.MachineHealthCheck {
+	mapiMachineHealthCheck := &mapi.MachineHealthCheck{}
+	mapiMachineHealthCheck.ObjectMeta = metav1.ObjectMeta{
+		Name:      capiMachineHealthCheck.Name,
+		Namespace: capiMachineHealthCheck.Namespace,
+	}
+	mapiMachineHealthCheck.TypeMeta = metav1.TypeMeta{
+		Kind:       machineHealthCheckKind,
+		APIVersion: mapiMachineHealthCheckAPIVersion,
+	}
+
+	mapiMachineHealthCheck.Spec.Selector = translateLabelSelector(capiMachineHealthCheck.Spec.Selector, labelTranslations.toMAPI)
+	// CAPI health checks only ever match machines of their cluster, keep that
+	// scope explicit in the selector.
+	if capiMachineHealthCheck.Spec.ClusterName != "" && !hasSelectorKey(mapiMachineHealthCheck.Spec.Selector, mapi.MachineClusterIDLabel) {
+		if mapiMachineHealthCheck.Spec.Selector.MatchLabels == nil {
+			mapiMachineHealthCheck.Spec.Selector.MatchLabels = map[string]string{}
+		}
+		mapiMachineHealthCheck.Spec.Selector.MatchLabels[mapi.MachineClusterIDLabel] = capiMachineHealthCheck.Spec.ClusterName
+	}
+
+	mapiMachineHealthCheck.Spec.UnhealthyConditions = convertUnhealthyConditionsToMAPI(capiMachineHealthCheck.Spec.UnhealthyConditions)
+	mapiMachineHealthCheck.Spec.MaxUnhealthy = capiMachineHealthCheck.Spec.MaxUnhealthy
+	mapiMachineHealthCheck.Spec.NodeStartupTimeout = capiMachineHealthCheck.Spec.NodeStartupTimeout
+	mapiMachineHealthCheck.Spec.RemediationTemplate = copyObjectReference(capiMachineHealthCheck.Spec.RemediationTemplate)
+
+	if capiMachineHealthCheck.Spec.UnhealthyRange != nil {
+		report.add("spec.unhealthyRange", "MAPI has no unhealthy range, %s was dropped", *capiMachineHealthCheck.Spec.UnhealthyRange)
+	}
+
+	return mapiMachineHealthCheck
+}
+
+func convertUnhealthyConditionsToMAPI(capiConditions []capi.UnhealthyCondition) []mapi.UnhealthyCondition {
+	if capiConditions == nil {
+		return nil
+	}
+
+	mapiConditions := []mapi.UnhealthyCondition{}
+	for _, condition := range capiConditions {
+		mapiConditions = append(mapiConditions, mapi.UnhealthyCondition{
+			Type:    condition.Type,
+			Status:  condition.Status,
+			Timeout: condition.Timeout,
+		})
+	}
+
+	return mapiConditions
+}
@@ -0,0 +1,147 @@
+package converter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+const testMachineHealthCheck = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineHealthCheck
+metadata:
+  name: worker-health
+  namespace: openshift-machine-api
+spec:
+  selector:
+    matchLabels:
+      machine.openshift.io/cluster-api-machineset: worker-us-east-1a
+  unhealthyConditions:
+  - type: Ready
+    status: "False"
+    timeout: 300s
+  - type: Ready
+    status: Unknown
+    timeout: 300s
+  maxUnhealthy: 40%
+  nodeStartupTimeout: 10m
+`
+
+func TestConvertMachineHealthCheckFileToCAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	capiMachineHealthCheck, err := convertMachineHealthCheckFileToCAPI([]byte(testMachineHealthCheck), "cluster", DefaultLabelTranslations)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachineHealthCheck.Kind).To(Equal(machineHealthCheckKind))
+	g.Expect(capiMachineHealthCheck.APIVersion).To(Equal(capiMachineHealthCheckAPIVersion))
+	g.Expect(capiMachineHealthCheck.Name).To(Equal("worker-health"))
+	g.Expect(capiMachineHealthCheck.Spec.ClusterName).To(Equal("cluster"))
+	g.Expect(capiMachineHealthCheck.Spec.Selector.MatchLabels).To(Equal(map[string]string{
+		capi.MachineSetLabelName: "worker-us-east-1a",
+	}))
+	g.Expect(capiMachineHealthCheck.Spec.UnhealthyConditions).To(Equal([]capi.UnhealthyCondition{
+		{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Timeout: metav1.Duration{Duration: 5 * time.Minute}},
+		{Type: corev1.NodeReady, Status: corev1.ConditionUnknown, Timeout: metav1.Duration{Duration: 5 * time.Minute}},
+	}))
+	g.Expect(capiMachineHealthCheck.Spec.MaxUnhealthy).To(Equal(&intstr.IntOrString{Type: intstr.String, StrVal: "40%"}))
+	g.Expect(capiMachineHealthCheck.Spec.NodeStartupTimeout).To(Equal(&metav1.Duration{Duration: 10 * time.Minute}))
+
+	_, err = convertMachineHealthCheckFileToCAPI([]byte(testMachineHealthCheck), "", DefaultLabelTranslations)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestConvertMachineHealthCheckToCAPISelector(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiMachineHealthCheck := &mapi.MachineHealthCheck{}
+	capiMachineHealthCheck, err := convertMachineHealthCheckToCAPI(mapiMachineHealthCheck, "cluster", DefaultLabelTranslations)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachineHealthCheck.Spec.Selector.MatchLabels).To(Equal(map[string]string{capi.ClusterLabelName: "cluster"}))
+
+	mapiMachineHealthCheck.Spec.Selector.MatchLabels = map[string]string{mapi.MachineClusterIDLabel: "other"}
+	capiMachineHealthCheck, err = convertMachineHealthCheckToCAPI(mapiMachineHealthCheck, "cluster", DefaultLabelTranslations)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachineHealthCheck.Spec.ClusterName).To(Equal("other"))
+}
+
+func TestConvertMachineHealthCheckToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	capiMachineHealthCheck := &capi.MachineHealthCheck{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "worker-health",
+			Namespace: "default",
+		},
+		Spec: capi.MachineHealthCheckSpec{
+			ClusterName: "cluster",
+			Selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{capi.MachineSetLabelName: "worker-us-east-1a"},
+			},
+			UnhealthyConditions: []capi.UnhealthyCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Timeout: metav1.Duration{Duration: time.Minute}},
+			},
+			UnhealthyRange: pointer.String("[1-3]"),
+			RemediationTemplate: &corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha5",
+				Kind:       "Metal3RemediationTemplate",
+				Name:       "worker-remediation",
+			},
+		},
+	}
+
+	report := &ConversionReport{}
+	mapiMachineHealthCheck := convertMachineHealthCheckToMAPI(capiMachineHealthCheck, DefaultLabelTranslations, report)
+	g.Expect(mapiMachineHealthCheck.Kind).To(Equal(machineHealthCheckKind))
+	g.Expect(mapiMachineHealthCheck.APIVersion).To(Equal(mapiMachineHealthCheckAPIVersion))
+	g.Expect(mapiMachineHealthCheck.Spec.Selector.MatchLabels).To(Equal(map[string]string{
+		mapiMachineSetLabelName:    "worker-us-east-1a",
+		mapi.MachineClusterIDLabel: "cluster",
+	}))
+	g.Expect(mapiMachineHealthCheck.Spec.UnhealthyConditions).To(HaveLen(1))
+	g.Expect(mapiMachineHealthCheck.Spec.RemediationTemplate).To(Equal(capiMachineHealthCheck.Spec.RemediationTemplate))
+	g.Expect(mapiMachineHealthCheck.Spec.RemediationTemplate).NotTo(BeIdenticalTo(capiMachineHealthCheck.Spec.RemediationTemplate))
+	g.Expect(report.Entries).To(HaveLen(1))
+	g.Expect(report.Entries[0].Field).To(Equal("spec.unhealthyRange"))
+}
+
+func TestToCAPIMachineHealthCheck(t *testing.T) {
+	g := NewWithT(t)
+
+	converter := &AWSConverter{
+		MachineSetFile: []byte(`apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: worker-us-east-1a
+  namespace: openshift-machine-api
+spec:
+  selector:
+    matchLabels:
+      machine.openshift.io/cluster-api-cluster: cluster
+  template:
+    metadata:
+      labels:
+        machine.openshift.io/cluster-api-cluster: cluster
+    spec:
+      providerSpec:
+        value:
+          placement:
+            availabilityZone: us-east-1a
+`),
+		MachineHealthCheckFile: []byte(testMachineHealthCheck),
+	}
+
+	out, err := converter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out).To(HaveLen(3))
+
+	capiMachineHealthCheck := &capi.MachineHealthCheck{}
+	g.Expect(yaml.Unmarshal(out[2], capiMachineHealthCheck)).To(Succeed())
+	g.Expect(capiMachineHealthCheck.Spec.ClusterName).To(Equal("cluster"))
+}
@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetadataPropagation selects the labels and annotations copied from a source
+// machine set onto the converted one. Keys are matched by prefix, so
+// "argocd.argoproj.io/" covers every Argo CD key.
+//
+// Template labels are always copied, machine set selectors depend on them.
+type MetadataPropagation struct {
+	// AllowPrefixes limits propagation to matching keys. When empty every key is allowed.
+	AllowPrefixes []string
+
+	// DenyPrefixes drops matching keys. It takes precedence over AllowPrefixes.
+	DenyPrefixes []string
+
+	// PropagateOwnerReferences copies the ownerReferences whose owner can own
+	// the converted object, i.e. isn't an object of the source machine API.
+	// References keep their uid, so only set it when the converted objects are
+	// applied next to their owners. Otherwise ownerReferences are dropped.
+	PropagateOwnerReferences bool
+}
+
+// deniedMetadataPrefixes describe the source object itself and are never propagated.
+var deniedMetadataPrefixes = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+func (propagation MetadataPropagation) allows(key string) bool {
+	if hasAnyPrefix(key, deniedMetadataPrefixes) || hasAnyPrefix(key, propagation.DenyPrefixes) {
+		return false
+	}
+
+	return len(propagation.AllowPrefixes) == 0 || hasAnyPrefix(key, propagation.AllowPrefixes)
+}
+
+func (propagation MetadataPropagation) filter(metadata map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for key, value := range metadata {
+		if propagation.allows(key) {
+			filtered[key] = value
+		}
+	}
+
+	if len(filtered) == 0 {
+		return nil
+	}
+	return filtered
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// propagateObjectMeta copies the user managed metadata of a machine set. The
+// server managed fields, uid, resourceVersion, generation, managedFields and
+// creationTimestamp, are left out, and so are finalizers which belong to the
+// source controllers. sourceAPIVersion is the API version of the source object,
+// its owners from the same machine API can't own the converted object.
+func propagateObjectMeta(source metav1.ObjectMeta, sourceAPIVersion string, propagation MetadataPropagation, translateLabel func(string) string, report *ConversionReport) metav1.ObjectMeta {
+	objectMeta := metav1.ObjectMeta{
+		Name:         source.Name,
+		GenerateName: source.GenerateName,
+		Namespace:    source.Namespace,
+		Labels:       translateLabels(propagation.filter(source.Labels), translateLabel),
+		Annotations:  propagation.filter(source.Annotations),
+	}
+
+	sourceGroup := apiGroup(sourceAPIVersion)
+	for _, ownerReference := range source.OwnerReferences {
+		switch group := apiGroup(ownerReference.APIVersion); {
+		case !propagation.PropagateOwnerReferences:
+			report.add("metadata.ownerReferences", "ownerReference to %s %s was dropped, ownerReferences aren't propagated unless asked for", ownerReference.Kind, ownerReference.Name)
+		case group == sourceGroup || strings.HasSuffix(group, "."+sourceGroup):
+			report.add("metadata.ownerReferences", "ownerReference to %s %s was dropped, %s objects can't own converted objects", ownerReference.Kind, ownerReference.Name, group)
+		default:
+			objectMeta.OwnerReferences = append(objectMeta.OwnerReferences, ownerReference)
+		}
+	}
+
+	return objectMeta
+}
+
+// apiGroup returns the group of an apiVersion, empty for the core group.
+func apiGroup(apiVersion string) string {
+	if i := strings.Index(apiVersion, "/"); i >= 0 {
+		return apiVersion[:i]
+	}
+	return ""
+}
+
+// convertMachineTemplateMetaToCAPI copies the machine template metadata. CAPI
+// templates only carry labels and annotations.
+func convertMachineTemplateMetaToCAPI(mapiMeta mapi.ObjectMeta, propagation MetadataPropagation, labelTranslations LabelTranslations, report *ConversionReport) (capi.ObjectMeta, error) {
+	if mapiMeta.GenerateName != "" {
+		report.add("spec.template.metadata.generateName", "CAPI machine templates have no generateName, %s was dropped", mapiMeta.GenerateName)
+	}
+	if mapiMeta.Namespace != "" {
+		report.add("spec.template.metadata.namespace", "CAPI machine templates have no namespace, %s was dropped", mapiMeta.Namespace)
+	}
+	if len(mapiMeta.OwnerReferences) > 0 {
+		report.add("spec.template.metadata.ownerReferences", "CAPI machine templates have no ownerReferences, %d references were dropped", len(mapiMeta.OwnerReferences))
+	}
+
+	annotations, err := translateAnnotations(propagation.filter(mapiMeta.Annotations), machineAnnotationTranslations, toCAPIAnnotation)
+	if err != nil {
+		return capi.ObjectMeta{}, err
+	}
+
+	return capi.ObjectMeta{
+		Labels:      translateLabels(mapiMeta.Labels, labelTranslations.toCAPI),
+		Annotations: annotations,
+	}, nil
+}
+
+func convertMachineTemplateMetaToMAPI(capiMeta capi.ObjectMeta, propagation MetadataPropagation, labelTranslations LabelTranslations) (mapi.ObjectMeta, error) {
+	annotations, err := translateAnnotations(propagation.filter(capiMeta.Annotations), machineAnnotationTranslations, toMAPIAnnotation)
+	if err != nil {
+		return mapi.ObjectMeta{}, err
+	}
+
+	return mapi.ObjectMeta{
+		Labels:      translateLabels(capiMeta.Labels, labelTranslations.toMAPI),
+		Annotations: annotations,
+	}, nil
+}
@@ -0,0 +1,138 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+func TestMetadataPropagationAllows(t *testing.T) {
+	g := NewWithT(t)
+
+	propagation := MetadataPropagation{}
+	g.Expect(propagation.allows("argocd.argoproj.io/instance")).To(BeTrue())
+	g.Expect(propagation.allows("kubectl.kubernetes.io/last-applied-configuration")).To(BeFalse())
+
+	propagation = MetadataPropagation{
+		AllowPrefixes: []string{"cost-center", "argocd.argoproj.io/"},
+		DenyPrefixes:  []string{"argocd.argoproj.io/sync-"},
+	}
+	g.Expect(propagation.allows("cost-center")).To(BeTrue())
+	g.Expect(propagation.allows("argocd.argoproj.io/instance")).To(BeTrue())
+	g.Expect(propagation.allows("argocd.argoproj.io/sync-wave")).To(BeFalse())
+	g.Expect(propagation.allows("team")).To(BeFalse())
+}
+
+func TestPropagateObjectMeta(t *testing.T) {
+	g := NewWithT(t)
+
+	source := metav1.ObjectMeta{
+		Name:            "worker",
+		Namespace:       "openshift-machine-api",
+		UID:             types.UID("6b0a3a52-0f3e-4d0c-8f4b-3b4c1b0b9e39"),
+		ResourceVersion: "12345",
+		Generation:      3,
+		Finalizers:      []string{"foregroundDeletion"},
+		ManagedFields:   []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+		Labels: map[string]string{
+			mapi.MachineClusterIDLabel: "cluster",
+			"cost-center":              "1234",
+		},
+		Annotations: map[string]string{
+			"argocd.argoproj.io/sync-wave":                     "1",
+			"kubectl.kubernetes.io/last-applied-configuration": "{}",
+		},
+		OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: types.UID("owner-uid"), Controller: pointer.Bool(true)},
+			{APIVersion: "cluster.x-k8s.io/v1alpha4", Kind: "MachineDeployment", Name: "worker", UID: types.UID("deployment-uid")},
+		},
+	}
+	source.CreationTimestamp = metav1.Now()
+
+	report := &ConversionReport{}
+	objectMeta := propagateObjectMeta(source, mapiMachineSetAPIVersion, MetadataPropagation{}, DefaultLabelTranslations.toCAPI, report)
+	g.Expect(objectMeta).To(Equal(metav1.ObjectMeta{
+		Name:      "worker",
+		Namespace: "openshift-machine-api",
+		Labels: map[string]string{
+			capi.ClusterLabelName: "cluster",
+			"cost-center":         "1234",
+		},
+		Annotations: map[string]string{
+			"argocd.argoproj.io/sync-wave": "1",
+		},
+	}))
+	g.Expect(report.Entries).To(HaveLen(2))
+
+	report = &ConversionReport{}
+	objectMeta = propagateObjectMeta(source, mapiMachineSetAPIVersion, MetadataPropagation{DenyPrefixes: []string{"argocd.argoproj.io/"}, PropagateOwnerReferences: true}, DefaultLabelTranslations.toCAPI, report)
+	g.Expect(objectMeta.Annotations).To(BeNil())
+	g.Expect(objectMeta.OwnerReferences).To(Equal(source.OwnerReferences))
+	g.Expect(report.Entries).To(BeEmpty())
+
+	// A MAPI machine set can't be owned by a CAPI MachineDeployment.
+	objectMeta = propagateObjectMeta(source, capiMachineSetAPIVersion, MetadataPropagation{PropagateOwnerReferences: true}, DefaultLabelTranslations.toMAPI, report)
+	g.Expect(objectMeta.OwnerReferences).To(Equal(source.OwnerReferences[:1]))
+	g.Expect(report.Entries).To(Equal([]ReportEntry{{
+		Field:   "metadata.ownerReferences",
+		Message: "ownerReference to MachineDeployment worker was dropped, cluster.x-k8s.io objects can't own converted objects",
+	}}))
+}
+
+func TestAPIGroup(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(apiGroup("v1")).To(Equal(""))
+	g.Expect(apiGroup("machine.openshift.io/v1beta1")).To(Equal("machine.openshift.io"))
+}
+
+func TestConvertMachineSetMetadataRoundTrip(t *testing.T) {
+	g := NewWithT(t)
+
+	mapiMachineSet := &mapi.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "worker",
+			Namespace: "openshift-machine-api",
+			Labels:    map[string]string{"cost-center": "1234"},
+			Annotations: map[string]string{
+				mapiAutoscalerMinSizeAnnotation: "1",
+				"team":                          "infra",
+			},
+		},
+		Spec: mapi.MachineSetSpec{
+			Selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{mapiMachineSetLabelName: "worker"},
+			},
+			Template: mapi.MachineTemplateSpec{
+				ObjectMeta: mapi.ObjectMeta{
+					GenerateName: "worker-",
+					Labels:       map[string]string{mapiMachineSetLabelName: "worker"},
+					Annotations:  map[string]string{"team": "infra"},
+				},
+			},
+		},
+	}
+
+	report := &ConversionReport{}
+	capiMachineSet, err := convertMachineSetToCAPI(mapiMachineSet, DefaultLabelTranslations, MetadataPropagation{}, report)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(capiMachineSet.Labels).To(Equal(map[string]string{"cost-center": "1234"}))
+	g.Expect(capiMachineSet.Annotations).To(Equal(map[string]string{
+		capiAutoscalerMinSizeAnnotation: "1",
+		"team":                          "infra",
+	}))
+	g.Expect(capiMachineSet.Spec.Template.Annotations).To(Equal(map[string]string{"team": "infra"}))
+	g.Expect(report.Entries).To(HaveLen(1))
+	g.Expect(report.Entries[0].Field).To(Equal("spec.template.metadata.generateName"))
+
+	roundTripped, err := convertMachineSetToMAPI(capiMachineSet, nil, DefaultLabelTranslations, MetadataPropagation{}, &ConversionReport{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(roundTripped.Labels).To(Equal(mapiMachineSet.Labels))
+	g.Expect(roundTripped.Annotations).To(Equal(mapiMachineSet.Annotations))
+	g.Expect(roundTripped.Spec.Template.Annotations).To(Equal(mapiMachineSet.Spec.Template.Annotations))
+}
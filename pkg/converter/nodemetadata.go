@@ -0,0 +1,145 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeMetadataStrategy selects what happens to the node labels, annotations and
+// taints of a MAPI machine spec. CAPI v1alpha4 machines can't set any of them.
+//
+// Moving them into the bootstrap config isn't supported: converted machines
+// boot from the pre-rendered user data secret of the source machines, so the
+// converter never renders the kubelet --node-labels or --register-with-taints
+// flags. Set them through the bootstrap provider instead.
+type NodeMetadataStrategy string
+
+const (
+	// NodeMetadataReport drops node metadata and records it in the conversion report.
+	// This is the default.
+	NodeMetadataReport NodeMetadataStrategy = "report"
+
+	// NodeMetadataAnnotations encodes node metadata as JSON annotations on the
+	// machine template, for a node-labeller to apply once the node joins. The
+	// annotations are decoded back into the machine spec when converting to MAPI.
+	NodeMetadataAnnotations NodeMetadataStrategy = "annotations"
+
+	// NodeMetadataError fails the conversion when the machine spec has node metadata,
+	// so pools relying on it for scheduling aren't converted silently.
+	NodeMetadataError NodeMetadataStrategy = "error"
+
+	// nodeMetadataBootstrap is rejected with an explanation rather than as an
+	// unknown strategy, see NodeMetadataStrategy.
+	nodeMetadataBootstrap NodeMetadataStrategy = "bootstrap"
+
+	nodeLabelsAnnotation      = "mapi-capi-converter.openshift.io/node-labels"
+	nodeAnnotationsAnnotation = "mapi-capi-converter.openshift.io/node-annotations"
+	nodeTaintsAnnotation      = "mapi-capi-converter.openshift.io/node-taints"
+
+	mapiNodeMetadataPath = "spec.template.spec.metadata"
+	mapiNodeTaintsPath   = "spec.template.spec.taints"
+)
+
+// convertNodeMetadataToCAPI applies the strategy to the node metadata of the MAPI
+// machine spec, annotating the CAPI machine template if requested.
+func convertNodeMetadataToCAPI(machineSpec mapi.MachineSpec, strategy NodeMetadataStrategy, capiMachineSet *capi.MachineSet, report *ConversionReport) error {
+	nodeMeta := machineSpec.ObjectMeta
+	if nodeMeta.GenerateName != "" || nodeMeta.Name != "" || nodeMeta.Namespace != "" || len(nodeMeta.OwnerReferences) > 0 {
+		report.add(mapiNodeMetadataPath, "only node labels and annotations can be converted, the other node metadata was dropped")
+	}
+
+	if strategy == nodeMetadataBootstrap {
+		return fmt.Errorf("node metadata strategy %s is not supported, converted machines boot from the existing user data secret which the converter doesn't render, set kubelet node labels and taints through the bootstrap provider or use the %s strategy", nodeMetadataBootstrap, NodeMetadataAnnotations)
+	}
+
+	if len(nodeMeta.Labels) == 0 && len(nodeMeta.Annotations) == 0 && len(machineSpec.Taints) == 0 {
+		return nil
+	}
+
+	switch strategy {
+	case "", NodeMetadataReport:
+		if len(nodeMeta.Labels) > 0 {
+			report.add(mapiNodeMetadataPath+".labels", "CAPI machines can't set node labels, %v were dropped", nodeMeta.Labels)
+		}
+		if len(nodeMeta.Annotations) > 0 {
+			report.add(mapiNodeMetadataPath+".annotations", "CAPI machines can't set node annotations, %v were dropped", nodeMeta.Annotations)
+		}
+		for _, taint := range machineSpec.Taints {
+			report.add(mapiNodeTaintsPath, "CAPI machines can't set node taints, %s was dropped", taint.ToString())
+		}
+		return nil
+	case NodeMetadataAnnotations:
+		templateMeta := &capiMachineSet.Spec.Template.ObjectMeta
+		if len(nodeMeta.Labels) > 0 {
+			if err := setJSONAnnotation(templateMeta, nodeLabelsAnnotation, nodeMeta.Labels); err != nil {
+				return err
+			}
+		}
+		if len(nodeMeta.Annotations) > 0 {
+			if err := setJSONAnnotation(templateMeta, nodeAnnotationsAnnotation, nodeMeta.Annotations); err != nil {
+				return err
+			}
+		}
+		if len(machineSpec.Taints) > 0 {
+			if err := setJSONAnnotation(templateMeta, nodeTaintsAnnotation, machineSpec.Taints); err != nil {
+				return err
+			}
+		}
+		return nil
+	case NodeMetadataError:
+		return fmt.Errorf("machine set %s sets node labels, annotations or taints which CAPI machines can't set", capiMachineSet.Name)
+	default:
+		return fmt.Errorf("unknown node metadata strategy %q", strategy)
+	}
+}
+
+func setJSONAnnotation(objectMeta *capi.ObjectMeta, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error marshalling %s annotation: %v", key, err)
+	}
+
+	if objectMeta.Annotations == nil {
+		objectMeta.Annotations = map[string]string{}
+	}
+	objectMeta.Annotations[key] = string(encoded)
+	return nil
+}
+
+// convertNodeMetadataToMAPI decodes node metadata annotations of the CAPI machine
+// template back into the MAPI machine spec.
+func convertNodeMetadataToMAPI(capiMachineSet *capi.MachineSet, mapiMachineSet *mapi.MachineSet) error {
+	templateAnnotations := capiMachineSet.Spec.Template.Annotations
+	machineSpec := &mapiMachineSet.Spec.Template.Spec
+
+	if value, ok := templateAnnotations[nodeLabelsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &machineSpec.Labels); err != nil {
+			return fmt.Errorf("invalid %s annotation: %v", nodeLabelsAnnotation, err)
+		}
+	}
+	if value, ok := templateAnnotations[nodeAnnotationsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &machineSpec.Annotations); err != nil {
+			return fmt.Errorf("invalid %s annotation: %v", nodeAnnotationsAnnotation, err)
+		}
+	}
+	if value, ok := templateAnnotations[nodeTaintsAnnotation]; ok {
+		taints := []corev1.Taint{}
+		if err := json.Unmarshal([]byte(value), &taints); err != nil {
+			return fmt.Errorf("invalid %s annotation: %v", nodeTaintsAnnotation, err)
+		}
+		machineSpec.Taints = taints
+	}
+
+	for _, key := range []string{nodeLabelsAnnotation, nodeAnnotationsAnnotation, nodeTaintsAnnotation} {
+		delete(mapiMachineSet.Spec.Template.Annotations, key)
+	}
+	if len(mapiMachineSet.Spec.Template.Annotations) == 0 {
+		mapiMachineSet.Spec.Template.Annotations = nil
+	}
+
+	return nil
+}
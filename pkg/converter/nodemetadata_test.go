@@ -0,0 +1,68 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func testInfraMachineSpec() mapi.MachineSpec {
+	return mapi.MachineSpec{
+		ObjectMeta: mapi.ObjectMeta{
+			Labels: map[string]string{"node-role.kubernetes.io/infra": ""},
+		},
+		Taints: []corev1.Taint{
+			{Key: "node-role.kubernetes.io/infra", Effect: corev1.TaintEffectNoSchedule},
+		},
+	}
+}
+
+func TestConvertNodeMetadataToCAPIReport(t *testing.T) {
+	g := NewWithT(t)
+
+	report := &ConversionReport{}
+	capiMachineSet := &capi.MachineSet{}
+	g.Expect(convertNodeMetadataToCAPI(testInfraMachineSpec(), "", capiMachineSet, report)).To(Succeed())
+	g.Expect(capiMachineSet.Spec.Template.Annotations).To(BeNil())
+	g.Expect(report.Entries).To(HaveLen(2))
+	g.Expect(report.Entries[0].Field).To(Equal(mapiNodeMetadataPath + ".labels"))
+	g.Expect(report.Entries[1].Field).To(Equal(mapiNodeTaintsPath))
+	g.Expect(report.Entries[1].Message).To(ContainSubstring("node-role.kubernetes.io/infra:NoSchedule"))
+}
+
+func TestConvertNodeMetadataToCAPIError(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(convertNodeMetadataToCAPI(testInfraMachineSpec(), NodeMetadataError, &capi.MachineSet{}, nil)).NotTo(Succeed())
+	g.Expect(convertNodeMetadataToCAPI(mapi.MachineSpec{}, NodeMetadataError, &capi.MachineSet{}, nil)).To(Succeed())
+	g.Expect(convertNodeMetadataToCAPI(testInfraMachineSpec(), "unknown", &capi.MachineSet{}, nil)).To(MatchError(`unknown node metadata strategy "unknown"`))
+	g.Expect(convertNodeMetadataToCAPI(mapi.MachineSpec{}, "bootstrap", &capi.MachineSet{}, nil)).To(MatchError(ContainSubstring("node metadata strategy bootstrap is not supported")))
+}
+
+func TestConvertNodeMetadataAnnotationsRoundTrip(t *testing.T) {
+	g := NewWithT(t)
+
+	capiMachineSet := &capi.MachineSet{}
+	capiMachineSet.Spec.Template.Annotations = map[string]string{"team": "infra"}
+	g.Expect(convertNodeMetadataToCAPI(testInfraMachineSpec(), NodeMetadataAnnotations, capiMachineSet, nil)).To(Succeed())
+	g.Expect(capiMachineSet.Spec.Template.Annotations).To(Equal(map[string]string{
+		"team":               "infra",
+		nodeLabelsAnnotation: `{"node-role.kubernetes.io/infra":""}`,
+		nodeTaintsAnnotation: `[{"key":"node-role.kubernetes.io/infra","effect":"NoSchedule"}]`,
+	}))
+
+	mapiMachineSet := &mapi.MachineSet{}
+	var err error
+	mapiMachineSet.Spec.Template.ObjectMeta, err = convertMachineTemplateMetaToMAPI(capiMachineSet.Spec.Template.ObjectMeta, MetadataPropagation{}, DefaultLabelTranslations)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(convertNodeMetadataToMAPI(capiMachineSet, mapiMachineSet)).To(Succeed())
+	g.Expect(mapiMachineSet.Spec.Template.Spec.ObjectMeta).To(Equal(testInfraMachineSpec().ObjectMeta))
+	g.Expect(mapiMachineSet.Spec.Template.Spec.Taints).To(Equal(testInfraMachineSpec().Taints))
+	g.Expect(mapiMachineSet.Spec.Template.Annotations).To(Equal(map[string]string{"team": "infra"}))
+
+	capiMachineSet.Spec.Template.Annotations[nodeTaintsAnnotation] = "NoSchedule"
+	g.Expect(convertNodeMetadataToMAPI(capiMachineSet, mapiMachineSet)).NotTo(Succeed())
+}
@@ -0,0 +1,44 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConversionReport collects fields that could not be carried over faithfully
+// between the two APIs. Entries don't fail the conversion, they are meant to be
+// reviewed by whoever applies the converted manifests.
+type ConversionReport struct {
+	Entries []ReportEntry `json:"entries,omitempty"`
+}
+
+// ReportEntry describes a single field that was dropped or altered during conversion.
+type ReportEntry struct {
+	// Field is the path of the field in the source object.
+	Field string `json:"field"`
+
+	// Message explains what happened to the field.
+	Message string `json:"message"`
+}
+
+func (r *ConversionReport) add(field, format string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	r.Entries = append(r.Entries, ReportEntry{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *ConversionReport) String() string {
+	if r == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, entry := range r.Entries {
+		fmt.Fprintf(&sb, "%s: %s\n", entry.Field, entry.Message)
+	}
+	return sb.String()
+}
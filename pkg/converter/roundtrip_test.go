@@ -0,0 +1,671 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+const roundTripSeeds = 200
+
+const testCAPIMachineSet = `apiVersion: cluster.x-k8s.io/v1alpha4
+kind: MachineSet
+metadata:
+  name: worker-a
+  namespace: openshift-machine-api
+spec:
+  clusterName: cluster
+  selector:
+    matchLabels:
+      cluster.x-k8s.io/cluster-name: cluster
+      cluster.x-k8s.io/set-name: worker-a
+  template:
+    metadata:
+      labels:
+        cluster.x-k8s.io/cluster-name: cluster
+        cluster.x-k8s.io/set-name: worker-a
+    spec:
+      bootstrap:
+        dataSecretName: worker-user-data
+      clusterName: cluster
+      infrastructureRef:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+        kind: AWSMachineTemplate
+        name: worker-a
+`
+
+var testAvailabilityZones = []string{"", "us-east-1a", "eu-west-1b", "us-gov-west-1c", "us-east-1-nyc-1a"}
+
+var testTenancies = []string{"", "default", "dedicated", "host"}
+
+// TestMAPIRoundTrip converts random provider configs to CAPI and back and
+// checks that every field is preserved or a declared loss, and that converting
+// the result again doesn't change it.
+func TestMAPIRoundTrip(t *testing.T) {
+	for seed := int64(0); seed < roundTripSeeds; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		providerConfig := randomProviderConfig(r)
+
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineSet, err := mapiMachineSetWithProviderSpec(providerConfig)
+			g.Expect(err).NotTo(HaveOccurred())
+			capiObjects, mapiMachineSet, err := mapiRoundTrip(machineSet)
+			if rootVolumes(providerConfig) > 1 {
+				g.Expect(err).To(MatchError(ContainSubstring("only the root volume can omit it")))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			roundTripped, err := providerSpecOf(mapiMachineSet)
+			g.Expect(err).NotTo(HaveOccurred())
+			differences, err := roundTripDifferences(withConvertibleBlockDevices(providerConfig), roundTripped, MAPIRoundTripLosses)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(differences).To(BeEmpty())
+
+			expectIdempotentMAPIRoundTrip(g, capiObjects, mapiMachineSet)
+		})
+	}
+}
+
+// TestCAPIRoundTrip converts random AWSMachineSpecs to MAPI and back and checks
+// that every field is preserved or a declared loss, and that converting the
+// result again doesn't change it.
+func TestCAPIRoundTrip(t *testing.T) {
+	for seed := int64(0); seed < roundTripSeeds; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		spec := randomAWSMachineSpec(r)
+
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineTemplate, err := awsMachineTemplateWithSpec(spec)
+			g.Expect(err).NotTo(HaveOccurred())
+			mapiMachineSet, capiObjects, err := capiRoundTrip(machineTemplate, []byte(testCAPIMachineSet))
+			g.Expect(err).NotTo(HaveOccurred())
+
+			roundTripped, err := awsMachineSpecOf(capiObjects[0])
+			g.Expect(err).NotTo(HaveOccurred())
+			differences, err := roundTripDifferences(spec, roundTripped, CAPIRoundTripLosses)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(differences).To(BeEmpty())
+
+			expectIdempotentCAPIRoundTrip(g, mapiMachineSet, capiObjects)
+		})
+	}
+}
+
+// FuzzMAPIRoundTrip converts arbitrary provider specs. Conversions must not
+// panic, and the output of a successful round trip must convert to itself.
+func FuzzMAPIRoundTrip(f *testing.F) {
+	goldenMachineSets, err := filepath.Glob(filepath.Join(goldenDir, "*", "machineset.yaml"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range goldenMachineSets {
+		machineSet, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		providerSpec, err := providerSpecOf(machineSet)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(providerSpec.Raw)
+	}
+	for seed := int64(0); seed < 10; seed++ {
+		providerSpec, err := json.Marshal(randomProviderConfig(rand.New(rand.NewSource(seed))))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(providerSpec)
+	}
+
+	f.Fuzz(func(t *testing.T, providerSpec []byte) {
+		g := NewWithT(t)
+
+		machineSet, err := mapiMachineSetWithProviderSpec(json.RawMessage(providerSpec))
+		if err != nil {
+			return
+		}
+		capiObjects, mapiMachineSet, err := mapiRoundTrip(machineSet)
+		if err != nil {
+			return
+		}
+
+		expectIdempotentMAPIRoundTrip(g, capiObjects, mapiMachineSet)
+	})
+}
+
+// FuzzCAPIRoundTrip converts arbitrary AWSMachineSpecs. Conversions must not
+// panic, and the output of a successful round trip must convert to itself.
+func FuzzCAPIRoundTrip(f *testing.F) {
+	for seed := int64(0); seed < 10; seed++ {
+		spec, err := json.Marshal(randomAWSMachineSpec(rand.New(rand.NewSource(seed))))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(spec)
+	}
+
+	f.Fuzz(func(t *testing.T, spec []byte) {
+		g := NewWithT(t)
+
+		machineTemplate, err := awsMachineTemplateWithSpec(json.RawMessage(spec))
+		if err != nil {
+			return
+		}
+		mapiMachineSet, capiObjects, err := capiRoundTrip(machineTemplate, []byte(testCAPIMachineSet))
+		if err != nil {
+			return
+		}
+
+		expectIdempotentCAPIRoundTrip(g, mapiMachineSet, capiObjects)
+	})
+}
+
+func expectIdempotentMAPIRoundTrip(g *WithT, capiObjects [][]byte, mapiMachineSet []byte) {
+	capiObjectsAgain, mapiMachineSetAgain, err := mapiRoundTrip(mapiMachineSet)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(bytesJoin(capiObjectsAgain))).To(Equal(string(bytesJoin(capiObjects))))
+	g.Expect(string(mapiMachineSetAgain)).To(Equal(string(mapiMachineSet)))
+}
+
+func expectIdempotentCAPIRoundTrip(g *WithT, mapiMachineSet []byte, capiObjects [][]byte) {
+	mapiMachineSetAgain, capiObjectsAgain, err := capiRoundTrip(capiObjects[0], capiObjects[1])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(mapiMachineSetAgain)).To(Equal(string(mapiMachineSet)))
+	g.Expect(string(bytesJoin(capiObjectsAgain))).To(Equal(string(bytesJoin(capiObjects))))
+}
+
+// mapiRoundTrip converts a MAPI machine set to CAPI and back.
+func mapiRoundTrip(machineSet []byte) ([][]byte, []byte, error) {
+	capiObjects, err := (&AWSConverter{MachineSetFile: machineSet}).ToCAPI()
+	if err != nil {
+		return nil, nil, err
+	}
+	mapiObjects, err := (&AWSConverter{MachineTemplateFile: capiObjects[0], MachineSetFile: capiObjects[1]}).ToMAPI()
+	if err != nil {
+		return nil, nil, err
+	}
+	return capiObjects, mapiObjects[0], nil
+}
+
+// capiRoundTrip converts a CAPI machine set and its template to MAPI and back.
+func capiRoundTrip(machineTemplate, machineSet []byte) ([]byte, [][]byte, error) {
+	mapiObjects, err := (&AWSConverter{MachineTemplateFile: machineTemplate, MachineSetFile: machineSet}).ToMAPI()
+	if err != nil {
+		return nil, nil, err
+	}
+	capiObjects, err := (&AWSConverter{MachineSetFile: mapiObjects[0]}).ToCAPI()
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapiObjects[0], capiObjects, nil
+}
+
+func mapiMachineSetWithProviderSpec(providerSpec interface{}) ([]byte, error) {
+	raw, err := json.Marshal(providerSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{mapi.MachineClusterIDLabel: "cluster"}
+	machineSet := &mapi.MachineSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: mapiMachineSetAPIVersion,
+			Kind:       mapiMachineSetKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "worker-a",
+			Namespace: "openshift-machine-api",
+		},
+	}
+	machineSet.Spec.Selector.MatchLabels = labels
+	machineSet.Spec.Template.Labels = labels
+	machineSet.Spec.Template.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: raw}
+	return yaml.Marshal(machineSet)
+}
+
+func awsMachineTemplateWithSpec(spec interface{}) ([]byte, error) {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	machineTemplate := &capi.AWSMachineTemplate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: awsTemplateAPIVersion,
+			Kind:       awsTemplateKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "worker-a",
+			Namespace: "openshift-machine-api",
+		},
+	}
+	if err := json.Unmarshal(raw, &machineTemplate.Spec.Template.Spec); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(machineTemplate)
+}
+
+func providerSpecOf(machineSet []byte) (*runtime.RawExtension, error) {
+	mapiMachineSet := &mapi.MachineSet{}
+	if err := yaml.Unmarshal(machineSet, mapiMachineSet); err != nil {
+		return nil, err
+	}
+	return mapiMachineSet.Spec.Template.Spec.ProviderSpec.Value, nil
+}
+
+func awsMachineSpecOf(machineTemplate []byte) (capi.AWSMachineSpec, error) {
+	awsMachineTemplate := &capi.AWSMachineTemplate{}
+	if err := yaml.Unmarshal(machineTemplate, awsMachineTemplate); err != nil {
+		return capi.AWSMachineSpec{}, err
+	}
+	return awsMachineTemplate.Spec.Template.Spec, nil
+}
+
+// roundTripDifferences returns the paths of the fields the round trip changed
+// that aren't declared losses. Omitted, zero and empty fields compare equal,
+// like they do with omitempty.
+func roundTripDifferences(original, roundTripped interface{}, losses []FieldLoss) ([]string, error) {
+	originalFields, err := flattenFields(original)
+	if err != nil {
+		return nil, err
+	}
+	roundTrippedFields, err := flattenFields(roundTripped)
+	if err != nil {
+		return nil, err
+	}
+
+	lossPatterns := make([]*regexp.Regexp, 0, len(losses))
+	// Lost fields that come back as another field, the other field is added.
+	replacementPatterns := []*regexp.Regexp{}
+	for _, loss := range losses {
+		lossPattern := fieldPattern(loss.Field)
+		lossPatterns = append(lossPatterns, lossPattern)
+		if loss.ReplacedBy == "" {
+			continue
+		}
+		for path := range originalFields {
+			if lossPattern.MatchString(path) {
+				replacementPatterns = append(replacementPatterns, fieldPattern(loss.ReplacedBy))
+				break
+			}
+		}
+	}
+	matchesAny := func(patterns []*regexp.Regexp, path string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	differences := []string{}
+	for path, value := range originalFields {
+		if !reflect.DeepEqual(roundTrippedFields[path], value) && !matchesAny(lossPatterns, path) {
+			differences = append(differences, fmt.Sprintf("%s: %v -> %v", path, value, roundTrippedFields[path]))
+		}
+	}
+	for path, value := range roundTrippedFields {
+		if _, ok := originalFields[path]; !ok && !matchesAny(lossPatterns, path) && !matchesAny(replacementPatterns, path) {
+			differences = append(differences, fmt.Sprintf("%s: added %v", path, value))
+		}
+	}
+	sort.Strings(differences)
+	return differences, nil
+}
+
+// fieldPattern matches the path of a field, of its fields and of its items.
+// [*] matches every list item.
+func fieldPattern(field string) *regexp.Regexp {
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(field), `\[\*\]`, `\[[0-9]+\]`)
+	return regexp.MustCompile(`^` + pattern + `($|\.|\[)`)
+}
+
+// flattenFields returns the non-empty leaf fields of an object by path, e.g.
+// blockDevices[0].ebs.volumeSize.
+func flattenFields(object interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	var flatten func(path string, value interface{})
+	flatten = func(path string, value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			for key, item := range value {
+				if path != "" {
+					key = path + "." + key
+				}
+				flatten(key, item)
+			}
+		case []interface{}:
+			for i, item := range value {
+				flatten(fmt.Sprintf("%s[%d]", path, i), item)
+			}
+		default:
+			if value != nil && value != "" && value != false && value != float64(0) {
+				fields[path] = value
+			}
+		}
+	}
+	flatten("", value)
+	return fields, nil
+}
+
+func bytesJoin(objects [][]byte) []byte {
+	out := []byte{}
+	for _, object := range objects {
+		out = append(out, object...)
+	}
+	return out
+}
+
+// randomProviderConfig populates every provider config field at random. The
+// AMI is always set, MAPI can't resolve CAPA image lookups, availability zones
+// and tenancies are valid, tags are unique and sorted, CAPA keeps them in a
+// map, and the user data secret is always set, MAPI machines can't bootstrap
+// without one. Block devices come in any order, and more than one of them can
+// omit the device name.
+func randomProviderConfig(r *rand.Rand) *mapi.AWSMachineProviderConfig {
+	providerConfig := &mapi.AWSMachineProviderConfig{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: pick(r, awsProviderConfigAPIVersion, "awsproviderconfig.openshift.io/v1beta1"),
+			Kind:       awsProviderConfigKind,
+		},
+		AMI:          randomMAPIResourceReference(r, "ami"),
+		InstanceType: randomString(r, "m5."),
+	}
+
+	for _, name := range randomNames(r, "tag-") {
+		providerConfig.Tags = append(providerConfig.Tags, mapi.TagSpecification{Name: name, Value: randomString(r, "value-")})
+	}
+	switch r.Intn(3) {
+	case 0:
+		providerConfig.IAMInstanceProfile = &mapi.AWSResourceReference{ID: pointer.String(randomString(r, "profile-"))}
+	case 1:
+		providerConfig.IAMInstanceProfile = &mapi.AWSResourceReference{ARN: pointer.String(randomString(r, "arn:aws:iam::123456789012:instance-profile/profile-"))}
+	}
+	providerConfig.UserDataSecret = &corev1.LocalObjectReference{Name: randomString(r, "user-data-")}
+	if r.Intn(2) == 0 {
+		providerConfig.CredentialsSecret = &corev1.LocalObjectReference{Name: randomString(r, "credentials-")}
+	}
+	providerConfig.KeyName = randomStringOrNil(r, "key-")
+	providerConfig.DeviceIndex = int64(r.Intn(2))
+	providerConfig.PublicIP = randomBoolOrNil(r)
+	for i := r.Intn(3); i > 0; i-- {
+		providerConfig.SecurityGroups = append(providerConfig.SecurityGroups, randomMAPIResourceReference(r, "sg"))
+	}
+	if r.Intn(2) == 0 {
+		providerConfig.Subnet = randomMAPIResourceReference(r, "subnet")
+	}
+
+	zone := pick(r, testAvailabilityZones...)
+	providerConfig.Placement = mapi.Placement{
+		AvailabilityZone: zone,
+		Region:           pick(r, regionFromAvailabilityZone(zone), "ap-south-1"),
+		Tenancy:          mapi.InstanceTenancy(pick(r, testTenancies...)),
+	}
+	for i := r.Intn(2); i > 0; i-- {
+		providerConfig.LoadBalancers = append(providerConfig.LoadBalancers, mapi.LoadBalancerReference{
+			Name: randomString(r, "lb-"),
+			Type: mapi.NetworkLoadBalancerType,
+		})
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		providerConfig.BlockDevices = append(providerConfig.BlockDevices, randomBlockDevice(r, nil))
+	}
+	for _, name := range randomNames(r, "/dev/xvd") {
+		providerConfig.BlockDevices = append(providerConfig.BlockDevices, randomBlockDevice(r, pointer.String(name)))
+	}
+	r.Shuffle(len(providerConfig.BlockDevices), func(i, j int) {
+		providerConfig.BlockDevices[i], providerConfig.BlockDevices[j] = providerConfig.BlockDevices[j], providerConfig.BlockDevices[i]
+	})
+
+	switch r.Intn(3) {
+	case 0:
+		providerConfig.SpotMarketOptions = &mapi.SpotMarketOptions{}
+	case 1:
+		providerConfig.SpotMarketOptions = &mapi.SpotMarketOptions{MaxPrice: pointer.String(fmt.Sprintf("0.%d", r.Intn(100)))}
+	}
+
+	return providerConfig
+}
+
+// rootVolumes returns the number of block devices without a device name.
+func rootVolumes(providerConfig *mapi.AWSMachineProviderConfig) int {
+	count := 0
+	for _, blockDevice := range providerConfig.BlockDevices {
+		if blockDevice.DeviceName == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// withConvertibleBlockDevices returns a copy of the provider config with the
+// root volume moved in front of the other block devices, where MAPI writes it,
+// and without the named block devices that have no EBS volume size, which CAPA
+// can't create.
+func withConvertibleBlockDevices(providerConfig *mapi.AWSMachineProviderConfig) *mapi.AWSMachineProviderConfig {
+	sorted := *providerConfig
+	sorted.BlockDevices = nil
+	for _, blockDevice := range providerConfig.BlockDevices {
+		if blockDevice.DeviceName == nil {
+			sorted.BlockDevices = append([]mapi.BlockDeviceMappingSpec{blockDevice}, sorted.BlockDevices...)
+		} else if blockDevice.EBS != nil && blockDevice.EBS.VolumeSize != nil && *blockDevice.EBS.VolumeSize != 0 {
+			sorted.BlockDevices = append(sorted.BlockDevices, blockDevice)
+		}
+	}
+	return &sorted
+}
+
+func randomBlockDevice(r *rand.Rand, deviceName *string) mapi.BlockDeviceMappingSpec {
+	blockDevice := mapi.BlockDeviceMappingSpec{
+		DeviceName:  deviceName,
+		NoDevice:    randomStringOrNil(r, ""),
+		VirtualName: randomStringOrNil(r, "ephemeral"),
+	}
+	if r.Intn(4) == 0 {
+		return blockDevice
+	}
+
+	blockDevice.EBS = &mapi.EBSBlockDeviceSpec{
+		DeleteOnTermination: randomBoolOrNil(r),
+		Encrypted:           randomBoolOrNil(r),
+		Iops:                randomInt64OrNil(r),
+		VolumeSize:          randomInt64OrNil(r),
+		VolumeType:          randomStringOrNil(r, "gp"),
+	}
+	switch r.Intn(4) {
+	case 0:
+		blockDevice.EBS.KMSKey.ID = pointer.String(randomString(r, "key-"))
+	case 1:
+		blockDevice.EBS.KMSKey.ARN = pointer.String(randomString(r, "arn:aws:kms:us-east-1:123456789012:key/"))
+	case 2:
+		blockDevice.EBS.KMSKey.Filters = randomMAPIFilters(r)
+	}
+	return blockDevice
+}
+
+func randomMAPIResourceReference(r *rand.Rand, prefix string) mapi.AWSResourceReference {
+	reference := mapi.AWSResourceReference{}
+	for reference.ID == nil && reference.ARN == nil && reference.Filters == nil {
+		reference.ID = randomStringOrNil(r, prefix+"-")
+		reference.ARN = randomStringOrNil(r, "arn:aws:ec2:us-east-1:123456789012:"+prefix+"/")
+		reference.Filters = randomMAPIFilters(r)
+	}
+	return reference
+}
+
+func randomMAPIFilters(r *rand.Rand) []mapi.Filter {
+	var filters []mapi.Filter
+	for _, name := range randomNames(r, "tag:") {
+		filters = append(filters, mapi.Filter{Name: name, Values: randomNames(r, "value-")})
+	}
+	return filters
+}
+
+// randomAWSMachineSpec populates every AWSMachineSpec field at random. The AMI
+// is always set, MAPI can't resolve CAPA image lookups, availability zones and
+// tenancies are valid, volumes have the minimum size of 8 GiB CAPA requires
+// and non-root volumes have a device name, MAPI takes volumes without one for
+// the root volume.
+func randomAWSMachineSpec(r *rand.Rand) capi.AWSMachineSpec {
+	spec := capi.AWSMachineSpec{
+		ProviderID:           randomStringOrNil(r, "aws:///us-east-1a/i-"),
+		InstanceID:           randomStringOrNil(r, "i-"),
+		AMI:                  randomCAPIResourceReference(r, "ami"),
+		ImageLookupFormat:    randomStringOrEmpty(r, "format-"),
+		ImageLookupOrg:       randomStringOrEmpty(r, "org-"),
+		ImageLookupBaseOS:    randomStringOrEmpty(r, "os-"),
+		InstanceType:         randomString(r, "m5."),
+		IAMInstanceProfile:   randomStringOrEmpty(r, "profile-"),
+		PublicIP:             randomBoolOrNil(r),
+		SSHKeyName:           randomStringOrNil(r, "key-"),
+		UncompressedUserData: randomBoolOrNil(r),
+		Tenancy:              pick(r, testTenancies...),
+	}
+
+	for _, name := range randomNames(r, "tag-") {
+		if spec.AdditionalTags == nil {
+			spec.AdditionalTags = capi.Tags{}
+		}
+		spec.AdditionalTags[name] = randomString(r, "value-")
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		spec.AdditionalSecurityGroups = append(spec.AdditionalSecurityGroups, randomCAPIResourceReference(r, "sg"))
+	}
+	if r.Intn(2) == 0 {
+		spec.FailureDomain = pointer.String(pick(r, testAvailabilityZones...))
+	}
+	if r.Intn(2) == 0 {
+		subnet := randomCAPIResourceReference(r, "subnet")
+		spec.Subnet = &subnet
+	}
+
+	if r.Intn(2) == 0 {
+		rootVolume := randomVolume(r, randomStringOrEmpty(r, "/dev/sd"))
+		spec.RootVolume = &rootVolume
+	}
+	for _, name := range randomNames(r, "/dev/xvd") {
+		spec.NonRootVolumes = append(spec.NonRootVolumes, randomVolume(r, name))
+	}
+	spec.NetworkInterfaces = randomNames(r, "eni-")
+	if len(spec.NetworkInterfaces) > maxNetworkInterfaces {
+		spec.NetworkInterfaces = spec.NetworkInterfaces[:maxNetworkInterfaces]
+	}
+
+	spec.CloudInit = capi.CloudInit{
+		InsecureSkipSecretsManager: r.Intn(2) == 0,
+		SecretCount:                int32(r.Intn(3)),
+		SecretPrefix:               randomStringOrEmpty(r, "prefix-"),
+		SecureSecretsBackend:       capi.SecretBackend(pick(r, "", string(capi.SecretBackendSecretsManager), string(capi.SecretBackendSSMParameterStore))),
+	}
+	if r.Intn(2) == 0 {
+		spec.Ignition = &capi.Ignition{Version: pick(r, "2.3", "3.1")}
+	}
+	switch r.Intn(3) {
+	case 0:
+		spec.SpotMarketOptions = &capi.SpotMarketOptions{}
+	case 1:
+		spec.SpotMarketOptions = &capi.SpotMarketOptions{MaxPrice: pointer.String(fmt.Sprintf("0.%d", r.Intn(100)))}
+	}
+
+	return spec
+}
+
+func randomVolume(r *rand.Rand, deviceName string) capi.Volume {
+	return capi.Volume{
+		DeviceName:    deviceName,
+		Size:          int64(8 + r.Intn(2000)),
+		Type:          randomStringOrEmpty(r, "gp"),
+		IOPS:          int64(r.Intn(2) * r.Intn(16000)),
+		Encrypted:     r.Intn(2) == 0,
+		EncryptionKey: pick(r, "", randomString(r, "key-"), randomString(r, "arn:aws:kms:us-east-1:123456789012:key/")),
+	}
+}
+
+func randomCAPIResourceReference(r *rand.Rand, prefix string) capi.AWSResourceReference {
+	reference := randomMAPIResourceReference(r, prefix)
+	capiReference := capi.AWSResourceReference{ID: reference.ID, ARN: reference.ARN}
+	for _, filter := range reference.Filters {
+		capiReference.Filters = append(capiReference.Filters, capi.Filter{Name: filter.Name, Values: filter.Values})
+	}
+	return capiReference
+}
+
+// randomNames returns up to three unique, sorted names.
+func randomNames(r *rand.Rand, prefix string) []string {
+	names := map[string]bool{}
+	for i := r.Intn(4); i > 0; i-- {
+		names[prefix+string(rune('a'+r.Intn(26)))] = true
+	}
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func randomString(r *rand.Rand, prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, r.Intn(1000))
+}
+
+func randomStringOrEmpty(r *rand.Rand, prefix string) string {
+	return pick(r, "", randomString(r, prefix))
+}
+
+func randomStringOrNil(r *rand.Rand, prefix string) *string {
+	if r.Intn(2) == 0 {
+		return nil
+	}
+	return pointer.String(randomString(r, prefix))
+}
+
+func randomInt64OrNil(r *rand.Rand) *int64 {
+	if r.Intn(2) == 0 {
+		return nil
+	}
+	return pointer.Int64(int64(r.Intn(2000)))
+}
+
+func randomBoolOrNil(r *rand.Rand) *bool {
+	if r.Intn(2) == 0 {
+		return nil
+	}
+	return pointer.Bool(r.Intn(2) == 0)
+}
+
+func pick(r *rand.Rand, values ...string) string {
+	return values[r.Intn(len(values))]
+}
@@ -0,0 +1,225 @@
+package converter
+
+import (
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+)
+
+// machinePhasesToCAPI maps MAPI machine phases to CAPI ones. MAPI machines have
+// no phase before they start provisioning, which is CAPI's Pending.
+var machinePhasesToCAPI = map[string]capi.MachinePhase{
+	"":                     capi.MachinePhasePending,
+	mapi.PhaseProvisioning: capi.MachinePhaseProvisioning,
+	mapi.PhaseProvisioned:  capi.MachinePhaseProvisioned,
+	mapi.PhaseRunning:      capi.MachinePhaseRunning,
+	mapi.PhaseDeleting:     capi.MachinePhaseDeleting,
+	mapi.PhaseFailed:       capi.MachinePhaseFailed,
+}
+
+var machinePhasesToMAPI = map[capi.MachinePhase]string{
+	capi.MachinePhasePending:      "",
+	capi.MachinePhaseProvisioning: mapi.PhaseProvisioning,
+	capi.MachinePhaseProvisioned:  mapi.PhaseProvisioned,
+	capi.MachinePhaseRunning:      mapi.PhaseRunning,
+	capi.MachinePhaseDeleting:     mapi.PhaseDeleting,
+	capi.MachinePhaseDeleted:      mapi.PhaseDeleting,
+	capi.MachinePhaseFailed:       mapi.PhaseFailed,
+}
+
+var machineErrorsToCAPI = map[mapi.MachineStatusError]capierrors.MachineStatusError{
+	mapi.InvalidConfigurationMachineError:  capierrors.InvalidConfigurationMachineError,
+	mapi.UnsupportedChangeMachineError:     capierrors.UnsupportedChangeMachineError,
+	mapi.InsufficientResourcesMachineError: capierrors.InsufficientResourcesMachineError,
+	mapi.CreateMachineError:                capierrors.CreateMachineError,
+	mapi.UpdateMachineError:                capierrors.UpdateMachineError,
+	mapi.DeleteMachineError:                capierrors.DeleteMachineError,
+	mapi.JoinClusterTimeoutMachineError:    capierrors.JoinClusterTimeoutMachineError,
+}
+
+func convertMachineStatusToCAPI(mapiStatus mapi.MachineStatus, report *ConversionReport) capi.MachineStatus {
+	capiStatus := capi.MachineStatus{
+		NodeRef:        copyObjectReference(mapiStatus.NodeRef),
+		LastUpdated:    mapiStatus.LastUpdated,
+		FailureMessage: mapiStatus.ErrorMessage,
+		Conditions:     convertConditionsToCAPI(mapiStatus.Conditions),
+	}
+
+	phase := ""
+	if mapiStatus.Phase != nil {
+		phase = *mapiStatus.Phase
+	}
+	capiPhase, ok := machinePhasesToCAPI[phase]
+	if !ok {
+		report.add("status.phase", "unknown MAPI phase %s, converted to %s", phase, capi.MachinePhaseUnknown)
+		capiPhase = capi.MachinePhaseUnknown
+	}
+	capiStatus.SetTypedPhase(capiPhase)
+
+	// MAPI machines have no bootstrap status. Their instance is ready once it
+	// has been provisioned, and it has consumed the user data by then, or once
+	// its node joined.
+	capiStatus.InfrastructureReady = capiPhase == capi.MachinePhaseProvisioned || capiPhase == capi.MachinePhaseRunning
+	capiStatus.BootstrapReady = capiStatus.InfrastructureReady || mapiStatus.NodeRef != nil
+	if !capiStatus.BootstrapReady && capiPhase != capi.MachinePhasePending && capiPhase != capi.MachinePhaseProvisioning {
+		report.add("status.bootstrapReady", "bootstrap readiness can't be derived from the MAPI %s phase, it was left false", phase)
+	}
+
+	if mapiStatus.ErrorReason != nil {
+		failureReason, ok := machineErrorsToCAPI[*mapiStatus.ErrorReason]
+		if !ok {
+			report.add("status.errorReason", "unknown MAPI error reason %s was copied verbatim", *mapiStatus.ErrorReason)
+			failureReason = capierrors.MachineStatusError(*mapiStatus.ErrorReason)
+		}
+		capiStatus.FailureReason = &failureReason
+	}
+
+	for _, address := range mapiStatus.Addresses {
+		capiStatus.Addresses = append(capiStatus.Addresses, capi.MachineAddress{
+			Type:    capi.MachineAddressType(address.Type),
+			Address: address.Address,
+		})
+	}
+
+	if mapiStatus.ProviderStatus != nil {
+		report.add("status.providerStatus", "CAPI keeps the provider status on the AWSMachine, it was dropped")
+	}
+	if mapiStatus.LastOperation != nil {
+		report.add("status.lastOperation", "CAPI machines have no last operation, it was dropped")
+	}
+
+	return capiStatus
+}
+
+func convertMachineStatusToMAPI(capiStatus capi.MachineStatus, report *ConversionReport) mapi.MachineStatus {
+	mapiStatus := mapi.MachineStatus{
+		NodeRef:      copyObjectReference(capiStatus.NodeRef),
+		LastUpdated:  capiStatus.LastUpdated,
+		ErrorMessage: capiStatus.FailureMessage,
+		Conditions:   convertConditionsToMAPI(capiStatus.Conditions),
+	}
+
+	phase, ok := machinePhasesToMAPI[capiStatus.GetTypedPhase()]
+	if !ok {
+		report.add("status.phase", "MAPI has no %s phase, it was dropped", capiStatus.Phase)
+	}
+	if phase != "" {
+		mapiStatus.Phase = &phase
+	}
+
+	if capiStatus.FailureReason != nil {
+		errorReason := mapi.MachineStatusError(*capiStatus.FailureReason)
+		for mapiReason, capiReason := range machineErrorsToCAPI {
+			if capiReason == *capiStatus.FailureReason {
+				errorReason = mapiReason
+			}
+		}
+		mapiStatus.ErrorReason = &errorReason
+	}
+
+	for _, address := range capiStatus.Addresses {
+		mapiStatus.Addresses = append(mapiStatus.Addresses, corev1.NodeAddress{
+			Type:    corev1.NodeAddressType(address.Type),
+			Address: address.Address,
+		})
+	}
+
+	if capiStatus.Version != nil {
+		report.add("status.version", "MAPI machines don't report a Kubernetes version, %s was dropped", *capiStatus.Version)
+	}
+
+	return mapiStatus
+}
+
+// convertMachineSetStatusToCAPI converts the replica counts and failures. The
+// selector is set from the converted spec, CAPI reports it in its string form.
+func convertMachineSetStatusToCAPI(mapiStatus mapi.MachineSetStatus, selector metav1.LabelSelector) capi.MachineSetStatus {
+	capiStatus := capi.MachineSetStatus{
+		Replicas:             mapiStatus.Replicas,
+		FullyLabeledReplicas: mapiStatus.FullyLabeledReplicas,
+		ReadyReplicas:        mapiStatus.ReadyReplicas,
+		AvailableReplicas:    mapiStatus.AvailableReplicas,
+		ObservedGeneration:   mapiStatus.ObservedGeneration,
+		FailureMessage:       mapiStatus.ErrorMessage,
+	}
+
+	if labelSelector, err := metav1.LabelSelectorAsSelector(&selector); err == nil {
+		capiStatus.Selector = labelSelector.String()
+	}
+
+	if mapiStatus.ErrorReason != nil {
+		failureReason := capierrors.MachineSetStatusError(*mapiStatus.ErrorReason)
+		capiStatus.FailureReason = &failureReason
+	}
+
+	return capiStatus
+}
+
+func convertMachineSetStatusToMAPI(capiStatus capi.MachineSetStatus) mapi.MachineSetStatus {
+	mapiStatus := mapi.MachineSetStatus{
+		Replicas:             capiStatus.Replicas,
+		FullyLabeledReplicas: capiStatus.FullyLabeledReplicas,
+		ReadyReplicas:        capiStatus.ReadyReplicas,
+		AvailableReplicas:    capiStatus.AvailableReplicas,
+		ObservedGeneration:   capiStatus.ObservedGeneration,
+		ErrorMessage:         capiStatus.FailureMessage,
+	}
+
+	if capiStatus.FailureReason != nil {
+		errorReason := mapi.MachineSetStatusError(*capiStatus.FailureReason)
+		mapiStatus.ErrorReason = &errorReason
+	}
+
+	return mapiStatus
+}
+
+func convertConditionsToCAPI(mapiConditions mapi.Conditions) capi.Conditions {
+	if mapiConditions == nil {
+		return nil
+	}
+
+	capiConditions := capi.Conditions{}
+	for _, condition := range mapiConditions {
+		capiConditions = append(capiConditions, capi.Condition{
+			Type:               capi.ConditionType(condition.Type),
+			Status:             condition.Status,
+			Severity:           capi.ConditionSeverity(condition.Severity),
+			LastTransitionTime: condition.LastTransitionTime,
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+		})
+	}
+
+	return capiConditions
+}
+
+func convertConditionsToMAPI(capiConditions capi.Conditions) mapi.Conditions {
+	if capiConditions == nil {
+		return nil
+	}
+
+	mapiConditions := mapi.Conditions{}
+	for _, condition := range capiConditions {
+		mapiConditions = append(mapiConditions, mapi.Condition{
+			Type:               mapi.ConditionType(condition.Type),
+			Status:             condition.Status,
+			Severity:           mapi.ConditionSeverity(condition.Severity),
+			LastTransitionTime: condition.LastTransitionTime,
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+		})
+	}
+
+	return mapiConditions
+}
+
+func copyObjectReference(ref *corev1.ObjectReference) *corev1.ObjectReference {
+	if ref == nil {
+		return nil
+	}
+
+	copied := *ref
+	return &copied
+}
@@ -0,0 +1,138 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+)
+
+func TestConvertMachineStatusToCAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	errorReason := mapi.InsufficientResourcesMachineError
+	mapiStatus := mapi.MachineStatus{
+		NodeRef:      &corev1.ObjectReference{Kind: "Node", Name: "ip-10-0-1-2"},
+		ErrorReason:  &errorReason,
+		ErrorMessage: pointer.String("out of capacity"),
+		Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.1.2"},
+			{Type: corev1.NodeInternalDNS, Address: "ip-10-0-1-2.ec2.internal"},
+		},
+		Phase:          pointer.String(mapi.PhaseRunning),
+		ProviderStatus: &runtime.RawExtension{Raw: []byte(`{}`)},
+		Conditions: mapi.Conditions{
+			{Type: "Drainable", Status: corev1.ConditionFalse, Severity: mapi.ConditionSeverityWarning, Reason: "PDB"},
+		},
+	}
+
+	report := &ConversionReport{}
+	capiStatus := convertMachineStatusToCAPI(mapiStatus, report)
+	g.Expect(capiStatus.GetTypedPhase()).To(Equal(capi.MachinePhaseRunning))
+	g.Expect(capiStatus.NodeRef).To(Equal(mapiStatus.NodeRef))
+	g.Expect(capiStatus.FailureReason).To(Equal(errorPtr(capierrors.InsufficientResourcesMachineError)))
+	g.Expect(capiStatus.FailureMessage).To(Equal(pointer.String("out of capacity")))
+	g.Expect(capiStatus.Addresses).To(Equal(capi.MachineAddresses{
+		{Type: capi.MachineInternalIP, Address: "10.0.1.2"},
+		{Type: capi.MachineInternalDNS, Address: "ip-10-0-1-2.ec2.internal"},
+	}))
+	g.Expect(capiStatus.Conditions).To(Equal(capi.Conditions{
+		{Type: "Drainable", Status: corev1.ConditionFalse, Severity: capi.ConditionSeverityWarning, Reason: "PDB"},
+	}))
+	g.Expect(capiStatus.BootstrapReady).To(BeTrue())
+	g.Expect(capiStatus.InfrastructureReady).To(BeTrue())
+	g.Expect(report.Entries).To(HaveLen(1))
+	g.Expect(report.Entries[0].Field).To(Equal("status.providerStatus"))
+
+	roundTripped := convertMachineStatusToMAPI(capiStatus, report)
+	mapiStatus.ProviderStatus = nil
+	g.Expect(roundTripped).To(Equal(mapiStatus))
+}
+
+func TestConvertMachineStatusBootstrapReady(t *testing.T) {
+	testCases := []struct {
+		phase          string
+		nodeRef        *corev1.ObjectReference
+		bootstrapReady bool
+		reported       bool
+	}{
+		{phase: "", bootstrapReady: false},
+		{phase: mapi.PhaseProvisioning, bootstrapReady: false},
+		{phase: mapi.PhaseProvisioned, bootstrapReady: true},
+		{phase: mapi.PhaseRunning, bootstrapReady: true},
+		{phase: mapi.PhaseDeleting, nodeRef: &corev1.ObjectReference{Kind: "Node", Name: "ip-10-0-1-2"}, bootstrapReady: true},
+		{phase: mapi.PhaseDeleting, bootstrapReady: false, reported: true},
+		{phase: mapi.PhaseFailed, bootstrapReady: false, reported: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.phase, func(t *testing.T) {
+			g := NewWithT(t)
+
+			report := &ConversionReport{}
+			capiStatus := convertMachineStatusToCAPI(mapi.MachineStatus{Phase: pointer.String(tc.phase), NodeRef: tc.nodeRef}, report)
+			g.Expect(capiStatus.BootstrapReady).To(Equal(tc.bootstrapReady))
+			if tc.reported {
+				g.Expect(report.Entries).To(HaveLen(1))
+				g.Expect(report.Entries[0].Field).To(Equal("status.bootstrapReady"))
+			} else {
+				g.Expect(report.Entries).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestConvertMachinePhases(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(convertMachineStatusToCAPI(mapi.MachineStatus{}, nil).Phase).To(Equal(string(capi.MachinePhasePending)))
+
+	report := &ConversionReport{}
+	g.Expect(convertMachineStatusToCAPI(mapi.MachineStatus{Phase: pointer.String("Stopped")}, report).Phase).To(Equal(string(capi.MachinePhaseUnknown)))
+	g.Expect(report.Entries).To(HaveLen(2))
+	g.Expect(report.Entries[0].Field).To(Equal("status.phase"))
+	g.Expect(report.Entries[1].Field).To(Equal("status.bootstrapReady"))
+
+	g.Expect(convertMachineStatusToMAPI(capi.MachineStatus{Phase: string(capi.MachinePhasePending)}, nil).Phase).To(BeNil())
+	g.Expect(convertMachineStatusToMAPI(capi.MachineStatus{Phase: string(capi.MachinePhaseDeleted)}, nil).Phase).To(Equal(pointer.String(mapi.PhaseDeleting)))
+
+	report = &ConversionReport{}
+	g.Expect(convertMachineStatusToMAPI(capi.MachineStatus{Phase: "Stopped"}, report).Phase).To(BeNil())
+	g.Expect(report.Entries).To(HaveLen(1))
+}
+
+func TestConvertMachineSetStatus(t *testing.T) {
+	g := NewWithT(t)
+
+	errorReason := mapi.InvalidConfigurationMachineSetError
+	mapiStatus := mapi.MachineSetStatus{
+		Replicas:             3,
+		FullyLabeledReplicas: 3,
+		ReadyReplicas:        2,
+		AvailableReplicas:    2,
+		ObservedGeneration:   4,
+		ErrorReason:          &errorReason,
+		ErrorMessage:         pointer.String("invalid instance type"),
+	}
+	selector := metav1.LabelSelector{
+		MatchLabels: map[string]string{capi.MachineSetLabelName: "worker"},
+	}
+
+	capiStatus := convertMachineSetStatusToCAPI(mapiStatus, selector)
+	g.Expect(capiStatus.Selector).To(Equal(capi.MachineSetLabelName + "=worker"))
+	g.Expect(capiStatus.Replicas).To(BeEquivalentTo(3))
+	g.Expect(capiStatus.ReadyReplicas).To(BeEquivalentTo(2))
+	g.Expect(*capiStatus.FailureReason).To(Equal(capierrors.InvalidConfigurationMachineSetError))
+
+	g.Expect(convertMachineSetStatusToMAPI(capiStatus)).To(Equal(mapiStatus))
+}
+
+func errorPtr(err capierrors.MachineStatusError) *capierrors.MachineStatusError {
+	return &err
+}
@@ -0,0 +1,350 @@
+// Package diff compares freshly converted objects with existing ones
+// semantically, to detect objects that drifted from their source.
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Type is the kind of a difference.
+type Type string
+
+const (
+	// Changed fields have different values.
+	Changed Type = "changed"
+
+	// Added fields are only set in the converted object.
+	Added Type = "added"
+
+	// Removed fields are only set in the existing object.
+	Removed Type = "removed"
+
+	// Missing objects were converted but don't exist.
+	Missing Type = "missing"
+)
+
+// OutputFormat selects how differences are written.
+type OutputFormat string
+
+const (
+	OutputFormatText OutputFormat = "text"
+	OutputFormatJSON OutputFormat = "json"
+)
+
+// Difference is a field, or a whole object, that differs between the
+// existing and the converted object.
+type Difference struct {
+	// Object is the Kind/namespace/name of the converted object.
+	Object string `json:"object"`
+
+	// Path is the field path, e.g. spec.template.spec.instanceType. Empty for
+	// missing objects.
+	Path string `json:"path,omitempty"`
+
+	Type      Type        `json:"type"`
+	Existing  interface{} `json:"existing"`
+	Converted interface{} `json:"converted"`
+}
+
+// serverManagedMetadata is set by the API server and never converted.
+var serverManagedMetadata = []string{
+	"managedFields",
+	"resourceVersion",
+	"uid",
+	"creationTimestamp",
+	"generation",
+	"selfLink",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+}
+
+// clientManagedAnnotations are set by clients applying the objects.
+var clientManagedAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// defaulters set the fields the API server defaults, so an omitted field
+// equals its default value.
+var defaulters = map[schema.GroupKind]func(object map[string]interface{}){
+	capi.GroupVersion.WithKind("MachineSet").GroupKind(): func(object map[string]interface{}) {
+		setDefault(object, int64(1), "spec", "replicas")
+		setDefault(object, string(capi.RandomMachineSetDeletePolicy), "spec", "deletePolicy")
+		if clusterName, ok, _ := unstructured.NestedString(object, "spec", "clusterName"); ok {
+			setDefault(object, clusterName, "metadata", "labels", capi.ClusterLabelName)
+		}
+	},
+	capi.InfrastructureGroupVersion.WithKind("AWSMachineTemplate").GroupKind(): func(object map[string]interface{}) {
+		if _, ignition, _ := unstructured.NestedMap(object, "spec", "template", "spec", "ignition"); !ignition {
+			setDefault(object, string(capi.SecretBackendSecretsManager), "spec", "template", "spec", "cloudInit", "secureSecretsBackend")
+		}
+	},
+}
+
+// ParseObjects reads a multi-document YAML or JSON stream. Lists are expanded
+// into their items.
+func ParseObjects(data []byte) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	objects := []*unstructured.Unstructured{}
+	for {
+		raw := json.RawMessage{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("error unmarshalling objects: %v", err)
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		// Unstructured keeps integers as int64, like the converted objects.
+		object := &unstructured.Unstructured{}
+		if err := object.UnmarshalJSON(raw); err != nil {
+			return nil, fmt.Errorf("error unmarshalling object: %v", err)
+		}
+
+		if !object.IsList() {
+			objects = append(objects, object)
+			continue
+		}
+		list, err := object.ToList()
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling %s: %v", object.GetKind(), err)
+		}
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	}
+}
+
+// Compare returns the differences between every converted object and the
+// existing object of the same kind and name. Status, server managed metadata
+// and defaulted fields are ignored, and so are empty fields. Existing objects
+// without a converted counterpart are ignored too.
+func Compare(converted, existing []*unstructured.Unstructured) []Difference {
+	differences := []Difference{}
+	for _, convertedObject := range converted {
+		convertedObject = convertedObject.DeepCopy()
+		existingObject := findObject(existing, convertedObject)
+		if existingObject == nil {
+			differences = append(differences, Difference{Object: describe(convertedObject), Type: Missing})
+			continue
+		}
+		if convertedObject.GetNamespace() == "" {
+			convertedObject.SetNamespace(existingObject.GetNamespace())
+		}
+
+		compare(describe(convertedObject), "", normalize(existingObject), normalize(convertedObject), &differences)
+	}
+	return differences
+}
+
+// SpecChanged returns whether the spec of a converted object differs from the
+// spec of the existing object. Defaulted and empty fields are ignored like in
+// Compare, so a spec the API server defaulted doesn't count as changed.
+func SpecChanged(converted, existing *unstructured.Unstructured) bool {
+	differences := []Difference{}
+	compare(describe(converted), "spec", specOf(normalize(existing)), specOf(normalize(converted)), &differences)
+	return len(differences) > 0
+}
+
+func specOf(object interface{}) interface{} {
+	fields, _ := object.(map[string]interface{})
+	return fields["spec"]
+}
+
+// Write writes the differences as text, one per line, or as a JSON document.
+func Write(out io.Writer, format OutputFormat, differences []Difference) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Differences []Difference `json:"differences"`
+		}{differences})
+	case OutputFormatText, "":
+		for _, difference := range differences {
+			if _, err := fmt.Fprintln(out, difference.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown diff output format %q, must be either text or json", format)
+	}
+}
+
+func (d Difference) String() string {
+	switch d.Type {
+	case Missing:
+		return fmt.Sprintf("%s missing", d.Object)
+	case Added:
+		return fmt.Sprintf("%s %s added: %s", d.Object, d.Path, formatValue(d.Converted))
+	case Removed:
+		return fmt.Sprintf("%s %s removed: %s", d.Object, d.Path, formatValue(d.Existing))
+	default:
+		return fmt.Sprintf("%s %s changed: %s -> %s", d.Object, d.Path, formatValue(d.Existing), formatValue(d.Converted))
+	}
+}
+
+func formatValue(value interface{}) string {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
+
+func findObject(objects []*unstructured.Unstructured, object *unstructured.Unstructured) *unstructured.Unstructured {
+	groupKind := object.GroupVersionKind().GroupKind()
+	for _, candidate := range objects {
+		if candidate.GroupVersionKind().GroupKind() != groupKind || candidate.GetName() != object.GetName() {
+			continue
+		}
+		if object.GetNamespace() != "" && candidate.GetNamespace() != "" && candidate.GetNamespace() != object.GetNamespace() {
+			continue
+		}
+		return candidate
+	}
+	return nil
+}
+
+// normalize returns the fields of the object that conversion sets.
+func normalize(object *unstructured.Unstructured) interface{} {
+	object = object.DeepCopy()
+	delete(object.Object, "status")
+	for _, field := range serverManagedMetadata {
+		unstructured.RemoveNestedField(object.Object, "metadata", field)
+	}
+	for _, annotation := range clientManagedAnnotations {
+		unstructured.RemoveNestedField(object.Object, "metadata", "annotations", annotation)
+	}
+
+	if defaulter, ok := defaulters[object.GroupVersionKind().GroupKind()]; ok {
+		defaulter(object.Object)
+	}
+
+	return prune(object.Object)
+}
+
+func setDefault(object map[string]interface{}, value interface{}, fields ...string) {
+	if _, ok, _ := unstructured.NestedFieldNoCopy(object, fields...); ok {
+		return
+	}
+	_ = unstructured.SetNestedField(object, value, fields...)
+}
+
+// prune drops nil values and empty maps and lists, so omitted and empty
+// fields compare equal.
+func prune(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for key, item := range value {
+			if item = prune(item); item != nil {
+				out[key] = item
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	case []interface{}:
+		if len(value) == 0 {
+			return nil
+		}
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = prune(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+func compare(object, path string, existing, converted interface{}, differences *[]Difference) {
+	switch {
+	case existing == nil && converted == nil:
+		return
+	case existing == nil:
+		*differences = append(*differences, Difference{Object: object, Path: path, Type: Added, Converted: converted})
+		return
+	case converted == nil:
+		*differences = append(*differences, Difference{Object: object, Path: path, Type: Removed, Existing: existing})
+		return
+	}
+
+	existingMap, existingIsMap := existing.(map[string]interface{})
+	convertedMap, convertedIsMap := converted.(map[string]interface{})
+	if existingIsMap && convertedIsMap {
+		keys := map[string]struct{}{}
+		for key := range existingMap {
+			keys[key] = struct{}{}
+		}
+		for key := range convertedMap {
+			keys[key] = struct{}{}
+		}
+		sortedKeys := make([]string, 0, len(keys))
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+
+		for _, key := range sortedKeys {
+			compare(object, fieldPath(path, key), existingMap[key], convertedMap[key], differences)
+		}
+		return
+	}
+
+	existingList, existingIsList := existing.([]interface{})
+	convertedList, convertedIsList := converted.([]interface{})
+	if existingIsList && convertedIsList {
+		for i := 0; i < len(existingList) || i < len(convertedList); i++ {
+			var existingItem, convertedItem interface{}
+			if i < len(existingList) {
+				existingItem = existingList[i]
+			}
+			if i < len(convertedList) {
+				convertedItem = convertedList[i]
+			}
+			compare(object, fmt.Sprintf("%s[%d]", path, i), existingItem, convertedItem, differences)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(existing, converted) {
+		*differences = append(*differences, Difference{Object: object, Path: path, Type: Changed, Existing: existing, Converted: converted})
+	}
+}
+
+var plainField = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// fieldPath appends a field to a path. Keys that aren't plain field names,
+// e.g. label keys, are quoted.
+func fieldPath(path, key string) string {
+	if !plainField.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describe(object *unstructured.Unstructured) string {
+	return strings.Join([]string{object.GetKind(), object.GetNamespace(), object.GetName()}, "/")
+}
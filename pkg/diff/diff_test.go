@@ -0,0 +1,258 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const convertedObjects = `apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+kind: AWSMachineTemplate
+metadata:
+  creationTimestamp: null
+  name: worker-a
+  namespace: openshift-machine-api
+spec:
+  template:
+    spec:
+      ami:
+        id: ami-0123
+      instanceType: m5.large
+      additionalTags:
+        owner: team-a
+        env: prod
+status: {}
+---
+apiVersion: cluster.x-k8s.io/v1alpha4
+kind: MachineSet
+metadata:
+  creationTimestamp: null
+  name: worker-a
+  namespace: openshift-machine-api
+spec:
+  clusterName: cluster
+  selector:
+    matchLabels:
+      cluster.x-k8s.io/cluster-name: cluster
+  template:
+    metadata:
+      labels:
+        cluster.x-k8s.io/cluster-name: cluster
+    spec:
+      bootstrap: {}
+      clusterName: cluster
+      infrastructureRef:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+        kind: AWSMachineTemplate
+        name: worker-a
+status: {}
+`
+
+// existingObjects is what the API server returns for the converted objects,
+// with map keys reordered.
+const existingObjects = `apiVersion: v1
+kind: List
+items:
+- apiVersion: cluster.x-k8s.io/v1alpha4
+  kind: MachineSet
+  metadata:
+    annotations:
+      kubectl.kubernetes.io/last-applied-configuration: "{}"
+    creationTimestamp: "2021-07-01T00:00:00Z"
+    generation: 3
+    labels:
+      cluster.x-k8s.io/cluster-name: cluster
+    managedFields:
+    - manager: kubectl
+    name: worker-a
+    namespace: openshift-machine-api
+    resourceVersion: "1234"
+    uid: 0f6e4c36-2f53-4a57-8f2c-58d7d2b7f0a4
+  spec:
+    clusterName: cluster
+    deletePolicy: Random
+    replicas: 1
+    selector:
+      matchLabels:
+        cluster.x-k8s.io/cluster-name: cluster
+    template:
+      metadata:
+        labels:
+          cluster.x-k8s.io/cluster-name: cluster
+      spec:
+        clusterName: cluster
+        infrastructureRef:
+          apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+          kind: AWSMachineTemplate
+          name: worker-a
+  status:
+    replicas: 1
+- apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+  kind: AWSMachineTemplate
+  metadata:
+    name: worker-a
+    namespace: openshift-machine-api
+  spec:
+    template:
+      spec:
+        additionalTags:
+          env: prod
+          owner: team-a
+        ami:
+          id: ami-0123
+        cloudInit:
+          secureSecretsBackend: secrets-manager
+        instanceType: m5.large
+`
+
+func TestCompareIgnoresServerManagedAndDefaultedFields(t *testing.T) {
+	g := NewWithT(t)
+
+	converted, err := ParseObjects([]byte(convertedObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(converted).To(HaveLen(2))
+	existing, err := ParseObjects([]byte(existingObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(existing).To(HaveLen(2))
+
+	g.Expect(Compare(converted, existing)).To(BeEmpty())
+}
+
+func TestSpecChanged(t *testing.T) {
+	g := NewWithT(t)
+
+	converted, err := ParseObjects([]byte(convertedObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+	existing, err := ParseObjects([]byte(existingObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// The existing machine set has its replicas and delete policy defaulted.
+	convertedTemplate, convertedMachineSet := converted[0], converted[1]
+	existingMachineSet, existingTemplate := existing[0], existing[1]
+	g.Expect(SpecChanged(convertedMachineSet, existingMachineSet)).To(BeFalse())
+	g.Expect(SpecChanged(convertedTemplate, existingTemplate)).To(BeFalse())
+
+	changed := existingTemplate.DeepCopy()
+	g.Expect(unstructured.SetNestedField(changed.Object, "m5.xlarge", "spec", "template", "spec", "instanceType")).To(Succeed())
+	g.Expect(SpecChanged(convertedTemplate, changed)).To(BeTrue())
+
+	// Metadata isn't part of the spec.
+	relabeled := existingTemplate.DeepCopy()
+	relabeled.SetLabels(map[string]string{"team": "b"})
+	g.Expect(SpecChanged(convertedTemplate, relabeled)).To(BeFalse())
+}
+
+func TestCompareReportsFieldDifferences(t *testing.T) {
+	g := NewWithT(t)
+
+	converted, err := ParseObjects([]byte(convertedObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+	existing, err := ParseObjects([]byte(existingObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	existingMachineSet := existing[0]
+	existingMachineSet.Object["spec"].(map[string]interface{})["replicas"] = int64(3)
+	existingTemplateSpec := existing[1].Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	existingTemplateSpec["instanceType"] = "m5.xlarge"
+	existingTemplateSpec["additionalTags"].(map[string]interface{})["cost-center"] = "42"
+	delete(existingTemplateSpec, "ami")
+
+	g.Expect(Compare(converted, existing)).To(Equal([]Difference{
+		{
+			Object:   "AWSMachineTemplate/openshift-machine-api/worker-a",
+			Path:     `spec.template.spec.additionalTags["cost-center"]`,
+			Type:     Removed,
+			Existing: "42",
+		},
+		{
+			Object:    "AWSMachineTemplate/openshift-machine-api/worker-a",
+			Path:      "spec.template.spec.ami",
+			Type:      Added,
+			Converted: map[string]interface{}{"id": "ami-0123"},
+		},
+		{
+			Object:    "AWSMachineTemplate/openshift-machine-api/worker-a",
+			Path:      "spec.template.spec.instanceType",
+			Type:      Changed,
+			Existing:  "m5.xlarge",
+			Converted: "m5.large",
+		},
+		{
+			Object:    "MachineSet/openshift-machine-api/worker-a",
+			Path:      "spec.replicas",
+			Type:      Changed,
+			Existing:  int64(3),
+			Converted: int64(1),
+		},
+	}))
+}
+
+func TestCompareReportsMissingObjects(t *testing.T) {
+	g := NewWithT(t)
+
+	converted, err := ParseObjects([]byte(convertedObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+	existing, err := ParseObjects([]byte(existingObjects))
+	g.Expect(err).NotTo(HaveOccurred())
+	existing[1].SetNamespace("other")
+
+	g.Expect(Compare(converted, existing[1:])).To(Equal([]Difference{
+		{Object: "AWSMachineTemplate/openshift-machine-api/worker-a", Type: Missing},
+		{Object: "MachineSet/openshift-machine-api/worker-a", Type: Missing},
+	}))
+}
+
+func TestWrite(t *testing.T) {
+	differences := []Difference{
+		{Object: "AWSMachineTemplate/ns/worker-a", Type: Missing},
+		{Object: "MachineSet/ns/worker-a", Path: "spec.replicas", Type: Changed, Existing: int64(3), Converted: int64(1)},
+		{Object: "MachineSet/ns/worker-a", Path: `metadata.labels["a/b"]`, Type: Added, Converted: "c"},
+		{Object: "MachineSet/ns/worker-a", Path: "spec.minReadySeconds", Type: Removed, Existing: int64(10)},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		g := NewWithT(t)
+
+		out := &bytes.Buffer{}
+		g.Expect(Write(out, OutputFormatText, differences)).To(Succeed())
+		g.Expect(out.String()).To(Equal(`AWSMachineTemplate/ns/worker-a missing
+MachineSet/ns/worker-a spec.replicas changed: 3 -> 1
+MachineSet/ns/worker-a metadata.labels["a/b"] added: "c"
+MachineSet/ns/worker-a spec.minReadySeconds removed: 10
+`))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		g := NewWithT(t)
+
+		out := &bytes.Buffer{}
+		g.Expect(Write(out, OutputFormatJSON, differences)).To(Succeed())
+		decoded := map[string][]map[string]interface{}{}
+		g.Expect(json.Unmarshal(out.Bytes(), &decoded)).To(Succeed())
+		g.Expect(decoded["differences"]).To(HaveLen(4))
+		g.Expect(decoded["differences"][1]).To(Equal(map[string]interface{}{
+			"object":    "MachineSet/ns/worker-a",
+			"path":      "spec.replicas",
+			"type":      "changed",
+			"existing":  float64(3),
+			"converted": float64(1),
+		}))
+	})
+
+	t.Run("no differences as json", func(t *testing.T) {
+		g := NewWithT(t)
+
+		out := &bytes.Buffer{}
+		g.Expect(Write(out, OutputFormatJSON, []Difference{})).To(Succeed())
+		g.Expect(out.String()).To(MatchJSON(`{"differences": []}`))
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		g := NewWithT(t)
+
+		g.Expect(Write(&bytes.Buffer{}, "html", differences)).To(MatchError(`unknown diff output format "html", must be either text or json`))
+	})
+}
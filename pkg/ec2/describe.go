@@ -0,0 +1,167 @@
+// Package ec2 reads the JSON printed by `aws ec2 describe-instances` and
+// `aws ec2 describe-volumes`, so instances can be imported without AWS access.
+package ec2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// InstanceLifecycleSpot is the lifecycle of instances launched as spot instances.
+	InstanceLifecycleSpot = "spot"
+)
+
+// DescribeInstancesOutput is the output of `aws ec2 describe-instances`.
+type DescribeInstancesOutput struct {
+	Reservations []Reservation `json:"Reservations"`
+}
+
+// Reservation groups the instances launched by a single request.
+type Reservation struct {
+	Instances []Instance `json:"Instances"`
+}
+
+// Instance holds the fields of a described instance the converter reads.
+type Instance struct {
+	InstanceID          string               `json:"InstanceId"`
+	InstanceType        string               `json:"InstanceType"`
+	ImageID             string               `json:"ImageId"`
+	SubnetID            string               `json:"SubnetId"`
+	KeyName             string               `json:"KeyName"`
+	State               InstanceState        `json:"State"`
+	PrivateIPAddress    string               `json:"PrivateIpAddress"`
+	PublicIPAddress     string               `json:"PublicIpAddress"`
+	IAMInstanceProfile  *IAMInstanceProfile  `json:"IamInstanceProfile"`
+	SecurityGroups      []GroupIdentifier    `json:"SecurityGroups"`
+	Placement           Placement            `json:"Placement"`
+	RootDeviceName      string               `json:"RootDeviceName"`
+	BlockDeviceMappings []BlockDeviceMapping `json:"BlockDeviceMappings"`
+	NetworkInterfaces   []NetworkInterface   `json:"NetworkInterfaces"`
+	Tags                []Tag                `json:"Tags"`
+	InstanceLifecycle   string               `json:"InstanceLifecycle"`
+	EBSOptimized        *bool                `json:"EbsOptimized"`
+	ENASupport          *bool                `json:"EnaSupport"`
+}
+
+// InstanceState is the state of an instance, e.g. running or stopped.
+type InstanceState struct {
+	Name string `json:"Name"`
+}
+
+// IAMInstanceProfile references the instance profile of an instance.
+type IAMInstanceProfile struct {
+	ARN string `json:"Arn"`
+	ID  string `json:"Id"`
+}
+
+// GroupIdentifier references a security group of an instance.
+type GroupIdentifier struct {
+	GroupID   string `json:"GroupId"`
+	GroupName string `json:"GroupName"`
+}
+
+// Placement is where an instance runs.
+type Placement struct {
+	AvailabilityZone string `json:"AvailabilityZone"`
+	Tenancy          string `json:"Tenancy"`
+}
+
+// BlockDeviceMapping is a volume attached to an instance.
+type BlockDeviceMapping struct {
+	DeviceName string                  `json:"DeviceName"`
+	EBS        *EBSInstanceBlockDevice `json:"Ebs"`
+}
+
+// EBSInstanceBlockDevice references the EBS volume of a block device mapping.
+type EBSInstanceBlockDevice struct {
+	VolumeID            string `json:"VolumeId"`
+	DeleteOnTermination *bool  `json:"DeleteOnTermination"`
+}
+
+// NetworkInterface is an ENI attached to an instance.
+type NetworkInterface struct {
+	NetworkInterfaceID string                     `json:"NetworkInterfaceId"`
+	Attachment         NetworkInterfaceAttachment `json:"Attachment"`
+}
+
+// NetworkInterfaceAttachment describes how an ENI is attached to an instance.
+type NetworkInterfaceAttachment struct {
+	DeviceIndex int64 `json:"DeviceIndex"`
+}
+
+// Tag is a key/value pair attached to an instance.
+type Tag struct {
+	Key   string `json:"Key"`
+	Value string `json:"Value"`
+}
+
+// DescribeVolumesOutput is the output of `aws ec2 describe-volumes`.
+type DescribeVolumesOutput struct {
+	Volumes []Volume `json:"Volumes"`
+}
+
+// Volume holds the fields of a described EBS volume the converter reads.
+type Volume struct {
+	VolumeID   string `json:"VolumeId"`
+	Size       int64  `json:"Size"`
+	VolumeType string `json:"VolumeType"`
+	IOPS       int64  `json:"Iops"`
+	Encrypted  bool   `json:"Encrypted"`
+	KMSKeyID   string `json:"KmsKeyId"`
+}
+
+// ParseInstances returns the instances of every reservation in a
+// describe-instances document.
+func ParseInstances(data []byte) ([]Instance, error) {
+	output := &DescribeInstancesOutput{}
+	if err := json.Unmarshal(data, output); err != nil {
+		return nil, fmt.Errorf("error unmarshalling describe-instances output: %v", err)
+	}
+
+	instances := []Instance{}
+	for _, reservation := range output.Reservations {
+		instances = append(instances, reservation.Instances...)
+	}
+
+	return instances, nil
+}
+
+// FindInstance returns the instance with the given ID. An empty ID is only
+// accepted when there's a single instance to pick.
+func FindInstance(instances []Instance, instanceID string) (Instance, error) {
+	if instanceID == "" {
+		switch len(instances) {
+		case 0:
+			return Instance{}, errors.New("describe-instances output has no instances")
+		case 1:
+			return instances[0], nil
+		default:
+			return Instance{}, fmt.Errorf("describe-instances output has %d instances, an instance id is required", len(instances))
+		}
+	}
+
+	for _, instance := range instances {
+		if instance.InstanceID == instanceID {
+			return instance, nil
+		}
+	}
+
+	return Instance{}, fmt.Errorf("instance %s not found in describe-instances output", instanceID)
+}
+
+// ParseVolumes returns the volumes of a describe-volumes document by volume ID.
+func ParseVolumes(data []byte) (map[string]Volume, error) {
+	output := &DescribeVolumesOutput{}
+	if err := json.Unmarshal(data, output); err != nil {
+		return nil, fmt.Errorf("error unmarshalling describe-volumes output: %v", err)
+	}
+
+	volumes := map[string]Volume{}
+	for _, volume := range output.Volumes {
+		volumes[volume.VolumeID] = volume
+	}
+
+	return volumes, nil
+}
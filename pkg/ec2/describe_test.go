@@ -0,0 +1,85 @@
+package ec2
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const testInstances = `{
+  "Reservations": [
+    {
+      "Instances": [
+        {
+          "InstanceId": "i-0123456789abcdef0",
+          "InstanceType": "m5.large",
+          "ImageId": "ami-0123456789abcdef0",
+          "State": {"Code": 16, "Name": "running"},
+          "BlockDeviceMappings": [
+            {"DeviceName": "/dev/xvda", "Ebs": {"VolumeId": "vol-root", "DeleteOnTermination": true}}
+          ]
+        }
+      ]
+    },
+    {
+      "Instances": [
+        {"InstanceId": "i-0fedcba9876543210", "InstanceType": "m5.xlarge"}
+      ]
+    }
+  ]
+}`
+
+const testVolumes = `{
+  "Volumes": [
+    {"VolumeId": "vol-root", "Size": 120, "VolumeType": "gp3", "Iops": 3000, "Encrypted": true, "KmsKeyId": "arn:aws:kms:us-east-1:123456789012:key/abcd"}
+  ]
+}`
+
+func TestParseInstances(t *testing.T) {
+	g := NewWithT(t)
+
+	instances, err := ParseInstances([]byte(testInstances))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(instances).To(HaveLen(2))
+	g.Expect(instances[0].State.Name).To(Equal("running"))
+	g.Expect(instances[0].BlockDeviceMappings[0].EBS.VolumeID).To(Equal("vol-root"))
+
+	_, err = ParseInstances([]byte("{"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestFindInstance(t *testing.T) {
+	g := NewWithT(t)
+
+	instances, err := ParseInstances([]byte(testInstances))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	instance, err := FindInstance(instances, "i-0fedcba9876543210")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(instance.InstanceType).To(Equal("m5.xlarge"))
+
+	_, err = FindInstance(instances, "")
+	g.Expect(err).To(MatchError(ContainSubstring("an instance id is required")))
+
+	_, err = FindInstance(instances, "i-missing")
+	g.Expect(err).To(HaveOccurred())
+
+	instance, err = FindInstance(instances[:1], "")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(instance.InstanceID).To(Equal("i-0123456789abcdef0"))
+}
+
+func TestParseVolumes(t *testing.T) {
+	g := NewWithT(t)
+
+	volumes, err := ParseVolumes([]byte(testVolumes))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(volumes).To(HaveKeyWithValue("vol-root", Volume{
+		VolumeID:   "vol-root",
+		Size:       120,
+		VolumeType: "gp3",
+		IOPS:       3000,
+		Encrypted:  true,
+		KMSKeyID:   "arn:aws:kms:us-east-1:123456789012:key/abcd",
+	}))
+}
@@ -0,0 +1,96 @@
+package ec2
+
+const (
+	// MarketTypeSpot requests a spot instance.
+	MarketTypeSpot = "spot"
+
+	// ResourceTypeInstance and ResourceTypeVolume are the resources tagged on launch.
+	ResourceTypeInstance = "instance"
+	ResourceTypeVolume   = "volume"
+)
+
+// RunInstancesInput is the request of `aws ec2 run-instances --cli-input-json`.
+type RunInstancesInput struct {
+	LaunchTemplateData
+
+	MinCount int64 `json:"MinCount"`
+	MaxCount int64 `json:"MaxCount"`
+}
+
+// CreateLaunchTemplateInput is the request of
+// `aws ec2 create-launch-template --cli-input-json`.
+type CreateLaunchTemplateInput struct {
+	LaunchTemplateName string             `json:"LaunchTemplateName"`
+	LaunchTemplateData LaunchTemplateData `json:"LaunchTemplateData"`
+}
+
+// LaunchTemplateData holds the instance parameters shared by RunInstances and
+// launch templates. The subnet and security groups are always set on the
+// primary network interface, which is the only place launch templates accept
+// them together with a public IP setting.
+type LaunchTemplateData struct {
+	ImageID               string                            `json:"ImageId,omitempty"`
+	InstanceType          string                            `json:"InstanceType,omitempty"`
+	KeyName               string                            `json:"KeyName,omitempty"`
+	IAMInstanceProfile    *IAMInstanceProfileSpecification  `json:"IamInstanceProfile,omitempty"`
+	NetworkInterfaces     []NetworkInterfaceSpecification   `json:"NetworkInterfaces,omitempty"`
+	BlockDeviceMappings   []BlockDeviceMappingSpecification `json:"BlockDeviceMappings,omitempty"`
+	Placement             *PlacementSpecification           `json:"Placement,omitempty"`
+	InstanceMarketOptions *InstanceMarketOptions            `json:"InstanceMarketOptions,omitempty"`
+	TagSpecifications     []TagSpecification                `json:"TagSpecifications,omitempty"`
+}
+
+// IAMInstanceProfileSpecification references an instance profile by name or ARN.
+type IAMInstanceProfileSpecification struct {
+	Name string `json:"Name,omitempty"`
+	ARN  string `json:"Arn,omitempty"`
+}
+
+// NetworkInterfaceSpecification is either a new interface in a subnet or an
+// existing interface.
+type NetworkInterfaceSpecification struct {
+	DeviceIndex              int64    `json:"DeviceIndex"`
+	NetworkInterfaceID       string   `json:"NetworkInterfaceId,omitempty"`
+	SubnetID                 string   `json:"SubnetId,omitempty"`
+	Groups                   []string `json:"Groups,omitempty"`
+	AssociatePublicIPAddress *bool    `json:"AssociatePublicIpAddress,omitempty"`
+}
+
+// BlockDeviceMappingSpecification is a volume created on launch.
+type BlockDeviceMappingSpecification struct {
+	DeviceName string                       `json:"DeviceName,omitempty"`
+	EBS        *EBSBlockDeviceSpecification `json:"Ebs,omitempty"`
+}
+
+// EBSBlockDeviceSpecification configures an EBS volume created on launch.
+type EBSBlockDeviceSpecification struct {
+	VolumeSize          *int64 `json:"VolumeSize,omitempty"`
+	VolumeType          string `json:"VolumeType,omitempty"`
+	IOPS                *int64 `json:"Iops,omitempty"`
+	Encrypted           *bool  `json:"Encrypted,omitempty"`
+	KMSKeyID            string `json:"KmsKeyId,omitempty"`
+	DeleteOnTermination *bool  `json:"DeleteOnTermination,omitempty"`
+}
+
+// PlacementSpecification is where the instance is launched.
+type PlacementSpecification struct {
+	AvailabilityZone string `json:"AvailabilityZone,omitempty"`
+	Tenancy          string `json:"Tenancy,omitempty"`
+}
+
+// InstanceMarketOptions requests a spot instance.
+type InstanceMarketOptions struct {
+	MarketType  string       `json:"MarketType"`
+	SpotOptions *SpotOptions `json:"SpotOptions,omitempty"`
+}
+
+// SpotOptions configures a spot instance request.
+type SpotOptions struct {
+	MaxPrice string `json:"MaxPrice,omitempty"`
+}
+
+// TagSpecification tags a resource created on launch.
+type TagSpecification struct {
+	ResourceType string `json:"ResourceType"`
+	Tags         []Tag  `json:"Tags"`
+}
@@ -0,0 +1,6 @@
+package mapi
+
+import "k8s.io/apimachinery/pkg/runtime/schema"
+
+// GroupVersion is group version of the machine API types.
+var GroupVersion = schema.GroupVersion{Group: "machine.openshift.io", Version: "v1beta1"}
@@ -21,6 +21,31 @@ const (
 	MachineClusterIDLabel = "machine.openshift.io/cluster-api-cluster"
 )
 
+const (
+	// PhaseFailed indicates a state that will need to be fixed before progress can be made.
+	// Failed machines have to be deleted manually and re-created
+	PhaseFailed = "Failed"
+
+	// PhaseProvisioning indicates the instance does NOT exist.
+	// The machine has NOT been given a providerID or addresses.
+	// Provisioning implies that the Machine API is in the process of creating the instance.
+	PhaseProvisioning = "Provisioning"
+
+	// PhaseProvisioned indicates the instance exists.
+	// The machine has been given a providerID and addresses.
+	// The machine API successfully provisioned an instance which has not yet joined the cluster,
+	// as such, the machine has NOT yet been given a nodeRef.
+	PhaseProvisioned = "Provisioned"
+
+	// PhaseRunning indicates the instance exists and the node has joined the cluster.
+	// The machine has been given a providerID, addresses, and a nodeRef.
+	PhaseRunning = "Running"
+
+	// PhaseDeleting indicates the machine has a deletion timestamp and that the
+	// Machine API is now in the process of removing the machine from the cluster.
+	PhaseDeleting = "Deleting"
+)
+
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
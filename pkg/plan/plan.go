@@ -0,0 +1,488 @@
+// Package plan generates the ordered steps that migrate a MAPI machine set to
+// CAPI without losing capacity, and the steps that roll the migration back.
+package plan
+
+import (
+	"fmt"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/controller"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	mapiAutoscalerMinSizeAnnotation = "machine.openshift.io/cluster-api-autoscaler-node-group-min-size"
+	mapiAutoscalerMaxSizeAnnotation = "machine.openshift.io/cluster-api-autoscaler-node-group-max-size"
+	capiAutoscalerMinSizeAnnotation = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-min-size"
+	capiAutoscalerMaxSizeAnnotation = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size"
+
+	// machineAutoscalerOwnerAnnotation is set by the cluster-autoscaler-operator
+	// on machine sets scaled by a MachineAutoscaler, which restores the size
+	// annotations when they are removed.
+	machineAutoscalerOwnerAnnotation = "autoscaling.openshift.io/machineautoscaler"
+)
+
+// Action is what a step does to its object.
+type Action string
+
+const (
+	// ActionCreate creates the step manifest.
+	ActionCreate Action = "create"
+
+	// ActionPatch applies the step patch as a JSON merge patch.
+	ActionPatch Action = "patch"
+
+	// ActionWait blocks until the step condition is met.
+	ActionWait Action = "wait"
+
+	// ActionDelete deletes the object.
+	ActionDelete Action = "delete"
+)
+
+// ObjectReference identifies the object of a step.
+type ObjectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// WaitCondition is met once the field of the object equals the value. Omitted
+// fields are zero.
+type WaitCondition struct {
+	Field string `json:"field"`
+	Value int64  `json:"value"`
+}
+
+// Step is a single change to a single object.
+type Step struct {
+	Description string          `json:"description"`
+	Action      Action          `json:"action"`
+	Object      ObjectReference `json:"object"`
+
+	// Manifest is the object created by create steps.
+	Manifest map[string]interface{} `json:"manifest,omitempty"`
+
+	// Patch is the JSON merge patch of patch steps.
+	Patch map[string]interface{} `json:"patch,omitempty"`
+
+	// Condition is what wait steps wait for.
+	Condition *WaitCondition `json:"condition,omitempty"`
+}
+
+// Plan migrates a MAPI machine set to CAPI. Steps are applied in order, each
+// once the previous one succeeded. Rollback reverts a completed migration;
+// when rolling back a partial one, steps for objects that already are in the
+// desired state are skipped.
+type Plan struct {
+	// MachineSet is the namespace/name of the MAPI machine set.
+	MachineSet string `json:"machineSet"`
+
+	Steps    []Step `json:"steps"`
+	Rollback []Step `json:"rollback"`
+}
+
+// Options limits the capacity changes while machines are replaced, like the
+// rolling update strategy of deployments.
+type Options struct {
+	// MaxSurge is how many machines, or which percentage of the replicas
+	// rounded up, may exist on top of the replicas.
+	MaxSurge intstr.IntOrString
+
+	// MaxUnavailable is how many machines, or which percentage of the replicas
+	// rounded down, may be missing from the replicas.
+	MaxUnavailable intstr.IntOrString
+}
+
+// machineSets holds the objects a plan changes.
+type machineSets struct {
+	mapiMachineSet     *unstructured.Unstructured
+	capiMachineSet     *unstructured.Unstructured
+	awsMachineTemplate *unstructured.Unstructured
+	replicas           int64
+
+	// machineAutoscaler is the MachineAutoscaler retargeted at the CAPI
+	// machine set, if the MAPI machine set has one.
+	machineAutoscaler *unstructured.Unstructured
+}
+
+// New returns the plan migrating the MAPI machine set to the CAPI machine set
+// and AWSMachineTemplate it was converted to. A MachineAutoscaler scaling the
+// MAPI machine set has to be converted to one retargeted at the CAPI machine
+// set, which the plan creates once the machines moved. Other converted objects
+// are ignored.
+func New(mapiMachineSetFile []byte, converted [][]byte, options Options) (*Plan, error) {
+	sets, err := parseMachineSets(mapiMachineSetFile, converted)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSurge, err := intstr.GetScaledValueFromIntOrPercent(&options.MaxSurge, int(sets.replicas), true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max surge: %v", err)
+	}
+	maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(&options.MaxUnavailable, int(sets.replicas), false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max unavailable: %v", err)
+	}
+	if maxSurge < 0 || maxUnavailable < 0 {
+		return nil, fmt.Errorf("max surge and max unavailable can't be negative")
+	}
+	if maxSurge == 0 && maxUnavailable == 0 && sets.replicas > 0 {
+		return nil, fmt.Errorf("max surge and max unavailable can't both be zero")
+	}
+
+	mapiRef := reference(sets.mapiMachineSet)
+	capiRef := reference(sets.capiMachineSet)
+	templateRef := reference(sets.awsMachineTemplate)
+	mapiAutoscaling := autoscalerAnnotations(sets.mapiMachineSet, mapiAutoscalerMinSizeAnnotation, mapiAutoscalerMaxSizeAnnotation, machineAutoscalerOwnerAnnotation)
+	capiAutoscaling := autoscalerAnnotations(sets.capiMachineSet, capiAutoscalerMinSizeAnnotation, capiAutoscalerMaxSizeAnnotation, machineAutoscalerOwnerAnnotation)
+
+	plan := &Plan{
+		MachineSet: sets.mapiMachineSet.GetNamespace() + "/" + sets.mapiMachineSet.GetName(),
+	}
+
+	// The MachineAutoscaler is deleted first, it would restore the autoscaler
+	// annotations of the MAPI machine set. The MAPI machine set is only paused
+	// once it has no machines left, a paused machine set wouldn't scale down.
+	if sets.machineAutoscaler != nil {
+		plan.Steps = append(plan.Steps, Step{
+			Description: "Delete the MachineAutoscaler of the MAPI machine set",
+			Action:      ActionDelete,
+			Object:      reference(sets.machineAutoscaler),
+		})
+	}
+	plan.Steps = append(plan.Steps,
+		Step{
+			Description: "Stop the autoscaler from scaling the MAPI machine set",
+			Action:      ActionPatch,
+			Object:      mapiRef,
+			Patch:       annotationsPatch(mapiAutoscaling, nil, ""),
+		},
+		Step{
+			Description: "Create the AWSMachineTemplate",
+			Action:      ActionCreate,
+			Object:      templateRef,
+			Manifest:    manifest(sets.awsMachineTemplate),
+		},
+		Step{
+			Description: "Create the CAPI machine set without machines",
+			Action:      ActionCreate,
+			Object:      capiRef,
+			Manifest:    scaledToZero(sets.capiMachineSet, capiAutoscalerMinSizeAnnotation, capiAutoscalerMaxSizeAnnotation, machineAutoscalerOwnerAnnotation),
+		},
+	)
+	plan.Steps = append(plan.Steps, scaleSteps(capiRef, "CAPI", mapiRef, "MAPI", sets.replicas, int64(maxSurge), int64(maxUnavailable))...)
+	if sets.machineAutoscaler != nil {
+		plan.Steps = append(plan.Steps, Step{
+			Description: "Create the MachineAutoscaler of the CAPI machine set",
+			Action:      ActionCreate,
+			Object:      reference(sets.machineAutoscaler),
+			Manifest:    manifest(sets.machineAutoscaler),
+		})
+	} else if len(capiAutoscaling) > 0 {
+		plan.Steps = append(plan.Steps, Step{
+			Description: "Let the autoscaler scale the CAPI machine set",
+			Action:      ActionPatch,
+			Object:      capiRef,
+			Patch:       annotationsPatch(nil, capiAutoscaling, ""),
+		})
+	}
+	plan.Steps = append(plan.Steps,
+		Step{
+			Description: "Pause the MAPI machine set now that it has no machines",
+			Action:      ActionPatch,
+			Object:      mapiRef,
+			Patch:       annotationsPatch(nil, nil, controller.MAPIPausedAnnotation),
+		},
+		Step{
+			Description: "Delete the MAPI machine set",
+			Action:      ActionDelete,
+			Object:      mapiRef,
+		},
+	)
+
+	plan.Rollback = append(plan.Rollback,
+		Step{
+			Description: "Recreate the MAPI machine set without machines, unless it still exists",
+			Action:      ActionCreate,
+			Object:      mapiRef,
+			Manifest:    scaledToZero(sets.mapiMachineSet, mapiAutoscalerMinSizeAnnotation, mapiAutoscalerMaxSizeAnnotation, machineAutoscalerOwnerAnnotation, controller.MAPIPausedAnnotation),
+		},
+		Step{
+			Description: "Unpause the MAPI machine set so it can scale up",
+			Action:      ActionPatch,
+			Object:      mapiRef,
+			Patch:       annotationsPatch(map[string]interface{}{controller.MAPIPausedAnnotation: nil}, nil, ""),
+		},
+	)
+	if sets.machineAutoscaler != nil {
+		plan.Rollback = append(plan.Rollback, Step{
+			Description: "Delete the MachineAutoscaler of the CAPI machine set",
+			Action:      ActionDelete,
+			Object:      reference(sets.machineAutoscaler),
+		})
+	} else if len(capiAutoscaling) > 0 {
+		plan.Rollback = append(plan.Rollback, Step{
+			Description: "Stop the autoscaler from scaling the CAPI machine set",
+			Action:      ActionPatch,
+			Object:      capiRef,
+			Patch:       annotationsPatch(capiAutoscaling, nil, ""),
+		})
+	}
+	plan.Rollback = append(plan.Rollback, scaleSteps(mapiRef, "MAPI", capiRef, "CAPI", sets.replicas, int64(maxSurge), int64(maxUnavailable))...)
+	if sets.machineAutoscaler != nil {
+		plan.Rollback = append(plan.Rollback, Step{
+			Description: "Recreate the MachineAutoscaler of the MAPI machine set",
+			Action:      ActionCreate,
+			Object:      reference(sets.machineAutoscaler),
+			Manifest:    retargeted(sets.machineAutoscaler, sets.mapiMachineSet),
+		})
+	} else if len(mapiAutoscaling) > 0 {
+		plan.Rollback = append(plan.Rollback, Step{
+			Description: "Let the autoscaler scale the MAPI machine set",
+			Action:      ActionPatch,
+			Object:      mapiRef,
+			Patch:       annotationsPatch(nil, mapiAutoscaling, ""),
+		})
+	}
+	plan.Rollback = append(plan.Rollback,
+		Step{
+			Description: "Delete the CAPI machine set",
+			Action:      ActionDelete,
+			Object:      capiRef,
+		},
+		Step{
+			Description: "Delete the AWSMachineTemplate",
+			Action:      ActionDelete,
+			Object:      templateRef,
+		},
+	)
+
+	return plan, nil
+}
+
+// Marshal returns the plan as YAML.
+func (p *Plan) Marshal() ([]byte, error) {
+	return yaml.Marshal(p)
+}
+
+func parseMachineSets(mapiMachineSetFile []byte, converted [][]byte) (*machineSets, error) {
+	sets := &machineSets{}
+
+	var err error
+	sets.mapiMachineSet, err = unstructuredFromYAML(mapiMachineSetFile)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling machineset: %v", err)
+	}
+	if sets.mapiMachineSet.GroupVersionKind() != mapi.GroupVersion.WithKind("MachineSet") {
+		return nil, fmt.Errorf("expected a %s MachineSet, got %s %s", mapi.GroupVersion, sets.mapiMachineSet.GetAPIVersion(), sets.mapiMachineSet.GetKind())
+	}
+
+	// MAPI defaults replicas to 1.
+	sets.replicas = 1
+	if replicas, ok, err := unstructured.NestedInt64(sets.mapiMachineSet.Object, "spec", "replicas"); err != nil {
+		return nil, fmt.Errorf("error reading machineset replicas: %v", err)
+	} else if ok {
+		sets.replicas = replicas
+	}
+
+	for _, document := range converted {
+		object, err := unstructuredFromYAML(document)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling converted object: %v", err)
+		}
+		switch object.GroupVersionKind() {
+		case capi.GroupVersion.WithKind("MachineSet"):
+			sets.capiMachineSet = object
+		case capi.InfrastructureGroupVersion.WithKind("AWSMachineTemplate"):
+			sets.awsMachineTemplate = object
+		case schema.FromAPIVersionAndKind(mapi.MachineAutoscalerAPIVersion, mapi.MachineAutoscalerKind):
+			sets.machineAutoscaler = object
+		}
+	}
+	if sets.capiMachineSet == nil || sets.awsMachineTemplate == nil {
+		return nil, fmt.Errorf("converting machineset %s didn't produce a machineset and machine template", sets.mapiMachineSet.GetName())
+	}
+
+	// Without the retargeted MachineAutoscaler the plan couldn't stop the
+	// original one from scaling the MAPI machine set.
+	if owner, ok := sets.mapiMachineSet.GetAnnotations()[machineAutoscalerOwnerAnnotation]; ok && sets.machineAutoscaler == nil {
+		return nil, fmt.Errorf("machineset %s is scaled by machine autoscaler %s, convert it to a %s retargeted at the CAPI machineset to plan the migration", sets.mapiMachineSet.GetName(), owner, mapi.MachineAutoscalerKind)
+	}
+
+	objects := []*unstructured.Unstructured{sets.capiMachineSet, sets.awsMachineTemplate}
+	if sets.machineAutoscaler != nil {
+		objects = append(objects, sets.machineAutoscaler)
+	}
+	for _, object := range objects {
+		if object.GetNamespace() == "" {
+			object.SetNamespace(sets.mapiMachineSet.GetNamespace())
+		}
+	}
+
+	return sets, nil
+}
+
+// scaleSteps moves the replicas from the source to the target machine set,
+// keeping at most replicas+maxSurge machines and at least
+// replicas-maxUnavailable ready machines. Every scale up waits for the new
+// machines to get ready, every scale down for the old machines to be gone.
+func scaleSteps(target ObjectReference, targetAPI string, source ObjectReference, sourceAPI string, replicas, maxSurge, maxUnavailable int64) []Step {
+	steps := []Step{}
+
+	targetReplicas, sourceReplicas := int64(0), replicas
+	for targetReplicas < replicas || sourceReplicas > 0 {
+		if scaled := min(replicas, replicas+maxSurge-sourceReplicas); scaled > targetReplicas {
+			targetReplicas = scaled
+			steps = append(steps,
+				Step{
+					Description: fmt.Sprintf("Scale the %s machine set up to %d", targetAPI, targetReplicas),
+					Action:      ActionPatch,
+					Object:      target,
+					Patch:       replicasPatch(targetReplicas),
+				},
+				Step{
+					Description: fmt.Sprintf("Wait for %d ready %s machines", targetReplicas, targetAPI),
+					Action:      ActionWait,
+					Object:      target,
+					Condition:   &WaitCondition{Field: "status.readyReplicas", Value: targetReplicas},
+				},
+			)
+		}
+
+		if scaled := max(0, replicas-maxUnavailable-targetReplicas); scaled < sourceReplicas {
+			sourceReplicas = scaled
+			steps = append(steps,
+				Step{
+					Description: fmt.Sprintf("Scale the %s machine set down to %d", sourceAPI, sourceReplicas),
+					Action:      ActionPatch,
+					Object:      source,
+					Patch:       replicasPatch(sourceReplicas),
+				},
+				Step{
+					Description: fmt.Sprintf("Wait for %d %s machines to remain", sourceReplicas, sourceAPI),
+					Action:      ActionWait,
+					Object:      source,
+					Condition:   &WaitCondition{Field: "status.replicas", Value: sourceReplicas},
+				},
+			)
+		}
+	}
+
+	return steps
+}
+
+func reference(object *unstructured.Unstructured) ObjectReference {
+	return ObjectReference{
+		APIVersion: object.GetAPIVersion(),
+		Kind:       object.GetKind(),
+		Namespace:  object.GetNamespace(),
+		Name:       object.GetName(),
+	}
+}
+
+// manifest drops the status, the metadata managed by the API server and the
+// ownerReferences, whose uids are stale once the owner is recreated.
+func manifest(object *unstructured.Unstructured) map[string]interface{} {
+	object = object.DeepCopy()
+	delete(object.Object, "status")
+	for _, field := range []string{"managedFields", "resourceVersion", "uid", "creationTimestamp", "generation", "selfLink", "ownerReferences"} {
+		unstructured.RemoveNestedField(object.Object, "metadata", field)
+	}
+	return object.Object
+}
+
+// retargeted returns the manifest of the MachineAutoscaler scaling the machine
+// set instead.
+func retargeted(machineAutoscaler, machineSet *unstructured.Unstructured) map[string]interface{} {
+	object := manifest(machineAutoscaler)
+	_ = unstructured.SetNestedMap(object, map[string]interface{}{
+		"apiVersion": machineSet.GetAPIVersion(),
+		"kind":       machineSet.GetKind(),
+		"name":       machineSet.GetName(),
+	}, "spec", "scaleTargetRef")
+	return object
+}
+
+// scaledToZero returns the manifest of the machine set without replicas and
+// without the given annotations, e.g. the autoscaler ones.
+func scaledToZero(machineSet *unstructured.Unstructured, annotations ...string) map[string]interface{} {
+	object := manifest(machineSet)
+	_ = unstructured.SetNestedField(object, int64(0), "spec", "replicas")
+	for _, annotation := range annotations {
+		unstructured.RemoveNestedField(object, "metadata", "annotations", annotation)
+	}
+	if remaining, _, _ := unstructured.NestedMap(object, "metadata", "annotations"); len(remaining) == 0 {
+		unstructured.RemoveNestedField(object, "metadata", "annotations")
+	}
+	return object
+}
+
+func autoscalerAnnotations(machineSet *unstructured.Unstructured, keys ...string) map[string]interface{} {
+	annotations := map[string]interface{}{}
+	for _, key := range keys {
+		if value, ok := machineSet.GetAnnotations()[key]; ok {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// annotationsPatch removes the annotations of remove, sets the annotations
+// of set and sets the flag annotation to an empty value when not empty.
+func annotationsPatch(remove, set map[string]interface{}, flag string) map[string]interface{} {
+	annotations := map[string]interface{}{}
+	for key := range remove {
+		annotations[key] = nil
+	}
+	for key, value := range set {
+		annotations[key] = value
+	}
+	if flag != "" {
+		annotations[flag] = ""
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	}
+}
+
+func replicasPatch(replicas int64) map[string]interface{} {
+	return map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+		},
+	}
+}
+
+func unstructuredFromYAML(document []byte) (*unstructured.Unstructured, error) {
+	data, err := yaml.YAMLToJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	object := &unstructured.Unstructured{}
+	if err := object.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,408 @@
+package plan
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/controller"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/converter"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+const testMachineSet = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: worker-a
+  namespace: openshift-machine-api
+  annotations:
+    machine.openshift.io/cluster-api-autoscaler-node-group-min-size: "1"
+    machine.openshift.io/cluster-api-autoscaler-node-group-max-size: "6"
+  uid: 0f6e4c36-2f53-4a57-8f2c-58d7d2b7f0a4
+  resourceVersion: "1234"
+  ownerReferences:
+  - apiVersion: v1
+    kind: ConfigMap
+    name: owner
+    uid: 3c9e1d7a-5b2f-4e8d-a6c4-0f1e2d3c4b5a
+spec:
+  replicas: %d
+  selector:
+    matchLabels:
+      machine.openshift.io/cluster-api-cluster: cluster
+  template:
+    metadata:
+      labels:
+        machine.openshift.io/cluster-api-cluster: cluster
+    spec:
+      providerSpec:
+        value:
+          ami:
+            id: ami-0123
+          instanceType: m5.large
+          placement:
+            availabilityZone: us-east-1a
+            region: us-east-1
+          subnet:
+            id: subnet-0123
+status:
+  replicas: %[1]d
+`
+
+func newTestPlan(g *WithT, replicas int, maxSurge, maxUnavailable string) (*Plan, error) {
+	machineSet := []byte(fmt.Sprintf(testMachineSet, replicas))
+	awsConverter := &converter.AWSConverter{MachineSetFile: machineSet}
+	converted, err := awsConverter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	return New(machineSet, converted, Options{
+		MaxSurge:       intstr.Parse(maxSurge),
+		MaxUnavailable: intstr.Parse(maxUnavailable),
+	})
+}
+
+func descriptions(steps []Step) []string {
+	out := []string{}
+	for _, step := range steps {
+		out = append(out, step.Description)
+	}
+	return out
+}
+
+// scaling returns the replicas every scale step of the plan sets, e.g.
+// "CAPI=1".
+func scaling(steps []Step) []string {
+	out := []string{}
+	for _, step := range steps {
+		if replicas, ok, _ := unstructured.NestedInt64(step.Patch, "spec", "replicas"); ok {
+			api := "MAPI"
+			if step.Object.Kind == "MachineSet" && step.Object.APIVersion != "machine.openshift.io/v1beta1" {
+				api = "CAPI"
+			}
+			out = append(out, fmt.Sprintf("%s=%d", api, replicas))
+		}
+	}
+	return out
+}
+
+func TestNew(t *testing.T) {
+	g := NewWithT(t)
+
+	plan, err := newTestPlan(g, 2, "1", "0")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(plan.MachineSet).To(Equal("openshift-machine-api/worker-a"))
+
+	g.Expect(descriptions(plan.Steps)).To(Equal([]string{
+		"Stop the autoscaler from scaling the MAPI machine set",
+		"Create the AWSMachineTemplate",
+		"Create the CAPI machine set without machines",
+		"Scale the CAPI machine set up to 1",
+		"Wait for 1 ready CAPI machines",
+		"Scale the MAPI machine set down to 1",
+		"Wait for 1 MAPI machines to remain",
+		"Scale the CAPI machine set up to 2",
+		"Wait for 2 ready CAPI machines",
+		"Scale the MAPI machine set down to 0",
+		"Wait for 0 MAPI machines to remain",
+		"Let the autoscaler scale the CAPI machine set",
+		"Pause the MAPI machine set now that it has no machines",
+		"Delete the MAPI machine set",
+	}))
+	g.Expect(descriptions(plan.Rollback)).To(Equal([]string{
+		"Recreate the MAPI machine set without machines, unless it still exists",
+		"Unpause the MAPI machine set so it can scale up",
+		"Stop the autoscaler from scaling the CAPI machine set",
+		"Scale the MAPI machine set up to 1",
+		"Wait for 1 ready MAPI machines",
+		"Scale the CAPI machine set down to 1",
+		"Wait for 1 CAPI machines to remain",
+		"Scale the MAPI machine set up to 2",
+		"Wait for 2 ready MAPI machines",
+		"Scale the CAPI machine set down to 0",
+		"Wait for 0 CAPI machines to remain",
+		"Let the autoscaler scale the MAPI machine set",
+		"Delete the CAPI machine set",
+		"Delete the AWSMachineTemplate",
+	}))
+
+	g.Expect(plan.Steps[0].Object).To(Equal(ObjectReference{
+		APIVersion: "machine.openshift.io/v1beta1",
+		Kind:       "MachineSet",
+		Namespace:  "openshift-machine-api",
+		Name:       "worker-a",
+	}))
+	g.Expect(plan.Steps[0].Patch).To(Equal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				mapiAutoscalerMinSizeAnnotation: nil,
+				mapiAutoscalerMaxSizeAnnotation: nil,
+			},
+		},
+	}))
+
+	capiMachineSet := &unstructured.Unstructured{Object: plan.Steps[2].Manifest}
+	g.Expect(capiMachineSet.GetNamespace()).To(Equal("openshift-machine-api"))
+	g.Expect(capiMachineSet.GetAnnotations()).NotTo(HaveKey(capiAutoscalerMinSizeAnnotation))
+	replicas, _, _ := unstructured.NestedInt64(capiMachineSet.Object, "spec", "replicas")
+	g.Expect(replicas).To(BeZero())
+	g.Expect(capiMachineSet.Object).NotTo(HaveKey("status"))
+
+	g.Expect(plan.Steps[4].Condition).To(Equal(&WaitCondition{Field: "status.readyReplicas", Value: 1}))
+	g.Expect(plan.Steps[6].Condition).To(Equal(&WaitCondition{Field: "status.replicas", Value: 1}))
+	g.Expect(plan.Steps[11].Patch).To(Equal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				capiAutoscalerMinSizeAnnotation: "1",
+				capiAutoscalerMaxSizeAnnotation: "6",
+			},
+		},
+	}))
+
+	// MAPI is paused once it has no machines, right before it's deleted.
+	g.Expect(plan.Steps[12].Patch).To(Equal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				controller.MAPIPausedAnnotation: "",
+			},
+		},
+	}))
+
+	// The recreated MAPI machine set isn't paused, it has to scale up.
+	mapiMachineSet := &unstructured.Unstructured{Object: plan.Rollback[0].Manifest}
+	g.Expect(mapiMachineSet.GetAnnotations()).To(BeEmpty())
+	g.Expect(mapiMachineSet.GetUID()).To(BeEmpty())
+	g.Expect(mapiMachineSet.GetResourceVersion()).To(BeEmpty())
+	g.Expect(mapiMachineSet.GetOwnerReferences()).To(BeEmpty())
+	g.Expect(mapiMachineSet.Object).NotTo(HaveKey("status"))
+	g.Expect(plan.Rollback[1].Patch).To(Equal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				controller.MAPIPausedAnnotation: nil,
+			},
+		},
+	}))
+	g.Expect(plan.Rollback[11].Patch).To(Equal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				mapiAutoscalerMinSizeAnnotation: "1",
+				mapiAutoscalerMaxSizeAnnotation: "6",
+			},
+		},
+	}))
+}
+
+const testMachineAutoscaler = `apiVersion: autoscaling.openshift.io/v1beta1
+kind: MachineAutoscaler
+metadata:
+  name: worker-a
+  namespace: openshift-machine-api
+spec:
+  minReplicas: 1
+  maxReplicas: 6
+  scaleTargetRef:
+    apiVersion: machine.openshift.io/v1beta1
+    kind: MachineSet
+    name: worker-a
+`
+
+// newTestMachineSetWithAutoscaler returns the test machine set with the
+// annotation the cluster-autoscaler-operator sets on machine sets scaled by a
+// MachineAutoscaler.
+func newTestMachineSetWithAutoscaler(g *WithT) []byte {
+	object, err := unstructuredFromYAML([]byte(fmt.Sprintf(testMachineSet, 1)))
+	g.Expect(err).NotTo(HaveOccurred())
+	annotations := object.GetAnnotations()
+	annotations[machineAutoscalerOwnerAnnotation] = "openshift-machine-api/worker-a"
+	object.SetAnnotations(annotations)
+	data, err := yaml.Marshal(object.Object)
+	g.Expect(err).NotTo(HaveOccurred())
+	return data
+}
+
+func TestNewMachineAutoscaler(t *testing.T) {
+	g := NewWithT(t)
+
+	machineSet := newTestMachineSetWithAutoscaler(g)
+	awsConverter := &converter.AWSConverter{
+		MachineSetFile:          machineSet,
+		MachineAutoscalerFile:   []byte(testMachineAutoscaler),
+		MachineAutoscalerOutput: converter.MachineAutoscalerOutputResource,
+	}
+	converted, err := awsConverter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	plan, err := New(machineSet, converted, Options{MaxSurge: intstr.FromInt(1)})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// The MachineAutoscaler would restore the autoscaler annotations of the
+	// MAPI machine set, it's deleted and recreated for the CAPI machine set.
+	g.Expect(descriptions(plan.Steps)).To(Equal([]string{
+		"Delete the MachineAutoscaler of the MAPI machine set",
+		"Stop the autoscaler from scaling the MAPI machine set",
+		"Create the AWSMachineTemplate",
+		"Create the CAPI machine set without machines",
+		"Scale the CAPI machine set up to 1",
+		"Wait for 1 ready CAPI machines",
+		"Scale the MAPI machine set down to 0",
+		"Wait for 0 MAPI machines to remain",
+		"Create the MachineAutoscaler of the CAPI machine set",
+		"Pause the MAPI machine set now that it has no machines",
+		"Delete the MAPI machine set",
+	}))
+	g.Expect(descriptions(plan.Rollback)).To(Equal([]string{
+		"Recreate the MAPI machine set without machines, unless it still exists",
+		"Unpause the MAPI machine set so it can scale up",
+		"Delete the MachineAutoscaler of the CAPI machine set",
+		"Scale the MAPI machine set up to 1",
+		"Wait for 1 ready MAPI machines",
+		"Scale the CAPI machine set down to 0",
+		"Wait for 0 CAPI machines to remain",
+		"Recreate the MachineAutoscaler of the MAPI machine set",
+		"Delete the CAPI machine set",
+		"Delete the AWSMachineTemplate",
+	}))
+
+	machineAutoscalerRef := ObjectReference{
+		APIVersion: "autoscaling.openshift.io/v1beta1",
+		Kind:       "MachineAutoscaler",
+		Namespace:  "openshift-machine-api",
+		Name:       "worker-a",
+	}
+	g.Expect(plan.Steps[0].Object).To(Equal(machineAutoscalerRef))
+	g.Expect(plan.Steps[1].Patch).To(Equal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				mapiAutoscalerMinSizeAnnotation:  nil,
+				mapiAutoscalerMaxSizeAnnotation:  nil,
+				machineAutoscalerOwnerAnnotation: nil,
+			},
+		},
+	}))
+	capiMachineSet := &unstructured.Unstructured{Object: plan.Steps[3].Manifest}
+	g.Expect(capiMachineSet.GetAnnotations()).NotTo(HaveKey(machineAutoscalerOwnerAnnotation))
+
+	g.Expect(plan.Steps[8].Object).To(Equal(machineAutoscalerRef))
+	targetRef, _, _ := unstructured.NestedMap(plan.Steps[8].Manifest, "spec", "scaleTargetRef")
+	g.Expect(targetRef).To(Equal(map[string]interface{}{
+		"apiVersion": "cluster.x-k8s.io/v1alpha4",
+		"kind":       "MachineSet",
+		"name":       "worker-a",
+	}))
+
+	g.Expect(plan.Rollback[2].Object).To(Equal(machineAutoscalerRef))
+	g.Expect(plan.Rollback[7].Object).To(Equal(machineAutoscalerRef))
+	targetRef, _, _ = unstructured.NestedMap(plan.Rollback[7].Manifest, "spec", "scaleTargetRef")
+	g.Expect(targetRef).To(Equal(map[string]interface{}{
+		"apiVersion": "machine.openshift.io/v1beta1",
+		"kind":       "MachineSet",
+		"name":       "worker-a",
+	}))
+	maxReplicas, _, _ := unstructured.NestedInt64(plan.Rollback[7].Manifest, "spec", "maxReplicas")
+	g.Expect(maxReplicas).To(Equal(int64(6)))
+}
+
+func TestNewRequiresRetargetedMachineAutoscaler(t *testing.T) {
+	g := NewWithT(t)
+
+	machineSet := newTestMachineSetWithAutoscaler(g)
+	awsConverter := &converter.AWSConverter{MachineSetFile: machineSet}
+	converted, err := awsConverter.ToCAPI()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = New(machineSet, converted, Options{MaxSurge: intstr.FromInt(1)})
+	g.Expect(err).To(MatchError("machineset worker-a is scaled by machine autoscaler openshift-machine-api/worker-a, convert it to a MachineAutoscaler retargeted at the CAPI machineset to plan the migration"))
+}
+
+func TestNewScaling(t *testing.T) {
+	testCases := []struct {
+		name           string
+		replicas       int
+		maxSurge       string
+		maxUnavailable string
+		steps          []string
+		rollback       []string
+		err            string
+	}{
+		{
+			name:           "surge only",
+			replicas:       3,
+			maxSurge:       "1",
+			maxUnavailable: "0",
+			steps:          []string{"CAPI=1", "MAPI=2", "CAPI=2", "MAPI=1", "CAPI=3", "MAPI=0"},
+			rollback:       []string{"MAPI=1", "CAPI=2", "MAPI=2", "CAPI=1", "MAPI=3", "CAPI=0"},
+		},
+		{
+			name:           "unavailable only",
+			replicas:       3,
+			maxSurge:       "0",
+			maxUnavailable: "1",
+			steps:          []string{"MAPI=2", "CAPI=1", "MAPI=1", "CAPI=2", "MAPI=0", "CAPI=3"},
+			rollback:       []string{"CAPI=2", "MAPI=1", "CAPI=1", "MAPI=2", "CAPI=0", "MAPI=3"},
+		},
+		{
+			name:           "surge and unavailable",
+			replicas:       4,
+			maxSurge:       "2",
+			maxUnavailable: "1",
+			steps:          []string{"CAPI=2", "MAPI=1", "CAPI=4", "MAPI=0"},
+			rollback:       []string{"MAPI=2", "CAPI=1", "MAPI=4", "CAPI=0"},
+		},
+		{
+			name:           "percentages round surge up and unavailable down",
+			replicas:       3,
+			maxSurge:       "50%",
+			maxUnavailable: "50%",
+			steps:          []string{"CAPI=2", "MAPI=0", "CAPI=3"},
+			rollback:       []string{"MAPI=2", "CAPI=0", "MAPI=3"},
+		},
+		{
+			name:           "no replicas",
+			replicas:       0,
+			maxSurge:       "0",
+			maxUnavailable: "0",
+			steps:          []string{},
+			rollback:       []string{},
+		},
+		{
+			name:           "no surge and no unavailable machines",
+			replicas:       3,
+			maxSurge:       "0",
+			maxUnavailable: "10%",
+			err:            "max surge and max unavailable can't both be zero",
+		},
+		{
+			name:           "invalid percentage",
+			replicas:       3,
+			maxSurge:       "ten%",
+			maxUnavailable: "0",
+			err:            "invalid max surge",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			plan, err := newTestPlan(g, tc.replicas, tc.maxSurge, tc.maxUnavailable)
+			if tc.err != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.err)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(scaling(plan.Steps)).To(Equal(tc.steps))
+			g.Expect(scaling(plan.Rollback)).To(Equal(tc.rollback))
+		})
+	}
+}
+
+func TestNewRequiresMachineSetAndTemplate(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := New([]byte(fmt.Sprintf(testMachineSet, 1)), nil, Options{MaxSurge: intstr.FromInt(1)})
+	g.Expect(err).To(MatchError("converting machineset worker-a didn't produce a machineset and machine template"))
+
+	_, err = New([]byte("apiVersion: cluster.x-k8s.io/v1alpha4\nkind: MachineSet\n"), nil, Options{MaxSurge: intstr.FromInt(1)})
+	g.Expect(err).To(MatchError("expected a machine.openshift.io/v1beta1 MachineSet, got cluster.x-k8s.io/v1alpha4 MachineSet"))
+}
@@ -0,0 +1,322 @@
+// Package plugin implements kubectl-mapi2capi, which reads MAPI machine sets
+// from a live cluster, converts them and prints, diffs or server-side applies
+// the CAPI objects.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/converter"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/diff"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// FieldManager owns the fields of the objects the plugin applies.
+const FieldManager = "kubectl-mapi2capi"
+
+var awsMachineTemplateGVK = capi.InfrastructureGroupVersion.WithKind("AWSMachineTemplate")
+
+// Mode selects what happens to the converted objects.
+type Mode string
+
+const (
+	// ModePrint writes the converted objects as a multi-document YAML stream.
+	ModePrint Mode = "print"
+
+	// ModeDiff writes a unified diff between the live and converted objects.
+	ModeDiff Mode = "diff"
+
+	// ModeApply server-side applies the converted objects. Like in the other
+	// modes, CAPI machine sets carry capi.PausedAnnotation. Machine templates
+	// are immutable in CAPA, a template whose spec changed is deleted and
+	// recreated, like the mirror controller does.
+	ModeApply Mode = "apply"
+)
+
+var (
+	mapiMachineSetResource = mapi.GroupVersion.WithResource("machinesets")
+	capiMachineSetGVK      = capi.GroupVersion.WithKind("MachineSet")
+)
+
+// Options configures a plugin run.
+type Options struct {
+	// Client reads the MAPI machine sets and reads or applies the CAPI objects.
+	Client dynamic.Interface
+
+	// Namespace holds the MAPI machine sets.
+	Namespace string
+
+	// TargetNamespace is the namespace of the converted objects. Defaults to
+	// the namespace of the MAPI machine set.
+	TargetNamespace string
+
+	// Names are the machine sets to convert, all machine sets of the namespace
+	// when empty.
+	Names []string
+
+	// Converter holds the conversion options, it is copied for every machine
+	// set. Its input files are ignored.
+	Converter converter.AWSConverter
+
+	Mode Mode
+
+	// ForceConflicts takes over fields owned by other field managers on apply.
+	ForceConflicts bool
+
+	// Out receives the converted objects, diffs and applied objects, ErrOut
+	// the conversion reports.
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+// Run converts the machine sets and handles the converted objects according
+// to the mode.
+func Run(ctx context.Context, options Options) error {
+	switch options.Mode {
+	case ModePrint, ModeDiff, ModeApply:
+	default:
+		return fmt.Errorf("unknown mode %q, must be one of print, diff or apply", options.Mode)
+	}
+
+	machineSets, err := getMachineSets(ctx, options.Client, options.Namespace, options.Names)
+	if err != nil {
+		return err
+	}
+
+	for _, machineSet := range machineSets {
+		objects, err := convertMachineSet(options, machineSet)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range objects {
+			switch options.Mode {
+			case ModePrint:
+				err = printObject(options.Out, object)
+			case ModeDiff:
+				err = diffObject(ctx, options.Client, options.Out, object)
+			case ModeApply:
+				err = applyObject(ctx, options.Client, options.Out, object, options.ForceConflicts)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getMachineSets returns the named machine sets, or every machine set of the
+// namespace sorted by name.
+func getMachineSets(ctx context.Context, client dynamic.Interface, namespace string, names []string) ([]unstructured.Unstructured, error) {
+	machineSets := client.Resource(mapiMachineSetResource).Namespace(namespace)
+
+	if len(names) == 0 {
+		list, err := machineSets.List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing machinesets in namespace %s: %v", namespace, err)
+		}
+		sort.Slice(list.Items, func(i, j int) bool {
+			return list.Items[i].GetName() < list.Items[j].GetName()
+		})
+		return list.Items, nil
+	}
+
+	out := []unstructured.Unstructured{}
+	for _, name := range names {
+		machineSet, err := machineSets.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting machineset %s/%s: %v", namespace, name, err)
+		}
+		out = append(out, *machineSet)
+	}
+	return out, nil
+}
+
+func convertMachineSet(options Options, machineSet unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	raw, err := machineSet.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling machineset: %v", err)
+	}
+
+	awsConverter := options.Converter
+	awsConverter.MachineSetFile = raw
+	awsConverter.MachineTemplateFile = nil
+	awsConverter.OutputFormat = converter.OutputFormatYAML
+
+	out, err := awsConverter.ToCAPI()
+	if err != nil {
+		return nil, fmt.Errorf("error converting machineset %s/%s: %v", machineSet.GetNamespace(), machineSet.GetName(), err)
+	}
+
+	report := awsConverter.Report()
+	if len(report.Entries) > 0 && options.ErrOut != nil {
+		fmt.Fprintf(options.ErrOut, "Some fields of machineset %s/%s could not be converted faithfully:\n%s", machineSet.GetNamespace(), machineSet.GetName(), report.String())
+	}
+
+	objects := []*unstructured.Unstructured{}
+	for _, document := range out {
+		object, err := unstructuredFromYAML(document)
+		if err != nil {
+			return nil, err
+		}
+		if options.TargetNamespace != "" {
+			object.SetNamespace(options.TargetNamespace)
+		}
+		if object.GetNamespace() == "" {
+			object.SetNamespace(machineSet.GetNamespace())
+		}
+		if object.GroupVersionKind() == capiMachineSetGVK {
+			// The MAPI machine set still runs the machines, the CAPI one mustn't
+			// create its replicas next to them until the migration unpauses it.
+			annotations := object.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[capi.PausedAnnotation] = ""
+			object.SetAnnotations(annotations)
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func printObject(out io.Writer, object *unstructured.Unstructured) error {
+	document, err := yaml.Marshal(object.Object)
+	if err != nil {
+		return fmt.Errorf("error marshalling %s: %v", describe(object), err)
+	}
+	_, err = fmt.Fprintf(out, "---\n%s", document)
+	return err
+}
+
+// diffObject writes a unified diff from the live object to the converted one.
+// Objects that don't exist yet are diffed against an empty document, unchanged
+// objects are skipped.
+func diffObject(ctx context.Context, client dynamic.Interface, out io.Writer, object *unstructured.Unstructured) error {
+	live, err := resourceClient(client, object).Get(ctx, object.GetName(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting %s: %v", describe(object), err)
+	}
+
+	var liveDocument []byte
+	if err == nil {
+		if liveDocument, err = normalizedYAML(live); err != nil {
+			return err
+		}
+	}
+	convertedDocument, err := normalizedYAML(object)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(liveDocument, convertedDocument) {
+		return nil
+	}
+
+	return difflib.WriteUnifiedDiff(out, difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(liveDocument)),
+		B:        difflib.SplitLines(string(convertedDocument)),
+		FromFile: "live/" + describe(object),
+		ToFile:   "converted/" + describe(object),
+		Context:  3,
+	})
+}
+
+func applyObject(ctx context.Context, client dynamic.Interface, out io.Writer, object *unstructured.Unstructured, force bool) error {
+	if object.GroupVersionKind() == awsMachineTemplateGVK {
+		if err := deleteChangedObject(ctx, client, out, object); err != nil {
+			return err
+		}
+	}
+
+	data, err := object.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("error marshalling %s: %v", describe(object), err)
+	}
+
+	_, err = resourceClient(client, object).Patch(ctx, object.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        &force,
+	})
+	if err != nil {
+		return fmt.Errorf("error applying %s: %v", describe(object), err)
+	}
+
+	_, err = fmt.Fprintf(out, "%s serverside-applied\n", describe(object))
+	return err
+}
+
+// deleteChangedObject deletes the live object when its spec differs from the
+// converted one, so the following apply recreates it.
+func deleteChangedObject(ctx context.Context, client dynamic.Interface, out io.Writer, object *unstructured.Unstructured) error {
+	live, err := resourceClient(client, object).Get(ctx, object.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting %s: %v", describe(object), err)
+	}
+	if !diff.SpecChanged(object, live) {
+		return nil
+	}
+
+	if err := resourceClient(client, object).Delete(ctx, object.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting %s: %v", describe(object), err)
+	}
+	_, err = fmt.Fprintf(out, "%s deleted\n", describe(object))
+	return err
+}
+
+// resourceClient guesses the resource of the object from its kind. All
+// converted kinds are namespaced and use the regular plural.
+func resourceClient(client dynamic.Interface, object *unstructured.Unstructured) dynamic.ResourceInterface {
+	resource, _ := meta.UnsafeGuessKindToResource(object.GroupVersionKind())
+	return client.Resource(resource).Namespace(object.GetNamespace())
+}
+
+// normalizedYAML drops the status and the metadata managed by the API server,
+// so live and converted objects only differ where the conversion would change
+// something.
+func normalizedYAML(object *unstructured.Unstructured) ([]byte, error) {
+	object = object.DeepCopy()
+	delete(object.Object, "status")
+	for _, field := range []string{"managedFields", "resourceVersion", "uid", "creationTimestamp", "generation", "selfLink"} {
+		unstructured.RemoveNestedField(object.Object, "metadata", field)
+	}
+
+	document, err := yaml.Marshal(object.Object)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling %s: %v", describe(object), err)
+	}
+	return document, nil
+}
+
+func unstructuredFromYAML(document []byte) (*unstructured.Unstructured, error) {
+	data, err := yaml.YAMLToJSON(document)
+	if err != nil {
+		return nil, fmt.Errorf("error converting object to json: %v", err)
+	}
+	object := &unstructured.Unstructured{}
+	if err := object.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("error unmarshalling converted object: %v", err)
+	}
+	return object, nil
+}
+
+func describe(object *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s/%s/%s", object.GetKind(), object.GetNamespace(), object.GetName())
+}
@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+const testMachineSet = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: %s
+  namespace: openshift-machine-api
+  ownerReferences:
+  - apiVersion: v1
+    kind: ConfigMap
+    name: owner
+    uid: 5d1c0f6a-3b2e-4a7d-9c8f-1e2d3c4b5a69
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      machine.openshift.io/cluster-api-cluster: cluster
+  template:
+    metadata:
+      labels:
+        machine.openshift.io/cluster-api-cluster: cluster
+    spec:
+      providerSpec:
+        value:
+          ami:
+            id: ami-0123
+          instanceType: m5.large
+          placement:
+            availabilityZone: us-east-1a
+            region: us-east-1
+          subnet:
+            id: subnet-0123
+`
+
+var awsMachineTemplateResource = capi.InfrastructureGroupVersion.WithResource("awsmachinetemplates")
+
+func newTestMachineSet(g *WithT, name string) *unstructured.Unstructured {
+	object, err := unstructuredFromYAML([]byte(fmt.Sprintf(testMachineSet, name)))
+	g.Expect(err).NotTo(HaveOccurred())
+	return object
+}
+
+func newTestClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		mapiMachineSetResource:                        "MachineSetList",
+		capi.GroupVersion.WithResource("machinesets"): "MachineSetList",
+		awsMachineTemplateResource:                    "AWSMachineTemplateList",
+	}, objects...)
+}
+
+func TestRunPrint(t *testing.T) {
+	g := NewWithT(t)
+
+	out := &bytes.Buffer{}
+	client := newTestClient(newTestMachineSet(g, "worker-b"), newTestMachineSet(g, "worker-a"))
+	err := Run(context.Background(), Options{
+		Client:          client,
+		Namespace:       "openshift-machine-api",
+		TargetNamespace: "capi",
+		Mode:            ModePrint,
+		Out:             out,
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	documents := bytes.Split(out.Bytes(), []byte("---\n"))[1:]
+	g.Expect(documents).To(HaveLen(4))
+
+	names := []string{}
+	for _, document := range documents {
+		object := &unstructured.Unstructured{}
+		g.Expect(yaml.Unmarshal(document, &object.Object)).To(Succeed())
+		g.Expect(object.GetNamespace()).To(Equal("capi"))
+		names = append(names, object.GetKind()+"/"+object.GetName())
+	}
+	g.Expect(names).To(Equal([]string{
+		"AWSMachineTemplate/worker-a",
+		"MachineSet/worker-a",
+		"AWSMachineTemplate/worker-b",
+		"MachineSet/worker-b",
+	}))
+}
+
+func TestRunNamedMachineSets(t *testing.T) {
+	g := NewWithT(t)
+
+	out := &bytes.Buffer{}
+	client := newTestClient(newTestMachineSet(g, "worker-a"))
+	err := Run(context.Background(), Options{
+		Client:    client,
+		Namespace: "openshift-machine-api",
+		Names:     []string{"worker-a", "worker-missing"},
+		Mode:      ModePrint,
+		Out:       out,
+	})
+	g.Expect(err).To(MatchError(ContainSubstring("error getting machineset openshift-machine-api/worker-missing")))
+}
+
+func TestRunDiff(t *testing.T) {
+	g := NewWithT(t)
+
+	out := &bytes.Buffer{}
+	client := newTestClient(newTestMachineSet(g, "worker-a"))
+	options := Options{
+		Client:    client,
+		Namespace: "openshift-machine-api",
+		Mode:      ModeDiff,
+		Out:       out,
+	}
+	g.Expect(Run(context.Background(), options)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("--- live/AWSMachineTemplate/openshift-machine-api/worker-a"))
+	g.Expect(out.String()).To(ContainSubstring("+++ converted/MachineSet/openshift-machine-api/worker-a"))
+	g.Expect(out.String()).To(ContainSubstring("+  replicas: 2"))
+
+	// Create the converted objects, with server managed metadata and status.
+	printed := &bytes.Buffer{}
+	options.Mode = ModePrint
+	options.Out = printed
+	g.Expect(Run(context.Background(), options)).To(Succeed())
+	for _, document := range bytes.Split(printed.Bytes(), []byte("---\n"))[1:] {
+		object, err := unstructuredFromYAML(document)
+		g.Expect(err).NotTo(HaveOccurred())
+		object.SetUID(types.UID("uid"))
+		object.SetResourceVersion("7")
+		object.Object["status"] = map[string]interface{}{"replicas": int64(2)}
+		_, err = resourceClient(client, object).Create(context.Background(), object, metav1.CreateOptions{})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	out.Reset()
+	options.Mode = ModeDiff
+	options.Out = out
+	g.Expect(Run(context.Background(), options)).To(Succeed())
+	g.Expect(out.String()).To(BeEmpty())
+
+	machineSet := newTestMachineSet(g, "worker-a")
+	g.Expect(unstructured.SetNestedField(machineSet.Object, int64(3), "spec", "replicas")).To(Succeed())
+	_, err := client.Resource(mapiMachineSetResource).Namespace("openshift-machine-api").Update(context.Background(), machineSet, metav1.UpdateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(Run(context.Background(), options)).To(Succeed())
+	g.Expect(out.String()).NotTo(ContainSubstring("AWSMachineTemplate"))
+	g.Expect(out.String()).To(ContainSubstring("-  replicas: 2\n+  replicas: 3\n"))
+}
+
+func TestRunApply(t *testing.T) {
+	g := NewWithT(t)
+
+	out := &bytes.Buffer{}
+	client := newTestClient(newTestMachineSet(g, "worker-a"))
+
+	// The fake object tracker doesn't implement server-side apply.
+	patches := []clienttesting.PatchActionImpl{}
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchActionImpl)
+		patches = append(patches, patch)
+		object := &unstructured.Unstructured{}
+		return true, object, object.UnmarshalJSON(patch.GetPatch())
+	})
+
+	err := Run(context.Background(), Options{
+		Client:         client,
+		Namespace:      "openshift-machine-api",
+		Mode:           ModeApply,
+		ForceConflicts: true,
+		Out:            out,
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(out.String()).To(Equal("AWSMachineTemplate/openshift-machine-api/worker-a serverside-applied\nMachineSet/openshift-machine-api/worker-a serverside-applied\n"))
+
+	g.Expect(patches).To(HaveLen(2))
+	g.Expect(patches[0].GetResource()).To(Equal(awsMachineTemplateResource))
+	g.Expect(patches[1].GetResource()).To(Equal(capi.GroupVersion.WithResource("machinesets")))
+	for _, patch := range patches {
+		g.Expect(patch.GetPatchType()).To(Equal(types.ApplyPatchType))
+		g.Expect(patch.GetNamespace()).To(Equal("openshift-machine-api"))
+		g.Expect(patch.GetName()).To(Equal("worker-a"))
+	}
+
+	// The CAPI machine set is applied paused, without ownerReferences.
+	capiMachineSet := &unstructured.Unstructured{}
+	g.Expect(capiMachineSet.UnmarshalJSON(patches[1].GetPatch())).To(Succeed())
+	g.Expect(capiMachineSet.GetAnnotations()).To(HaveKeyWithValue(capi.PausedAnnotation, ""))
+	g.Expect(capiMachineSet.GetOwnerReferences()).To(BeEmpty())
+	replicas, _, _ := unstructured.NestedInt64(capiMachineSet.Object, "spec", "replicas")
+	g.Expect(replicas).To(Equal(int64(2)))
+}
+
+func TestRunApplyChangedMachineTemplate(t *testing.T) {
+	g := NewWithT(t)
+
+	out := &bytes.Buffer{}
+	client := newTestClient(newTestMachineSet(g, "worker-a"))
+	options := Options{
+		Client:    client,
+		Namespace: "openshift-machine-api",
+		Mode:      ModePrint,
+		Out:       out,
+	}
+
+	// Create the converted objects, as a first apply would.
+	g.Expect(Run(context.Background(), options)).To(Succeed())
+	for _, document := range bytes.Split(out.Bytes(), []byte("---\n"))[1:] {
+		object, err := unstructuredFromYAML(document)
+		g.Expect(err).NotTo(HaveOccurred())
+		_, err = resourceClient(client, object).Create(context.Background(), object, metav1.CreateOptions{})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	// The fake object tracker doesn't implement server-side apply.
+	patches := []clienttesting.PatchActionImpl{}
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchActionImpl)
+		patches = append(patches, patch)
+		object := &unstructured.Unstructured{}
+		return true, object, object.UnmarshalJSON(patch.GetPatch())
+	})
+	deletes := func() []string {
+		names := []string{}
+		for _, action := range client.Actions() {
+			if action, ok := action.(clienttesting.DeleteActionImpl); ok {
+				names = append(names, action.GetResource().Resource+"/"+action.GetName())
+			}
+		}
+		return names
+	}
+
+	out.Reset()
+	options.Mode = ModeApply
+	g.Expect(Run(context.Background(), options)).To(Succeed())
+	g.Expect(deletes()).To(BeEmpty())
+
+	// CAPA rejects spec updates of machine templates, a changed template is
+	// deleted before it is applied again.
+	machineSet := newTestMachineSet(g, "worker-a")
+	g.Expect(unstructured.SetNestedField(machineSet.Object, "m5.xlarge", "spec", "template", "spec", "providerSpec", "value", "instanceType")).To(Succeed())
+	_, err := client.Resource(mapiMachineSetResource).Namespace("openshift-machine-api").Update(context.Background(), machineSet, metav1.UpdateOptions{})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	out.Reset()
+	patches = patches[:0]
+	g.Expect(Run(context.Background(), options)).To(Succeed())
+	g.Expect(out.String()).To(Equal("AWSMachineTemplate/openshift-machine-api/worker-a deleted\nAWSMachineTemplate/openshift-machine-api/worker-a serverside-applied\nMachineSet/openshift-machine-api/worker-a serverside-applied\n"))
+	g.Expect(deletes()).To(Equal([]string{"awsmachinetemplates/worker-a"}))
+
+	_, err = client.Resource(awsMachineTemplateResource).Namespace("openshift-machine-api").Get(context.Background(), "worker-a", metav1.GetOptions{})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(patches).To(HaveLen(2))
+	g.Expect(patches[0].GetResource()).To(Equal(awsMachineTemplateResource))
+	template := &unstructured.Unstructured{}
+	g.Expect(template.UnmarshalJSON(patches[0].GetPatch())).To(Succeed())
+	instanceType, _, _ := unstructured.NestedString(template.Object, "spec", "template", "spec", "instanceType")
+	g.Expect(instanceType).To(Equal("m5.xlarge"))
+}
+
+func TestRunUnknownMode(t *testing.T) {
+	g := NewWithT(t)
+
+	err := Run(context.Background(), Options{Client: newTestClient(), Mode: "sync"})
+	g.Expect(err).To(MatchError(`unknown mode "sync", must be one of print, diff or apply`))
+}
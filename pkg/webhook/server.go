@@ -0,0 +1,287 @@
+// Package webhook serves the converter over the CRD conversion webhook
+// protocol, so MAPI machine sets can be viewed as CAPI machine sets and
+// AWSMachineTemplates and the other way around.
+//
+// The endpoint is experimental and can't be registered as the conversion
+// webhook of a CRD. The API server only asks a CRD's webhook to convert
+// between versions of that CRD, and MAPI and CAPI machine sets are different
+// CRDs in different groups, so a cluster never sends it these requests.
+// Clients, e.g. migration tooling, post ConversionReviews to it directly.
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/converter"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConvertPath serves ConversionReview requests.
+	ConvertPath = "/convert"
+
+	// HealthzPath reports whether the process is alive.
+	HealthzPath = "/healthz"
+
+	// ReadyzPath reports whether the server accepts conversion requests.
+	ReadyzPath = "/readyz"
+
+	machineSetKind         = "MachineSet"
+	awsMachineTemplateKind = "AWSMachineTemplate"
+
+	// maxRequestBytes mirrors the request size limit of the API server.
+	maxRequestBytes = 3 * 1024 * 1024
+)
+
+// MachineTemplateGetter returns the AWSMachineTemplate manifest a CAPI machine
+// set references. Converting CAPI machine sets to MAPI needs it, the
+// ConversionReview only carries the machine set.
+type MachineTemplateGetter func(namespace, name string) ([]byte, error)
+
+// Server converts MAPI and CAPI machine sets in ConversionReview requests.
+type Server struct {
+	// Addr is the address to listen on, e.g. :9443.
+	Addr string
+
+	// CertFile and KeyFile hold the PEM encoded serving certificate and key.
+	CertFile string
+	KeyFile  string
+
+	// Converter holds the conversion options, it is copied for every object.
+	// Its input files are ignored.
+	Converter converter.AWSConverter
+
+	// MachineTemplate resolves the infrastructure templates of CAPI machine sets.
+	// When nil, CAPI machine sets can't be converted to MAPI.
+	MachineTemplate MachineTemplateGetter
+
+	ready int32
+}
+
+// Handler returns the conversion, health and readiness endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ConvertPath, s.serveConvert)
+	mux.HandleFunc(HealthzPath, serveHealthz)
+	mux.HandleFunc(ReadyzPath, s.serveReadyz)
+	return mux
+}
+
+// ListenAndServe serves TLS on Addr until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", s.Addr, err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves TLS on the listener until ctx is done. The server reports ready
+// once the certificate is loaded.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	certificate, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("error loading serving certificate: %v", err)
+	}
+
+	server := &http.Server{
+		Handler: s.Handler(),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		atomic.StoreInt32(&s.ready, 0)
+		server.Close()
+	}()
+
+	atomic.StoreInt32(&s.ready, 1)
+	if err := server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) serveReadyz(w http.ResponseWriter, _ *http.Request) {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) serveConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &apiextensionsv1.ConversionReview{}
+	if err := json.Unmarshal(body, review); err != nil {
+		http.Error(w, fmt.Sprintf("error unmarshalling conversion review: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "conversion review has no request", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = s.convert(review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		http.Error(w, fmt.Sprintf("error marshalling conversion review: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// convert converts every object of the request. A single failure fails the
+// whole request, as the API server requires.
+func (s *Server) convert(request *apiextensionsv1.ConversionRequest) *apiextensionsv1.ConversionResponse {
+	response := &apiextensionsv1.ConversionResponse{
+		UID:              request.UID,
+		ConvertedObjects: []runtime.RawExtension{},
+		Result:           metav1.Status{Status: metav1.StatusSuccess},
+	}
+
+	for i, object := range request.Objects {
+		converted, err := s.convertObject(object.Raw, request.DesiredAPIVersion)
+		if err != nil {
+			return &apiextensionsv1.ConversionResponse{
+				UID: request.UID,
+				Result: metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: fmt.Sprintf("error converting object %d: %v", i, err),
+				},
+			}
+		}
+		response.ConvertedObjects = append(response.ConvertedObjects, runtime.RawExtension{Raw: converted})
+	}
+
+	return response
+}
+
+func (s *Server) convertObject(raw []byte, desiredAPIVersion string) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, fmt.Errorf("error unmarshalling object: %v", err)
+	}
+	if typeMeta.APIVersion == desiredAPIVersion {
+		return raw, nil
+	}
+
+	awsConverter := s.Converter
+	awsConverter.MachineSetFile = raw
+	awsConverter.MachineTemplateFile = nil
+
+	var out [][]byte
+	var err error
+	switch {
+	case typeMeta.Kind == machineSetKind && typeMeta.APIVersion == mapi.GroupVersion.String():
+		out, err = awsConverter.ToCAPI()
+	case typeMeta.Kind == machineSetKind && typeMeta.APIVersion == capi.GroupVersion.String():
+		awsConverter.MachineTemplateFile, err = s.machineTemplate(raw)
+		if err != nil {
+			return nil, err
+		}
+		out, err = awsConverter.ToMAPI()
+	default:
+		return nil, fmt.Errorf("can't convert %s %s", typeMeta.APIVersion, typeMeta.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range out {
+		convertedTypeMeta := metav1.TypeMeta{}
+		if err := yaml.Unmarshal(object, &convertedTypeMeta); err != nil {
+			return nil, fmt.Errorf("error unmarshalling converted object: %v", err)
+		}
+		if convertedTypeMeta.APIVersion != desiredAPIVersion {
+			continue
+		}
+		if convertedTypeMeta.Kind != machineSetKind && convertedTypeMeta.Kind != awsMachineTemplateKind {
+			continue
+		}
+
+		converted, err := yaml.YAMLToJSON(object)
+		if err != nil {
+			return nil, fmt.Errorf("error converting object to json: %v", err)
+		}
+		return preserveMetadata(raw, converted)
+	}
+
+	return nil, fmt.Errorf("can't convert %s %s to %s", typeMeta.APIVersion, typeMeta.Kind, desiredAPIVersion)
+}
+
+func (s *Server) machineTemplate(raw []byte) ([]byte, error) {
+	machineSet := &capi.MachineSet{}
+	if err := json.Unmarshal(raw, machineSet); err != nil {
+		return nil, fmt.Errorf("error unmarshalling machineset: %v", err)
+	}
+	if s.MachineTemplate == nil {
+		return nil, fmt.Errorf("machineset %s references machine template %s, but no machine templates are configured", machineSet.Name, machineSet.Spec.Template.Spec.InfrastructureRef.Name)
+	}
+
+	namespace := machineSet.Spec.Template.Spec.InfrastructureRef.Namespace
+	if namespace == "" {
+		namespace = machineSet.Namespace
+	}
+	return s.MachineTemplate(namespace, machineSet.Spec.Template.Spec.InfrastructureRef.Name)
+}
+
+// preserveMetadata keeps the metadata of the original object. The API server
+// rejects conversions that change anything but labels and annotations.
+func preserveMetadata(original, converted []byte) ([]byte, error) {
+	originalObject := map[string]interface{}{}
+	if err := json.Unmarshal(original, &originalObject); err != nil {
+		return nil, fmt.Errorf("error unmarshalling object: %v", err)
+	}
+	convertedObject := map[string]interface{}{}
+	if err := json.Unmarshal(converted, &convertedObject); err != nil {
+		return nil, fmt.Errorf("error unmarshalling converted object: %v", err)
+	}
+
+	metadata, _ := originalObject["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	convertedMetadata, _ := convertedObject["metadata"].(map[string]interface{})
+	for _, key := range []string{"labels", "annotations"} {
+		if value, ok := convertedMetadata[key]; ok {
+			metadata[key] = value
+		} else {
+			delete(metadata, key)
+		}
+	}
+	convertedObject["metadata"] = metadata
+
+	return json.Marshal(convertedObject)
+}
@@ -0,0 +1,289 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/capi"
+	"github.com/cloud-team-poc/mapi-capi-static-converter/pkg/mapi"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const testMAPIMachineSet = `apiVersion: machine.openshift.io/v1beta1
+kind: MachineSet
+metadata:
+  name: worker-us-east-1a
+  namespace: openshift-machine-api
+  uid: 6b1a3f3e-2c44-4d0a-9d52-2f0f8c1f6c11
+  resourceVersion: "4242"
+  labels:
+    machine.openshift.io/cluster-api-cluster: cluster
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      machine.openshift.io/cluster-api-cluster: cluster
+  template:
+    metadata:
+      labels:
+        machine.openshift.io/cluster-api-cluster: cluster
+    spec:
+      providerSpec:
+        value:
+          ami:
+            id: ami-0123
+          instanceType: m5.large
+          placement:
+            availabilityZone: us-east-1a
+            region: us-east-1
+`
+
+const testCAPIMachineSet = `apiVersion: cluster.x-k8s.io/v1alpha4
+kind: MachineSet
+metadata:
+  name: worker-us-east-1a
+  namespace: openshift-cluster-api
+  uid: 0d7e52a6-0b4b-4d3c-8d1f-7a5e0f2b9c33
+spec:
+  clusterName: cluster
+  replicas: 2
+  template:
+    spec:
+      clusterName: cluster
+      bootstrap:
+        dataSecretName: worker-user-data
+      infrastructureRef:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+        kind: AWSMachineTemplate
+        name: worker-us-east-1a
+`
+
+const testAWSMachineTemplate = `apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+kind: AWSMachineTemplate
+metadata:
+  name: worker-us-east-1a
+  namespace: openshift-cluster-api
+spec:
+  template:
+    spec:
+      ami:
+        id: ami-0123
+      instanceType: m5.large
+      subnet:
+        id: subnet-0123
+`
+
+func conversionReview(g *WithT, desiredAPIVersion string, objects ...string) []byte {
+	review := &apiextensionsv1.ConversionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiextensionsv1.SchemeGroupVersion.String(),
+			Kind:       "ConversionReview",
+		},
+		Request: &apiextensionsv1.ConversionRequest{
+			UID:               types.UID("705ab4f5-6393-11e8-b7cc-42010a800002"),
+			DesiredAPIVersion: desiredAPIVersion,
+		},
+	}
+	for _, object := range objects {
+		raw, err := yaml.YAMLToJSON([]byte(object))
+		g.Expect(err).NotTo(HaveOccurred())
+		review.Request.Objects = append(review.Request.Objects, runtime.RawExtension{Raw: raw})
+	}
+
+	body, err := json.Marshal(review)
+	g.Expect(err).NotTo(HaveOccurred())
+	return body
+}
+
+func postConversionReview(g *WithT, url string, body []byte) *apiextensionsv1.ConversionReview {
+	resp, err := http.Post(url+ConvertPath, "application/json", bytes.NewReader(body))
+	g.Expect(err).NotTo(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	review := &apiextensionsv1.ConversionReview{}
+	g.Expect(json.NewDecoder(resp.Body).Decode(review)).To(Succeed())
+	g.Expect(review.Response).NotTo(BeNil())
+	g.Expect(review.Response.UID).To(Equal(types.UID("705ab4f5-6393-11e8-b7cc-42010a800002")))
+	return review
+}
+
+func TestConvertToCAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer((&Server{}).Handler())
+	defer server.Close()
+
+	review := postConversionReview(g, server.URL, conversionReview(g, capi.GroupVersion.String(), testMAPIMachineSet))
+	g.Expect(review.Response.Result.Status).To(Equal(metav1.StatusSuccess))
+	g.Expect(review.Response.ConvertedObjects).To(HaveLen(1))
+
+	machineSet := &capi.MachineSet{}
+	g.Expect(json.Unmarshal(review.Response.ConvertedObjects[0].Raw, machineSet)).To(Succeed())
+	g.Expect(machineSet.APIVersion).To(Equal(capi.GroupVersion.String()))
+	g.Expect(machineSet.Kind).To(Equal(machineSetKind))
+	g.Expect(machineSet.UID).To(Equal(types.UID("6b1a3f3e-2c44-4d0a-9d52-2f0f8c1f6c11")))
+	g.Expect(machineSet.ResourceVersion).To(Equal("4242"))
+	g.Expect(machineSet.Labels).To(HaveKeyWithValue("cluster.x-k8s.io/cluster-name", "cluster"))
+	g.Expect(machineSet.Spec.ClusterName).To(Equal("cluster"))
+
+	review = postConversionReview(g, server.URL, conversionReview(g, capi.InfrastructureGroupVersion.String(), testMAPIMachineSet))
+	g.Expect(review.Response.Result.Status).To(Equal(metav1.StatusSuccess))
+
+	awsMachineTemplate := &capi.AWSMachineTemplate{}
+	g.Expect(json.Unmarshal(review.Response.ConvertedObjects[0].Raw, awsMachineTemplate)).To(Succeed())
+	g.Expect(awsMachineTemplate.Kind).To(Equal(awsMachineTemplateKind))
+	g.Expect(awsMachineTemplate.Spec.Template.Spec.InstanceType).To(Equal("m5.large"))
+}
+
+func TestConvertToMAPI(t *testing.T) {
+	g := NewWithT(t)
+
+	var requested types.NamespacedName
+	server := httptest.NewServer((&Server{
+		MachineTemplate: func(namespace, name string) ([]byte, error) {
+			requested = types.NamespacedName{Namespace: namespace, Name: name}
+			return []byte(testAWSMachineTemplate), nil
+		},
+	}).Handler())
+	defer server.Close()
+
+	review := postConversionReview(g, server.URL, conversionReview(g, mapi.GroupVersion.String(), testCAPIMachineSet))
+	g.Expect(review.Response.Result.Status).To(Equal(metav1.StatusSuccess), review.Response.Result.Message)
+	g.Expect(requested).To(Equal(types.NamespacedName{Namespace: "openshift-cluster-api", Name: "worker-us-east-1a"}))
+
+	machineSet := &mapi.MachineSet{}
+	g.Expect(json.Unmarshal(review.Response.ConvertedObjects[0].Raw, machineSet)).To(Succeed())
+	g.Expect(machineSet.APIVersion).To(Equal(mapi.GroupVersion.String()))
+	g.Expect(machineSet.Namespace).To(Equal("openshift-cluster-api"))
+
+	providerConfig, err := mapi.ProviderSpecFromRawExtension(machineSet.Spec.Template.Spec.ProviderSpec.Value)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(providerConfig.InstanceType).To(Equal("m5.large"))
+}
+
+func TestConvertFailures(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer((&Server{}).Handler())
+	defer server.Close()
+
+	// Without machine templates CAPI machine sets can't be converted.
+	review := postConversionReview(g, server.URL, conversionReview(g, mapi.GroupVersion.String(), testMAPIMachineSet, testCAPIMachineSet))
+	g.Expect(review.Response.Result.Status).To(Equal(metav1.StatusFailure))
+	g.Expect(review.Response.Result.Message).To(ContainSubstring("error converting object 1"))
+	g.Expect(review.Response.ConvertedObjects).To(BeEmpty())
+
+	review = postConversionReview(g, server.URL, conversionReview(g, "machine.openshift.io/v1", testMAPIMachineSet))
+	g.Expect(review.Response.Result.Status).To(Equal(metav1.StatusFailure))
+
+	resp, err := http.Post(server.URL+ConvertPath, "application/json", bytes.NewReader([]byte(`{"kind":"ConversionReview"}`)))
+	g.Expect(err).NotTo(HaveOccurred())
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+	resp, err = http.Get(server.URL + ConvertPath)
+	g.Expect(err).NotTo(HaveOccurred())
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+}
+
+func TestServeTLS(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	server := &Server{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+	}
+	writeTestCertificate(g, server.CertFile, server.KeyFile)
+
+	// Readiness fails until the certificate is loaded.
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadyzPath, nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(ctx, listener)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	url := fmt.Sprintf("https://%s", listener.Addr())
+	for _, path := range []string{HealthzPath, ReadyzPath} {
+		g.Eventually(func() (int, error) {
+			resp, err := client.Get(url + path)
+			if err != nil {
+				return 0, err
+			}
+			resp.Body.Close()
+			return resp.StatusCode, nil
+		}, 5*time.Second).Should(Equal(http.StatusOK))
+	}
+
+	cancel()
+	g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+}
+
+func TestServeMissingCertificate(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	server := &Server{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(server.Serve(context.Background(), listener)).To(MatchError(ContainSubstring("error loading serving certificate")))
+}
+
+func writeTestCertificate(g *WithT, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mapi-capi-converter"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600)).To(Succeed())
+	g.Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)).To(Succeed())
+}